@@ -1,25 +1,44 @@
 package tish
 
 import (
-	"context"
+	"errors"
 	"flag"
 	"fmt"
-	// "io"
+	"io"
 	// "os"
+	"os/exec"
+	"path/filepath"
 	"plugin"
+	"sort"
 	"strconv"
 	"strings"
+
+	"github.com/midbel/tish/internal/parser"
+	"github.com/midbel/tish/internal/token"
+	"github.com/midbel/tish/internal/words"
 )
 
 var builtins = map[string]Builtin{
 	"set": {
-		Usage:   "set",
+		Usage:   "set [-+ux] [-+o option] [--] [arg...]",
 		Short:   "set specific shell option",
 		Help:    "",
-		Execute: nil,
+		Execute: runSet,
+	},
+	"shopt": {
+		Usage:   "shopt [-pqsu] [optname...]",
+		Short:   "set and unset shell options",
+		Help:    "",
+		Execute: runShopt,
+	},
+	"unset": {
+		Usage:   "unset [-v|-f] name...",
+		Short:   "unset values and attributes of variables and functions",
+		Help:    "",
+		Execute: runUnset,
 	},
 	"echo": {
-		Usage:   "echo",
+		Usage:   "echo [-neE] [-d delim] [arg...]",
 		Short:   "echo the string(s) to standard output",
 		Help:    "",
 		Execute: runEcho,
@@ -42,6 +61,18 @@ var builtins = map[string]Builtin{
 		Help:    "",
 		Execute: runBuiltins,
 	},
+	"test": {
+		Usage:   "test [expr]",
+		Short:   "evaluate conditional expression",
+		Help:    "",
+		Execute: runTest,
+	},
+	"[": {
+		Usage:   "[ [expr] ]",
+		Short:   "evaluate conditional expression",
+		Help:    "",
+		Execute: runTest,
+	},
 	"true": {
 		Usage:   "true",
 		Short:   "always return a successful result",
@@ -61,7 +92,7 @@ var builtins = map[string]Builtin{
 		Execute: runBuiltin,
 	},
 	"command": {
-		Usage:   "command",
+		Usage:   "command [-p] [-v|-V] name [arg...]",
 		Short:   "execute a simple command or display information about commands",
 		Help:    "",
 		Execute: runCommand,
@@ -78,12 +109,6 @@ var builtins = map[string]Builtin{
 		Help:    "",
 		Execute: runType,
 	},
-	"env": {
-		Usage:   "env",
-		Short:   "display list of variables exported to environment of commands to be executed",
-		Help:    "",
-		Execute: runEnv,
-	},
 	"export": {
 		Usage:   "export [name[=value]]...",
 		Short:   "mark variables to export in environment of commands to be executed",
@@ -109,7 +134,7 @@ var builtins = map[string]Builtin{
 		Execute: runUnalias,
 	},
 	"cd": {
-		Usage:   "cd <dir>",
+		Usage:   "cd [-L|-P] [dir]",
 		Short:   "change the shell working directory",
 		Help:    "",
 		Execute: runChdir,
@@ -121,29 +146,71 @@ var builtins = map[string]Builtin{
 		Execute: runPwd,
 	},
 	"popd": {
-		Usage:   "popd",
-		Short:   "",
+		Usage:   "popd [-n] [+N | -N]",
+		Short:   "remove directories from the directory stack",
 		Help:    "",
 		Execute: runPopd,
 	},
 	"pushd": {
-		Usage:   "pushd",
-		Short:   "",
+		Usage:   "pushd [-n] [+N | -N | dir]",
+		Short:   "add directories to the directory stack",
 		Help:    "",
 		Execute: runPushd,
 	},
 	"dirs": {
-		Usage:   "dirs",
-		Short:   "",
+		Usage:   "dirs [-clpv] [+N | -N]",
+		Short:   "display the directory stack",
 		Help:    "",
 		Execute: runDirs,
 	},
 	"readonly": {
-		Usage:   "readonly <name>",
-		Short:   "mark and unmark shell variables as readonly",
+		Usage:   "readonly [-p] [name[=value]]...",
+		Short:   "mark shell variables as readonly",
 		Help:    "",
 		Execute: runReadOnly,
 	},
+	"declare": {
+		Usage:   "declare [-p] [-+ilnrux] [name[=value]]...",
+		Short:   "set values and attributes of shell variables",
+		Help:    "",
+		Execute: runDeclare,
+	},
+	"typeset": {
+		Usage:   "typeset [-p] [-+ilnrux] [name[=value]]...",
+		Short:   "set values and attributes of shell variables",
+		Help:    "",
+		Execute: runDeclare,
+	},
+	"return": {
+		Usage:   "return [n]",
+		Short:   "return from a shell function",
+		Help:    "",
+		Execute: runReturn,
+	},
+	"exec": {
+		Usage:   "exec [command [arg...]]",
+		Short:   "replace the shell with the given command",
+		Help:    "",
+		Execute: runExec,
+	},
+	"let": {
+		Usage:   "let expr...",
+		Short:   "evaluate arithmetic expressions",
+		Help:    "",
+		Execute: runLet,
+	},
+	"shift": {
+		Usage:   "shift [n]",
+		Short:   "shift positional parameters",
+		Help:    "",
+		Execute: runShift,
+	},
+	"getopts": {
+		Usage:   "getopts optstring name [arg...]",
+		Short:   "parse option arguments",
+		Help:    "",
+		Execute: runGetopts,
+	},
 	"exit": {
 		Usage:   "exit [code]",
 		Short:   "exit the shell",
@@ -158,21 +225,103 @@ var builtins = map[string]Builtin{
 	},
 }
 
+// builtins running commands in a subshell refer indirectly to builtins and are
+// registered once it is initialized
+func init() {
+	builtins["env"] = Builtin{
+		Usage:   "env [-i] [-0] [-u name]... [name=value]... [command [arg...]]",
+		Short:   "display or run a command in a modified environment",
+		Help:    "",
+		Execute: runEnv,
+	}
+	builtins["eval"] = Builtin{
+		Usage:   "eval [arg...]",
+		Short:   "execute arguments as a shell command",
+		Help:    "",
+		Execute: runEval,
+	}
+}
+
 func runEcho(b Builtin) error {
 	var (
-		set   flag.FlagSet
-		delim = set.String("d", " ", "strings delimiter")
+		args    = b.Args
+		delim   = " "
+		newline = true
+		escape  bool
 	)
-	if err := set.Parse(b.Args); err != nil {
-		return err
+	for len(args) > 0 {
+		if args[0] == "-d" && len(args) > 1 {
+			delim, args = args[1], args[2:]
+			continue
+		}
+		if !isEchoOption(args[0]) {
+			break
+		}
+		for _, c := range args[0][1:] {
+			switch c {
+			case 'n':
+				newline = false
+			case 'e':
+				escape = true
+			case 'E':
+				escape = false
+			}
+		}
+		args = args[1:]
 	}
-	for i, a := range set.Args() {
+	for i, a := range args {
 		if i > 0 {
-			fmt.Fprint(b.Stdout, *delim)
+			fmt.Fprint(b.Stdout, delim)
+		}
+		if escape {
+			str, stop := words.Unescape(a)
+			fmt.Fprint(b.Stdout, str)
+			if stop {
+				return nil
+			}
+			continue
 		}
 		fmt.Fprint(b.Stdout, a)
 	}
-	fmt.Fprintln(b.Stdout)
+	if newline {
+		fmt.Fprintln(b.Stdout)
+	}
+	return nil
+}
+
+// isEchoOption reports whether str is made only of options of echo. Any other
+// string starting with a dash is printed as is
+func isEchoOption(str string) bool {
+	if len(str) < 2 || str[0] != '-' {
+		return false
+	}
+	return strings.Trim(str[1:], "neE") == ""
+}
+
+func runTest(b Builtin) error {
+	args := b.Args
+	if b.Name() == "[" {
+		if len(args) == 0 || args[len(args)-1] != "]" {
+			fmt.Fprintln(b.Stderr, "[: missing ']'")
+			return ExitCode(2)
+		}
+		args = args[:len(args)-1]
+	}
+	t, err := parseTest(args)
+	if err != nil {
+		fmt.Fprintf(b.Stderr, "%s: %s", b.Name(), err)
+		fmt.Fprintln(b.Stderr)
+		return ExitCode(2)
+	}
+	ok, err := t.Test(b.shell)
+	if err != nil {
+		fmt.Fprintf(b.Stderr, "%s: %s", b.Name(), err)
+		fmt.Fprintln(b.Stderr)
+		return ExitCode(2)
+	}
+	if !ok {
+		return Failure
+	}
 	return nil
 }
 
@@ -239,6 +388,7 @@ func runBuiltin(b Builtin) error {
 		other.Args = append(other.Args, set.Arg(i))
 	}
 	other.shell = b.shell
+	other.ctx = b.ctx
 	other.Stdout = b.Stdout
 	other.Stderr = b.Stderr
 	other.Stdin = b.Stdin
@@ -247,10 +397,191 @@ func runBuiltin(b Builtin) error {
 }
 
 func runCommand(b Builtin) error {
-	var set flag.FlagSet
-	if err := set.Parse(b.Args); err != nil {
+	var (
+		std      bool
+		describe bool
+		verbose  bool
+		args     = b.Args
+	)
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' {
+		a := args[0]
+		args = args[1:]
+		if a == "--" {
+			break
+		}
+		for _, c := range a[1:] {
+			switch c {
+			case 'p':
+				std = true
+			case 'v':
+				describe = true
+			case 'V':
+				verbose = true
+			default:
+				fmt.Fprintf(b.Stderr, "%s: %s: invalid option", b.Name(), a)
+				fmt.Fprintln(b.Stderr)
+				return ExitCode(2)
+			}
+		}
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	var path string
+	if std {
+		path = defaultPath
+	}
+	if describe || verbose {
+		var code ExitCode
+		for _, a := range args {
+			if err := describeCommand(b, a, path, verbose); err != nil {
+				if verbose {
+					fmt.Fprintf(b.Stderr, "%s: %s", b.Name(), err)
+					fmt.Fprintln(b.Stderr)
+				}
+				code = Failure
+			}
+		}
+		if code.Failure() {
+			return code
+		}
+		return nil
+	}
+	if other, ok := b.shell.builtins[args[0]]; (!ok || !other.IsEnabled()) && std {
+		file, err := b.shell.lookPath(args[0], path)
+		if err != nil {
+			fmt.Fprintf(b.Stderr, "%s: %s", b.Name(), err)
+			fmt.Fprintln(b.Stderr)
+			return ExitCode(127)
+		}
+		args = append([]string{file}, args[1:]...)
+	}
+	return runNested(b, b.shell.findCommand(b.ctx, args))
+}
+
+// describeCommand prints how the shell resolves name. With verbose, the kind of
+// command is given in a sentence
+func describeCommand(b Builtin, name, path string, verbose bool) error {
+	var (
+		sh   = b.shell
+		desc string
+	)
+	if alias, ok := sh.alias[name]; ok {
+		str := strings.Join(alias, " ")
+		if verbose {
+			desc = fmt.Sprintf("%s is aliased to `%s'", name, str)
+		} else {
+			desc = fmt.Sprintf("alias %s=%s", name, words.Quote(str))
+		}
+	} else if token.IsKeyword(name) {
+		desc = describeKind(name, "a shell keyword", verbose)
+	} else if _, ok := sh.functions[name]; ok {
+		desc = describeKind(name, "a function", verbose)
+	} else if other, ok := sh.builtins[name]; ok && other.IsEnabled() {
+		desc = describeKind(name, "a shell builtin", verbose)
+	} else if _, err := sh.Find(b.ctx, name); err == nil {
+		desc = describeKind(name, "a user command", verbose)
+	} else {
+		file, err := sh.lookPath(name, path)
+		if err != nil {
+			return err
+		}
+		desc = file
+		if verbose {
+			desc = fmt.Sprintf("%s is %s", name, file)
+		}
+	}
+	fmt.Fprintln(b.Stdout, desc)
+	return nil
+}
+
+func describeKind(name, kind string, verbose bool) string {
+	if !verbose {
+		return name
+	}
+	return fmt.Sprintf("%s is %s", name, kind)
+}
+
+// runNested runs cmd on behalf of b with its standard streams. The exit code of
+// cmd becomes the exit code of b
+func runNested(b Builtin, cmd Command) error {
+	cmd.SetIn(b.Stdin)
+	cmd.SetOut(b.Stdout)
+	cmd.SetErr(b.Stderr)
+
+	err := cmd.Run()
+	if errors.Is(err, ErrExec) {
 		return err
 	}
+	if _, ok := cmd.(*stdCommand); ok && err != nil && !errors.As(err, new(*exec.ExitError)) {
+		fmt.Fprintf(b.Stderr, "%s: %s", b.Name(), err)
+		fmt.Fprintln(b.Stderr)
+		return ExitCode(127)
+	}
+	if _, code := cmd.Exit(); code != 0 {
+		return ExitCode(code)
+	}
+	return nil
+}
+
+func runEval(b Builtin) error {
+	var (
+		list words.ExecList
+		p    = parser.NewParser(strings.NewReader(strings.Join(b.Args, " ")))
+	)
+	for {
+		ex, err := p.Parse()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			fmt.Fprintf(b.Stderr, "%s: %s", b.Name(), err)
+			fmt.Fprintln(b.Stderr)
+			return ExitCode(2)
+		}
+		list = append(list, ex)
+	}
+	if len(list) == 0 {
+		return nil
+	}
+	return runNested(b, createScript(b.ctx, b.shell, list))
+}
+
+func runExec(b Builtin) error {
+	args := b.Args
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		b.shell.rebind(b.Stdin, b.Stdout, b.Stderr)
+		return nil
+	}
+	file, err := b.shell.lookPath(args[0], "")
+	if err != nil {
+		fmt.Fprintf(b.Stderr, "%s: %s", b.Name(), err)
+		fmt.Fprintln(b.Stderr)
+		return ExitCode(127)
+	}
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(b.shell.Cwd(), file)
+	}
+	env := b.shell.environ()
+	if b.shell.exec == nil {
+		return ExecError{
+			Path:   file,
+			Dir:    b.shell.Cwd(),
+			Args:   args,
+			Env:    env,
+			Stdin:  b.Stdin,
+			Stdout: b.Stdout,
+			Stderr: b.Stderr,
+		}
+	}
+	if err := b.shell.exec(file, args, env, b.Stdin, b.Stdout, b.Stderr); err != nil {
+		fmt.Fprintf(b.Stderr, "%s: %s: %s", b.Name(), args[0], err)
+		fmt.Fprintln(b.Stderr)
+		return ExitCode(126)
+	}
 	return nil
 }
 
@@ -261,9 +592,11 @@ func runType(b Builtin) error {
 	}
 	for _, a := range set.Args() {
 		var kind string
-		if _, ok := b.shell.builtins[a]; ok {
+		if _, ok := b.shell.functions[a]; ok {
+			kind = "function"
+		} else if _, ok := b.shell.builtins[a]; ok {
 			kind = "builtin"
-		} else if _, err := b.shell.Find(context.TODO(), a); err == nil {
+		} else if _, err := b.shell.Find(b.ctx, a); err == nil {
 			kind = "user command"
 		} else if _, ok := b.shell.alias[a]; ok {
 			kind = "alias"
@@ -414,21 +747,497 @@ func printEnableBuiltins(b Builtin) {
 }
 
 func runReadOnly(b Builtin) error {
-	var set flag.FlagSet
+	var (
+		set   flag.FlagSet
+		print = set.Bool("p", false, "print readonly variables")
+	)
 	if err := set.Parse(b.Args); err != nil {
 		return err
 	}
+	if *print || set.NArg() == 0 {
+		return printDeclare(b, set.Args(), AttrReadOnly)
+	}
+	var code ExitCode
+	for _, a := range set.Args() {
+		if err := declareVariable(b.shell, a, AttrReadOnly, 0); err != nil {
+			fmt.Fprintf(b.Stderr, "readonly: %s", err)
+			fmt.Fprintln(b.Stderr)
+			code = Failure
+		}
+	}
+	if code.Failure() {
+		return code
+	}
 	return nil
 }
 
-func runEnv(b Builtin) error {
-	for n, v := range b.shell.env {
-		fmt.Fprintf(b.Stdout, "%-10s = %s", n, v)
+var declareFlags = map[rune]Attribute{
+	'i': AttrInteger,
+	'l': AttrLower,
+	'n': AttrRef,
+	'r': AttrReadOnly,
+	'u': AttrUpper,
+	'x': AttrExport,
+}
+
+func runDeclare(b Builtin) error {
+	var (
+		set   Attribute
+		unset Attribute
+		print bool
+		args  = b.Args
+	)
+	for len(args) > 0 {
+		a := args[0]
+		if a == "--" {
+			args = args[1:]
+			break
+		}
+		if len(a) < 2 || (a[0] != '-' && a[0] != '+') {
+			break
+		}
+		for _, c := range a[1:] {
+			if c == 'p' && a[0] == '-' {
+				print = true
+				continue
+			}
+			attr, ok := declareFlags[c]
+			if !ok {
+				fmt.Fprintf(b.Stderr, "%s: %s: invalid option", b.Name(), a)
+				fmt.Fprintln(b.Stderr)
+				return Failure
+			}
+			if a[0] == '-' {
+				set |= attr
+			} else {
+				unset |= attr
+			}
+		}
+		args = args[1:]
+	}
+	if print || len(args) == 0 {
+		return printDeclare(b, args, set)
+	}
+	var code ExitCode
+	for _, a := range args {
+		if err := declareVariable(b.shell, a, set, unset); err != nil {
+			fmt.Fprintf(b.Stderr, "%s: %s", b.Name(), err)
+			fmt.Fprintln(b.Stderr)
+			code = Failure
+		}
+	}
+	if code.Failure() {
+		return code
+	}
+	return nil
+}
+
+func declareVariable(sh *Shell, str string, set, unset Attribute) error {
+	ident, value, ok := strings.Cut(str, "=")
+	if !ok {
+		// a variable of the environment keeps its value once declared
+		if v, found := sh.env[ident]; found {
+			if _, err := sh.locals.Resolve(ident); err != nil {
+				value, ok = v, true
+			}
+		}
+	}
+	attr, _ := sh.Attributes(ident)
+	attr = (attr | set) &^ unset
+	if set&AttrLower != 0 {
+		attr &^= AttrUpper
+	}
+	if set&AttrUpper != 0 {
+		attr &^= AttrLower
+	}
+	if ok {
+		if err := sh.SetAttributes(ident, attr&^(AttrReadOnly|AttrRef)); err != nil {
+			return err
+		}
+		if err := sh.Define(ident, []string{value}); err != nil {
+			return err
+		}
+	}
+	return sh.SetAttributes(ident, attr)
+}
+
+func printDeclare(b Builtin, names []string, filter Attribute) error {
+	var listed bool
+	if len(names) == 0 {
+		for _, n := range b.shell.Names() {
+			if v, ok := b.shell.listed(n); ok && v.attrs&filter == filter {
+				names = append(names, n)
+			}
+		}
+		listed = true
+	}
+	var code ExitCode
+	for _, n := range names {
+		v, ok := b.shell.lookup(n)
+		if listed {
+			v, ok = b.shell.listed(n)
+		}
+		if !ok {
+			fmt.Fprintf(b.Stderr, "%s: %s: not found", b.Name(), n)
+			fmt.Fprintln(b.Stderr)
+			code = Failure
+			continue
+		}
+		fmt.Fprintln(b.Stdout, formatDeclare(n, v))
+	}
+	if code.Failure() {
+		return code
+	}
+	return nil
+}
+
+// setFlags maps the short options of set to their long name
+var setFlags = map[rune]string{
+	'u': "nounset",
+	'x': "xtrace",
+}
+
+func runSet(b Builtin) error {
+	args := b.Args
+	if len(args) == 0 {
+		for _, n := range b.shell.Names() {
+			vs, _ := b.shell.Resolve(n)
+			fmt.Fprintf(b.Stdout, "%s=%s", n, words.Quote(strings.Join(vs, " ")))
+			fmt.Fprintln(b.Stdout)
+		}
+		return nil
+	}
+	for len(args) > 0 {
+		a := args[0]
+		if a == "--" {
+			b.shell.context.args = append([]string{}, args[1:]...)
+			return nil
+		}
+		if len(a) < 2 || (a[0] != '-' && a[0] != '+') {
+			break
+		}
+		args = args[1:]
+		on := a[0] == '-'
+		for _, c := range a[1:] {
+			name, ok := setFlags[c]
+			if c == 'o' {
+				if len(args) == 0 {
+					printOptions(b)
+					continue
+				}
+				name, ok, args = args[0], true, args[1:]
+			}
+			if !ok {
+				fmt.Fprintf(b.Stderr, "%s: %s: invalid option", b.Name(), a)
+				fmt.Fprintln(b.Stderr)
+				return ExitCode(2)
+			}
+			if err := b.shell.setOption(name, on); err != nil {
+				fmt.Fprintf(b.Stderr, "%s: %s", b.Name(), err)
+				fmt.Fprintln(b.Stderr)
+				return ExitCode(2)
+			}
+		}
+	}
+	if len(args) > 0 {
+		b.shell.context.args = append([]string{}, args...)
+	}
+	return nil
+}
+
+func printOptions(b Builtin) {
+	for _, n := range []string{"nounset", "xtrace"} {
+		state := "off"
+		if on, _ := b.shell.option(n); on {
+			state = "on"
+		}
+		fmt.Fprintf(b.Stdout, "%-15s %s", n, state)
 		fmt.Fprintln(b.Stdout)
 	}
+}
+
+func runShopt(b Builtin) error {
+	var (
+		set   bool
+		unset bool
+		print bool
+		quiet bool
+		args  = b.Args
+	)
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' {
+		a := args[0]
+		args = args[1:]
+		if a == "--" {
+			break
+		}
+		for _, c := range a[1:] {
+			switch c {
+			case 's':
+				set = true
+			case 'u':
+				unset = true
+			case 'p':
+				print = true
+			case 'q':
+				quiet = true
+			default:
+				fmt.Fprintf(b.Stderr, "%s: %s: invalid option", b.Name(), a)
+				fmt.Fprintln(b.Stderr)
+				return ExitCode(2)
+			}
+		}
+	}
+	if set && unset {
+		fmt.Fprintf(b.Stderr, "%s: cannot set and unset shell options simultaneously", b.Name())
+		fmt.Fprintln(b.Stderr)
+		return Failure
+	}
+	show := func(name string, on bool) {
+		if quiet {
+			return
+		}
+		switch {
+		case print && on:
+			fmt.Fprintf(b.Stdout, "%s -s %s", b.Name(), name)
+		case print:
+			fmt.Fprintf(b.Stdout, "%s -u %s", b.Name(), name)
+		case on:
+			fmt.Fprintf(b.Stdout, "%-15s\ton", name)
+		default:
+			fmt.Fprintf(b.Stdout, "%-15s\toff", name)
+		}
+		fmt.Fprintln(b.Stdout)
+	}
+	if len(args) == 0 {
+		for _, n := range shoptNames {
+			on, _ := b.shell.shopt(n)
+			if (set && !on) || (unset && on) {
+				continue
+			}
+			show(n, on)
+		}
+		return nil
+	}
+	var code error
+	for _, n := range args {
+		var (
+			on  bool
+			err error
+		)
+		if set || unset {
+			err = b.shell.setShopt(n, set)
+		} else {
+			on, err = b.shell.shopt(n)
+		}
+		if err != nil {
+			fmt.Fprintf(b.Stderr, "%s: %s", b.Name(), err)
+			fmt.Fprintln(b.Stderr)
+			code = Failure
+			continue
+		}
+		if set || unset {
+			continue
+		}
+		show(n, on)
+		if !on {
+			code = Failure
+		}
+	}
+	return code
+}
+
+func runUnset(b Builtin) error {
+	var (
+		vars  = true
+		funcs = true
+		args  = b.Args
+	)
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' {
+		a := args[0]
+		args = args[1:]
+		if a == "--" {
+			break
+		}
+		for _, c := range a[1:] {
+			switch c {
+			case 'v':
+				vars, funcs = true, false
+			case 'f':
+				vars, funcs = false, true
+			default:
+				fmt.Fprintf(b.Stderr, "%s: %s: invalid option", b.Name(), a)
+				fmt.Fprintln(b.Stderr)
+				return ExitCode(2)
+			}
+		}
+	}
+	var code ExitCode
+	for _, a := range args {
+		var err error
+		switch {
+		case vars && funcs:
+			// without option, a function is only unset when no variable has its name
+			if _, ok := b.shell.functions[a]; ok {
+				if _, err := b.shell.Resolve(a); err != nil {
+					delete(b.shell.functions, a)
+					continue
+				}
+			}
+			err = unsetVariable(b.shell, a)
+		case vars:
+			err = unsetVariable(b.shell, a)
+		default:
+			delete(b.shell.functions, a)
+		}
+		if err != nil {
+			fmt.Fprintf(b.Stderr, "%s: %s", b.Name(), err)
+			fmt.Fprintln(b.Stderr)
+			code = Failure
+		}
+	}
+	if code != Success {
+		return code
+	}
 	return nil
 }
 
+// unsetVariable removes the variable ident from the shell. The form name[index]
+// only removes the element at index, the elements after it moving down.
+func unsetVariable(sh *Shell, ident string) error {
+	x := strings.IndexByte(ident, '[')
+	if x <= 0 || !strings.HasSuffix(ident, "]") {
+		if err := sh.Delete(ident); err != nil {
+			return err
+		}
+		sh.Unexport(ident)
+		return nil
+	}
+	n, err := sh.Evaluate(ident[x+1 : len(ident)-1])
+	if err != nil {
+		return err
+	}
+	ident = ident[:x]
+	values, err := sh.Resolve(ident)
+	if err != nil {
+		return nil
+	}
+	i := int(n)
+	if i < 0 {
+		i += len(values)
+	}
+	if i < 0 || i >= len(values) {
+		return nil
+	}
+	values = append(values[:i], values[i+1:]...)
+	return sh.Define(ident, values)
+}
+
+// formatFlags gives the options of declare setting the given attributes
+func formatFlags(attr Attribute) string {
+	var flags []byte
+	for _, c := range []rune{'i', 'l', 'n', 'r', 'u', 'x'} {
+		if attr&declareFlags[c] != 0 {
+			flags = append(flags, byte(c))
+		}
+	}
+	return string(flags)
+}
+
+// formatDeclare gives the declare command that recreates the given variable
+// with its value(s) and its attributes
+func formatDeclare(ident string, v variable) string {
+	flags := formatFlags(v.attrs)
+	prefix := "--"
+	if len(flags) > 0 {
+		prefix = "-" + flags
+	}
+	if len(v.values) == 0 {
+		if v.values == nil {
+			return fmt.Sprintf("declare %s %s", prefix, ident)
+		}
+		return fmt.Sprintf("declare %s %s=''", prefix, ident)
+	}
+	return fmt.Sprintf("declare %s %s=%s", prefix, ident, words.QuoteValue(strings.Join(v.values, " ")))
+}
+
+func runEnv(b Builtin) error {
+	var (
+		null bool
+		vars = make(map[string]string)
+		args = b.Args
+	)
+	for _, str := range b.shell.environ() {
+		ident, value, _ := strings.Cut(str, "=")
+		vars[ident] = value
+	}
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' {
+		a := args[0]
+		args = args[1:]
+		if a == "--" {
+			break
+		}
+		for i, c := range a[1:] {
+			switch c {
+			case 'i':
+				vars = make(map[string]string)
+			case '0':
+				null = true
+			case 'u':
+				ident := a[i+2:]
+				if ident == "" && len(args) > 0 {
+					ident, args = args[0], args[1:]
+				}
+				if ident == "" {
+					fmt.Fprintf(b.Stderr, "%s: %s: option requires an argument", b.Name(), a)
+					fmt.Fprintln(b.Stderr)
+					return ExitCode(125)
+				}
+				delete(vars, ident)
+			default:
+				fmt.Fprintf(b.Stderr, "%s: %s: invalid option", b.Name(), a)
+				fmt.Fprintln(b.Stderr)
+				return ExitCode(125)
+			}
+			if c == 'u' {
+				break
+			}
+		}
+	}
+	for len(args) > 0 {
+		ident, value, ok := strings.Cut(args[0], "=")
+		if !ok || ident == "" {
+			break
+		}
+		vars[ident] = value
+		args = args[1:]
+	}
+	list := make([]string, 0, len(vars))
+	for n, v := range vars {
+		list = append(list, fmt.Sprintf("%s=%s", n, v))
+	}
+	sort.Strings(list)
+	if len(args) == 0 {
+		for _, v := range list {
+			if null {
+				fmt.Fprintf(b.Stdout, "%s\x00", v)
+			} else {
+				fmt.Fprintln(b.Stdout, v)
+			}
+		}
+		return nil
+	}
+	if null {
+		fmt.Fprintf(b.Stderr, "%s: cannot specify -0 with a command", b.Name())
+		fmt.Fprintln(b.Stderr)
+		return ExitCode(125)
+	}
+	sub, err := b.shell.environShell(list)
+	if err != nil {
+		return err
+	}
+	return runNested(b, sub.resolveCommand(b.ctx, args))
+}
+
 func runExport(b Builtin) error {
 	var (
 		set flag.FlagSet
@@ -437,16 +1246,30 @@ func runExport(b Builtin) error {
 	if err := set.Parse(b.Args); err != nil {
 		return err
 	}
-	for _, k := range set.Args() {
-		if *del {
-			b.shell.Unexport(k)
+	var code ExitCode
+	for _, a := range set.Args() {
+		var err error
+		if _, ok := specials[a]; ok {
+			// the special variables are exported only from the environment
+			if *del {
+				b.shell.Unexport(a)
+			}
 			continue
 		}
-		var v string
-		if x := strings.Index(k, "="); x > 0 {
-			k, v = k[:x], v[x+1:]
+		if *del {
+			b.shell.Unexport(a)
+			err = declareVariable(b.shell, a, 0, AttrExport)
+		} else {
+			err = declareVariable(b.shell, a, AttrExport, 0)
+		}
+		if err != nil {
+			fmt.Fprintf(b.Stderr, "%s: %s", b.Name(), err)
+			fmt.Fprintln(b.Stderr)
+			code = Failure
 		}
-		b.shell.Export(k, v)
+	}
+	if code.Failure() {
+		return code
 	}
 	return nil
 }
@@ -458,7 +1281,7 @@ func runExit(b Builtin) error {
 	}
 	code := ExitCode(b.shell.context.code)
 	if c, err := strconv.Atoi(set.Arg(0)); err == nil {
-		code = ExitCode(c)
+		code = ExitCode(c & 0xFF)
 	}
 	if code.Failure() {
 		return fmt.Errorf("%w: %s", ErrExit, code)
@@ -466,67 +1289,402 @@ func runExit(b Builtin) error {
 	return nil
 }
 
-func runChdir(b Builtin) error {
+func runReturn(b Builtin) error {
+	if b.shell.calls == 0 {
+		fmt.Fprintf(b.Stderr, "%s: can only return from a function", b.Name())
+		fmt.Fprintln(b.Stderr)
+		return Failure
+	}
 	var set flag.FlagSet
 	if err := set.Parse(b.Args); err != nil {
 		return err
 	}
-	if err := b.shell.Chdir(set.Arg(0)); err != nil {
-		fmt.Fprintf(b.Stderr, err.Error())
+	if set.NArg() > 0 {
+		code, err := strconv.Atoi(set.Arg(0))
+		if err != nil {
+			return fmt.Errorf("%s: numeric argument required", set.Arg(0))
+		}
+		b.shell.context.code = code & 0xFF
+	}
+	return ErrReturn
+}
+
+func runLet(b Builtin) error {
+	if len(b.Args) == 0 {
+		fmt.Fprintf(b.Stderr, "%s: expression expected", b.Name())
+		fmt.Fprintln(b.Stderr)
+		return Failure
+	}
+	var (
+		env = getEnvShell(b.shell)
+		ret float64
+	)
+	for _, a := range b.Args {
+		var err error
+		if ret, err = evaluate(env, a); err != nil {
+			fmt.Fprintf(b.Stderr, "%s: %s: %s", b.Name(), a, err)
+			fmt.Fprintln(b.Stderr)
+			return Failure
+		}
+	}
+	if ret == 0 {
+		return Failure
+	}
+	return nil
+}
+
+func runShift(b Builtin) error {
+	n := 1
+	if len(b.Args) > 0 {
+		c, err := strconv.Atoi(b.Args[0])
+		if err != nil {
+			fmt.Fprintf(b.Stderr, "%s: %s: numeric argument required", b.Name(), b.Args[0])
+			fmt.Fprintln(b.Stderr)
+			return ExitCode(2)
+		}
+		n = c
+	}
+	args := b.shell.context.args
+	if n < 0 || n > len(args) {
+		fmt.Fprintf(b.Stderr, "%s: %d: shift count out of range", b.Name(), n)
+		fmt.Fprintln(b.Stderr)
+		return Failure
+	}
+	b.shell.context.args = append(args[:0:0], args[n:]...)
+	return nil
+}
+
+const (
+	varOptInd = "OPTIND"
+	varOptArg = "OPTARG"
+	varOptErr = "OPTERR"
+)
+
+func runGetopts(b Builtin) error {
+	if len(b.Args) < 2 {
+		fmt.Fprintf(b.Stderr, "usage: %s", b.Usage)
+		fmt.Fprintln(b.Stderr)
+		return ExitCode(2)
+	}
+	var (
+		sh     = b.shell
+		spec   = b.Args[0]
+		ident  = b.Args[1]
+		args   = sh.context.args
+		silent = strings.HasPrefix(spec, ":")
+		quiet  = silent
+		index  = 1
+	)
+	if len(b.Args) > 2 {
+		args = b.Args[2:]
+	}
+	if vs, err := sh.Resolve(varOptInd); err == nil && len(vs) == 1 {
+		if n, err := strconv.Atoi(vs[0]); err == nil && n > 0 {
+			index = n
+		}
+	}
+	// OPTERR=0 only suppresses the messages of the non silent mode
+	if vs, err := sh.Resolve(varOptErr); err == nil && len(vs) == 1 && vs[0] == "0" {
+		quiet = true
+	}
+	if sh.getopts.index != index {
+		sh.getopts.index, sh.getopts.pos = index, 1
+	}
+	define := func(ident, value string) error {
+		return sh.Define(ident, []string{value})
+	}
+	if index > len(args) {
+		define(ident, "?")
+		return Failure
+	}
+	arg := args[index-1]
+	if sh.getopts.pos == 1 {
+		if arg == "--" {
+			define(varOptInd, strconv.Itoa(index+1))
+			define(ident, "?")
+			return Failure
+		}
+		if len(arg) < 2 || arg[0] != '-' {
+			define(ident, "?")
+			return Failure
+		}
+	}
+	var (
+		opt = arg[sh.getopts.pos]
+		pos = sh.getopts.pos + 1
+		val string
+	)
+	if pos >= len(arg) {
+		index, pos = index+1, 1
+	}
+	ix := strings.IndexByte(spec, opt)
+	switch {
+	case opt == ':' || ix < 0:
+		if !quiet {
+			fmt.Fprintf(b.Stderr, "%s: illegal option -- %c", b.Name(), opt)
+			fmt.Fprintln(b.Stderr)
+		}
+		val, opt = string(opt), '?'
+	case ix+1 < len(spec) && spec[ix+1] == ':':
+		if pos > 1 {
+			val = arg[pos:]
+			index, pos = index+1, 1
+		} else if index <= len(args) {
+			val = args[index-1]
+			index++
+		} else if silent {
+			val, opt = string(opt), ':'
+		} else {
+			if !quiet {
+				fmt.Fprintf(b.Stderr, "%s: option requires an argument -- %c", b.Name(), opt)
+				fmt.Fprintln(b.Stderr)
+			}
+			opt = '?'
+		}
+	}
+	sh.getopts.index, sh.getopts.pos = index, pos
+	if val == "" || (opt == '?' && !silent) {
+		sh.Delete(varOptArg)
+	} else {
+		define(varOptArg, val)
+	}
+	define(varOptInd, strconv.Itoa(index))
+	if err := define(ident, string(opt)); err != nil {
+		fmt.Fprintf(b.Stderr, "%s: %s: %s", b.Name(), ident, err)
 		fmt.Fprintln(b.Stderr)
+		return Failure
 	}
 	return nil
 }
 
+func runChdir(b Builtin) error {
+	var (
+		physical bool
+		args     = b.Args
+	)
+	for len(args) > 0 {
+		a := args[0]
+		if a == "--" {
+			args = args[1:]
+			break
+		}
+		if len(a) < 2 || a[0] != '-' {
+			break
+		}
+		for _, c := range a[1:] {
+			switch c {
+			case 'L':
+				physical = false
+			case 'P':
+				physical = true
+			default:
+				fmt.Fprintf(b.Stderr, "%s: %s: invalid option", b.Name(), a)
+				fmt.Fprintln(b.Stderr)
+				return Failure
+			}
+		}
+		args = args[1:]
+	}
+	if len(args) > 1 {
+		fmt.Fprintf(b.Stderr, "%s: too many arguments", b.Name())
+		fmt.Fprintln(b.Stderr)
+		return Failure
+	}
+	var (
+		dir   string
+		print bool
+	)
+	if len(args) == 0 {
+		dir = resolveDirVar(b.shell, varHome)
+		if dir == "" {
+			fmt.Fprintf(b.Stderr, "%s: %s not set", b.Name(), varHome)
+			fmt.Fprintln(b.Stderr)
+			return Failure
+		}
+	} else if dir = args[0]; dir == dirOld {
+		dir, print = resolveDirVar(b.shell, varOld), true
+		if dir == "" {
+			fmt.Fprintf(b.Stderr, "%s: %s not set", b.Name(), varOld)
+			fmt.Fprintln(b.Stderr)
+			return Failure
+		}
+	} else if dir != "" {
+		dir, print = b.shell.searchDir(dir)
+	}
+	if err := b.shell.chdir(dir, physical); err != nil {
+		fmt.Fprintf(b.Stderr, "%s: %s", b.Name(), err)
+		fmt.Fprintln(b.Stderr)
+		return Failure
+	}
+	if print {
+		fmt.Fprintln(b.Stdout, b.shell.Cwd())
+	}
+	return nil
+}
+
+func resolveDirVar(sh *Shell, ident string) string {
+	str, err := sh.Resolve(ident)
+	if err != nil || len(str) == 0 {
+		return ""
+	}
+	return str[0]
+}
+
 func runPwd(b Builtin) error {
 	fmt.Fprintln(b.Stdout, b.shell.Cwd())
 	return nil
 }
 
 func runPopd(b Builtin) error {
-	var set flag.FlagSet
-	if err := set.Parse(b.Args); err != nil {
+	keep, args, err := parseStackFlags(b, "n")
+	if err != nil {
 		return err
 	}
-	return b.shell.Popd(set.Arg(0))
+	if len(args) > 1 {
+		fmt.Fprintf(b.Stderr, "%s: too many arguments", b.Name())
+		fmt.Fprintln(b.Stderr)
+		return Failure
+	}
+	var n int
+	if keep['n'] {
+		n = 1
+	}
+	if len(args) == 1 {
+		x, ok := stackIndex(args[0], len(b.shell.Dirs()))
+		if !ok {
+			fmt.Fprintf(b.Stderr, "%s: %s: invalid argument", b.Name(), args[0])
+			fmt.Fprintln(b.Stderr)
+			return Failure
+		}
+		n = x
+	}
+	if err := b.shell.popDirs(n); err != nil {
+		fmt.Fprintf(b.Stderr, "%s: %s", b.Name(), err)
+		fmt.Fprintln(b.Stderr)
+		return Failure
+	}
+	printDirs(b, b.shell.Dirs(), false, false, false)
+	return nil
 }
 
 func runPushd(b Builtin) error {
-	var set flag.FlagSet
-	if err := set.Parse(b.Args); err != nil {
+	keep, args, err := parseStackFlags(b, "n")
+	if err != nil {
 		return err
 	}
-	return b.shell.Pushd(set.Arg(0))
+	if len(args) > 1 {
+		fmt.Fprintf(b.Stderr, "%s: too many arguments", b.Name())
+		fmt.Fprintln(b.Stderr)
+		return Failure
+	}
+	switch {
+	case len(args) == 0:
+		err = b.shell.swapDirs()
+	case keep['n']:
+		var dir string
+		if dir, err = b.shell.resolveDir(args[0], false); err == nil {
+			err = b.shell.InsertDir(1, dir)
+		}
+	default:
+		if n, ok := stackIndex(args[0], len(b.shell.Dirs())); ok {
+			err = b.shell.rotateDirs(n)
+		} else {
+			err = b.shell.Pushd(args[0])
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(b.Stderr, "%s: %s", b.Name(), err)
+		fmt.Fprintln(b.Stderr)
+		return Failure
+	}
+	printDirs(b, b.shell.Dirs(), false, false, false)
+	return nil
 }
 
 func runDirs(b Builtin) error {
-	var (
-		set    flag.FlagSet
-		clear  = set.Bool("c", false, "clear directory stack")
-		line   = set.Bool("p", false, "print one entry per line")
-		prefix = set.Bool("v", false, "print prefix")
-	)
-	if err := set.Parse(b.Args); err != nil {
+	opts, args, err := parseStackFlags(b, "clpv")
+	if err != nil {
 		return err
 	}
-	if *clear {
+	if opts['c'] {
+		b.shell.ClearDirs()
+		return nil
+	}
+	dirs := b.shell.Dirs()
+	if len(args) > 0 {
+		n, ok := stackIndex(args[0], len(dirs))
+		if !ok {
+			fmt.Fprintf(b.Stderr, "%s: %s: invalid argument", b.Name(), args[0])
+			fmt.Fprintln(b.Stderr)
+			return Failure
+		}
+		if n < 0 || n >= len(dirs) {
+			fmt.Fprintf(b.Stderr, "%s: %s: %s", b.Name(), args[0], ErrStackRange)
+			fmt.Fprintln(b.Stderr)
+			return Failure
+		}
+		dirs = dirs[n : n+1]
+	}
+	printDirs(b, dirs, opts['l'], opts['p'], opts['v'])
+	return nil
+}
 
+// parseStackFlags parses the options of the dirs, pushd and popd builtins. The
+// +N and -N arguments are left in the returned arguments.
+func parseStackFlags(b Builtin, accept string) (map[rune]bool, []string, error) {
+	var (
+		opts = make(map[rune]bool)
+		args = b.Args
+	)
+	for len(args) > 0 {
+		a := args[0]
+		if a == "--" {
+			args = args[1:]
+			break
+		}
+		if len(a) < 2 || a[0] != '-' || (a[1] >= '0' && a[1] <= '9') {
+			break
+		}
+		for _, c := range a[1:] {
+			if !strings.ContainsRune(accept, c) {
+				fmt.Fprintf(b.Stderr, "%s: %s: invalid option", b.Name(), a)
+				fmt.Fprintln(b.Stderr)
+				return nil, nil, Failure
+			}
+			opts[c] = true
+		}
+		args = args[1:]
 	}
-	eol := " "
-	if *line || *prefix {
-		eol = "\n"
+	return opts, args, nil
+}
+
+// printDirs prints the entries of the directory stack. Unless long is set, the
+// home directory is replaced by a tilde
+func printDirs(b Builtin, dirs []string, long, line, prefix bool) {
+	var home string
+	if !long {
+		home = resolveDirVar(b.shell, varHome)
 	}
-	for i, d := range b.shell.Dirs() {
-		if i > 0 {
-			fmt.Fprint(b.Stdout, eol)
+	for i, d := range dirs {
+		if home != "" && (d == home || strings.HasPrefix(d, home+"/")) {
+			d = "~" + d[len(home):]
 		}
-		if *prefix {
-			fmt.Fprintf(b.Stdout, "%d ", i+1)
+		switch {
+		case prefix:
+			fmt.Fprintf(b.Stdout, "%2d  %s", i, d)
+			fmt.Fprintln(b.Stdout)
+		case line:
+			fmt.Fprintln(b.Stdout, d)
+		default:
+			if i > 0 {
+				fmt.Fprint(b.Stdout, " ")
+			}
+			fmt.Fprint(b.Stdout, d)
 		}
-		fmt.Fprint(b.Stdout, d)
 	}
-	fmt.Fprintln(b.Stdout)
-	return nil
+	if !line && !prefix {
+		fmt.Fprintln(b.Stdout)
+	}
 }
 
 func runAlias(b Builtin) error {
@@ -543,7 +1701,7 @@ func runAlias(b Builtin) error {
 	for _, k := range set.Args() {
 		var v string
 		if x := strings.Index(k, "="); x > 0 {
-			k, v = k[:x], v[x+1:]
+			k, v = k[:x], k[x+1:]
 		}
 		b.shell.Alias(k, v)
 	}
@@ -0,0 +1,9 @@
+package main
+
+import (
+	"syscall"
+)
+
+func dup(oldfd, newfd int) error {
+	return syscall.Dup2(oldfd, newfd)
+}
@@ -0,0 +1,12 @@
+//go:build !linux && !darwin
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func dup(oldfd, newfd int) error {
+	return fmt.Errorf("duplicating file descriptors not supported on %s", runtime.GOOS)
+}
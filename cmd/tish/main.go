@@ -11,6 +11,7 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"syscall"
 
 	"github.com/midbel/tish"
 	"github.com/midbel/tish/internal/parser"
@@ -86,35 +87,64 @@ func main() {
 	if *echo {
 		options = append(options, tish.WithEcho())
 	}
+	var sh *tish.Shell
+	options = append(options, tish.WithExec(func(file string, args, env []string, stdin io.Reader, stdout, stderr io.Writer) error {
+		if err := os.Chdir(sh.Cwd()); err != nil {
+			return err
+		}
+		if err := redirect(stdin, stdout, stderr); err != nil {
+			return err
+		}
+		return syscall.Exec(file, args, env)
+	}))
 
-	sh, err := tish.New(options...)
+	sh, err = tish.New(options...)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 	var args []string
 	if flag.NArg() > 1 {
-		args = flag.Args()
-		args = args
+		args = flag.Args()[1:]
 	}
 	if *inline {
 		err = sh.Execute(ctx, flag.Arg(0), *name, args)
 	} else {
-		r, err := os.Open(flag.Arg(0))
-		if err != nil {
+		var r *os.File
+		if r, err = os.Open(flag.Arg(0)); err != nil {
 			fmt.Fprintln(os.Stderr, err)
 			os.Exit(2)
 		}
-		defer r.Close()
 		err = sh.Run(ctx, r, filepath.Base(flag.Arg(0)), args)
+		r.Close()
 	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "fail to execute command: %s => %s", flag.Arg(0), err)
 		fmt.Fprintln(os.Stderr)
+		os.Exit(2)
 	}
 	sh.Exit()
 }
 
+// redirect duplicates the files given as standard streams to exec on the
+// descriptors 0, 1 and 2 of the process. Streams that are not files are left
+// unchanged
+func redirect(streams ...interface{}) error {
+	for i, s := range streams {
+		if w, ok := s.(*lockWriter); ok {
+			s = w.Writer
+		}
+		f, ok := s.(interface{ Fd() uintptr })
+		if !ok || int(f.Fd()) == i {
+			continue
+		}
+		if err := dup(int(f.Fd()), i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func parseScript(script string, inline bool) error {
 	var r io.Reader
 	if inline {
@@ -2,11 +2,15 @@ package tish
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"strings"
+	"syscall"
+
+	"github.com/midbel/tish/internal/words"
 )
 
 type CommandFinder interface {
@@ -20,6 +24,7 @@ const (
 	TypeScript
 	TypeExternal
 	TypeRegular
+	TypeFunction
 )
 
 type Command interface {
@@ -62,8 +67,11 @@ func (_ *stdCommand) Type() CommandType {
 	return TypeRegular
 }
 
+// SetEnv replaces the environment of the command. A nil Env would make the
+// command inherit the environment of the process
 func (c *stdCommand) SetEnv(env []string) {
-	c.Cmd.Env = append(c.Cmd.Env[:0], env...)
+	c.Cmd.Env = make([]string, 0, len(env))
+	c.Cmd.Env = append(c.Cmd.Env, env...)
 }
 
 func (c *stdCommand) SetIn(r io.Reader) {
@@ -86,9 +94,177 @@ func (c *stdCommand) Exit() (int, int) {
 		pid  = c.ProcessState.Pid()
 		code = c.ProcessState.ExitCode()
 	)
+	if ws, ok := c.ProcessState.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		code = 128 + int(ws.Signal())
+	}
 	return pid, code
 }
 
+type shellCommand struct {
+	name  string
+	args  []string
+	kind  CommandType
+	ex    words.Executer
+	shell *Shell
+	ctx   context.Context
+
+	finished bool
+	code     int
+	done     chan error
+
+	stdout io.Writer
+	stderr io.Writer
+	stdin  io.Reader
+	closes []io.Closer
+}
+
+func createFunction(ctx context.Context, sh *Shell, name string, args []string, ex words.Executer) Command {
+	return &shellCommand{
+		name:  name,
+		args:  args,
+		kind:  TypeFunction,
+		ex:    ex,
+		shell: sh,
+		ctx:   ctx,
+	}
+}
+
+func createScript(ctx context.Context, sh *Shell, ex words.Executer) Command {
+	return &shellCommand{
+		kind:  TypeScript,
+		ex:    ex,
+		shell: sh,
+		ctx:   ctx,
+	}
+}
+
+func (c *shellCommand) Command() string {
+	return c.name
+}
+
+func (c *shellCommand) Type() CommandType {
+	return c.kind
+}
+
+func (c *shellCommand) SetOut(w io.Writer) {
+	c.stdout = w
+}
+
+func (c *shellCommand) SetErr(w io.Writer) {
+	c.stderr = w
+}
+
+func (c *shellCommand) SetIn(r io.Reader) {
+	c.stdin = r
+}
+
+func (c *shellCommand) StdinPipe() (io.WriteCloser, error) {
+	if c.stdin != nil {
+		return nil, fmt.Errorf("%s: stdin already set", c.name)
+	}
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	c.SetIn(pr)
+	c.closes = append(c.closes, pr)
+	return pw, nil
+}
+
+func (c *shellCommand) StdoutPipe() (io.ReadCloser, error) {
+	if c.stdout != nil {
+		return nil, fmt.Errorf("%s: stdout already set", c.name)
+	}
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	c.SetOut(pw)
+	c.closes = append(c.closes, pw)
+	return pr, nil
+}
+
+func (c *shellCommand) StderrPipe() (io.ReadCloser, error) {
+	if c.stderr != nil {
+		return nil, fmt.Errorf("%s: stderr already set", c.name)
+	}
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	c.SetErr(pw)
+	c.closes = append(c.closes, pw)
+	return pr, nil
+}
+
+func (c *shellCommand) Exit() (int, int) {
+	return 0, c.code
+}
+
+func (c *shellCommand) Start() error {
+	if c.finished {
+		return fmt.Errorf("%s: already executed", c.name)
+	}
+	c.done = make(chan error, 1)
+	go func() {
+		c.done <- c.execute()
+	}()
+	return nil
+}
+
+func (c *shellCommand) Wait() error {
+	if c.finished {
+		return fmt.Errorf("%s: already finished", c.name)
+	}
+	c.finished = true
+	err := <-c.done
+	close(c.done)
+	for _, x := range c.closes {
+		x.Close()
+	}
+	c.closes = c.closes[:0]
+	return err
+}
+
+func (c *shellCommand) Run() error {
+	if err := c.Start(); err != nil {
+		return err
+	}
+	return c.Wait()
+}
+
+func (c *shellCommand) execute() error {
+	sh := c.shell
+	stdin, stdout, stderr := sh.stdin, sh.stdout, sh.stderr
+	defer func() {
+		sh.stdin, sh.stdout, sh.stderr = stdin, stdout, stderr
+	}()
+	if c.stdin != nil {
+		sh.stdin = c.stdin
+	}
+	if c.stdout != nil {
+		sh.stdout = c.stdout
+	}
+	if c.stderr != nil {
+		sh.stderr = c.stderr
+	}
+	if c.kind == TypeFunction {
+		args := sh.context.args
+		defer func() {
+			sh.context.args = args
+			sh.calls--
+		}()
+		sh.context.args = c.args
+		sh.calls++
+	}
+	err := sh.execute(c.ctx, c.ex)
+	if errors.Is(err, ErrReturn) {
+		err = nil
+	}
+	c.code = sh.context.code
+	return err
+}
+
 type Builtin struct {
 	Usage    string
 	Short    string
@@ -98,6 +274,7 @@ type Builtin struct {
 
 	Args     []string
 	shell    *Shell
+	ctx      context.Context
 	finished bool
 	code     int
 	done     chan error
@@ -215,6 +392,10 @@ func (b *Builtin) Wait() error {
 
 	if err != nil {
 		b.code = 1
+		var code ExitCode
+		if errors.As(err, &code) {
+			b.code = int(code)
+		}
 		return err
 	}
 	return nil
@@ -1,8 +1,9 @@
 package tish
 
 import (
+	"errors"
 	"fmt"
-	"os"
+	"io/fs"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -10,13 +11,19 @@ import (
 	"github.com/midbel/tish/internal/stack"
 )
 
+// Stack is the stack of directories of a shell. Its entries are given by their
+// position from the top of the stack, the entry at position 0 being the current
+// working directory.
 type Stack interface {
 	Cwd() string
 	Dirs() []string
 
 	Chdir(string) error
 	Pushd(string) error
-	Popd(string) error
+	Popd(int) error
+	InsertDir(int, string) error
+	RotateDirs(int) error
+	ClearDirs()
 }
 
 const (
@@ -25,6 +32,17 @@ const (
 	dirOld    = "-"
 )
 
+var (
+	ErrStackEmpty = errors.New("directory stack empty")
+	ErrStackRange = errors.New("directory stack index out of range")
+	ErrNoOtherDir = errors.New("no other directory")
+)
+
+const (
+	varCdPath = "CDPATH"
+	maxLinks  = 40
+)
+
 type dirsstack struct {
 	list stack.Stack[string]
 }
@@ -47,69 +65,267 @@ func (d *dirsstack) Dirs() []string {
 	return list
 }
 
+// Chdir replaces the directory at the top of the stack by dir. dir is expected
+// to be already resolved by the caller
 func (d *dirsstack) Chdir(dir string) error {
-	switch dir {
-	case dirCurr:
-	case dirParent:
-		dir = d.list.Curr()
-		d.list.Push(filepath.Dir(dir))
-	case dirOld:
-		d.list.Pop()
-	case "":
-		// back to home dir
-	default:
-		i, err := os.Stat(dir)
-		if err != nil {
+	d.list.Pop()
+	d.list.Push(dir)
+	return nil
+}
+
+// Pushd puts dir at the top of the stack
+func (d *dirsstack) Pushd(dir string) error {
+	d.list.Push(dir)
+	return nil
+}
+
+// Popd removes the entry at position n
+func (d *dirsstack) Popd(n int) error {
+	if d.list.Len() <= 1 {
+		return ErrStackEmpty
+	}
+	x, err := d.index(n)
+	if err == nil {
+		d.list.RemoveRight(x)
+	}
+	return err
+}
+
+// InsertDir puts dir at position n, moving down the entries below it
+func (d *dirsstack) InsertDir(n int, dir string) error {
+	if n < 0 || n > d.list.Len() {
+		return fmt.Errorf("%d: %w", n, ErrStackRange)
+	}
+	d.list.Insert(d.list.Len()-n, dir)
+	return nil
+}
+
+// RotateDirs rotates the stack so that the entry at position n becomes the top
+// of the stack
+func (d *dirsstack) RotateDirs(n int) error {
+	if _, err := d.index(n); err != nil {
+		return err
+	}
+	d.list.RotateRight(n)
+	return nil
+}
+
+// ClearDirs removes all the entries of the stack except the current working
+// directory
+func (d *dirsstack) ClearDirs() {
+	cwd := d.list.Curr()
+	d.list = stack.New[string]()
+	d.list.Push(cwd)
+}
+
+func (d *dirsstack) index(n int) (int, error) {
+	if n < 0 || n >= d.list.Len() {
+		return 0, fmt.Errorf("%d: %w", n, ErrStackRange)
+	}
+	return d.list.Len() - 1 - n, nil
+}
+
+// Chdir changes the working directory of the shell. Relative directories are
+// resolved logically from the current working directory and looked up in the
+// filesystem of the shell
+func (s *Shell) Chdir(dir string) error {
+	return s.chdir(dir, false)
+}
+
+// Pushd puts dir at the top of the directory stack after having resolved it as
+// Chdir does. dir becomes the working directory of the shell.
+func (s *Shell) Pushd(dir string) error {
+	dir, err := s.resolveDir(dir, false)
+	if err != nil {
+		return err
+	}
+	return s.changeTop(func() error {
+		return s.Stack.Pushd(dir)
+	})
+}
+
+// swapDirs exchanges the two directories at the top of the directory stack
+func (s *Shell) swapDirs() error {
+	dirs := s.Dirs()
+	if len(dirs) < 2 {
+		return ErrNoOtherDir
+	}
+	if _, err := s.resolveDir(dirs[1], false); err != nil {
+		return err
+	}
+	return s.changeTop(func() error {
+		if err := s.Stack.Popd(1); err != nil {
 			return err
 		}
-		if !i.IsDir() {
-			return fmt.Errorf("%s: not a directory", dir)
-		}
-		d.list.Push(dir)
+		return s.Stack.Pushd(dirs[1])
+	})
+}
+
+// rotateDirs brings the directory at position n at the top of the directory
+// stack
+func (s *Shell) rotateDirs(n int) error {
+	dirs := s.Dirs()
+	if n < 0 || n >= len(dirs) {
+		return fmt.Errorf("%d: %w", n, ErrStackRange)
+	}
+	if _, err := s.resolveDir(dirs[n], false); err != nil {
+		return err
+	}
+	return s.changeTop(func() error {
+		return s.Stack.RotateDirs(n)
+	})
+}
+
+// popDirs removes the directory at position n of the directory stack. The
+// working directory changes when the top of the stack is removed
+func (s *Shell) popDirs(n int) error {
+	dirs := s.Dirs()
+	if len(dirs) <= 1 {
+		return ErrStackEmpty
+	}
+	if n != 0 {
+		return s.Stack.Popd(n)
+	}
+	if _, err := s.resolveDir(dirs[1], false); err != nil {
+		return err
+	}
+	return s.changeTop(func() error {
+		return s.Stack.Popd(0)
+	})
+}
+
+// changeTop calls fn that replaces the top of the directory stack and keeps
+// the previous working directory in OLDPWD
+func (s *Shell) changeTop(fn func() error) error {
+	cwd := s.Cwd()
+	if err := fn(); err != nil {
+		return err
 	}
+	s.old = cwd
 	return nil
 }
 
-func (d *dirsstack) Pushd(dir string) error {
-	var (
-		off int
-		err error
-	)
-	switch {
-	case strings.HasPrefix(dir, "+"):
-		off, err = strconv.Atoi(dir)
-		if err == nil {
-			d.list.RotateLeft(off)
+// stackIndex gives the position in a stack of size entries of the +N and -N
+// arguments of dirs, pushd and popd. +N counts from the top of the stack while
+// -N counts from its bottom.
+func stackIndex(str string, size int) (int, bool) {
+	if len(str) < 2 || (str[0] != '+' && str[0] != '-') {
+		return 0, false
+	}
+	n, err := strconv.Atoi(str[1:])
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	if str[0] == '-' {
+		n = size - 1 - n
+	}
+	return n, true
+}
+
+func (s *Shell) chdir(dir string, physical bool) error {
+	dir, err := s.resolveDir(dir, physical)
+	if err != nil {
+		return err
+	}
+	s.old = s.Cwd()
+	return s.Stack.Chdir(dir)
+}
+
+// searchDir gives the directory where cd should go by looking for dir in each
+// directory of CDPATH. The returned bool reports whether the directory has been
+// found thanks to a non empty entry of CDPATH.
+func (s *Shell) searchDir(dir string) (string, bool) {
+	if filepath.IsAbs(dir) || dir == dirCurr || dir == dirParent {
+		return dir, false
+	}
+	if strings.HasPrefix(dir, "./") || strings.HasPrefix(dir, "../") {
+		return dir, false
+	}
+	list, err := s.Resolve(varCdPath)
+	if err != nil || len(list) == 0 {
+		return dir, false
+	}
+	for _, base := range strings.Split(strings.Join(list, ":"), ":") {
+		file := dir
+		if base != "" {
+			file = filepath.Join(base, dir)
 		}
-	case strings.HasPrefix(dir, "-"):
-		off, err = strconv.Atoi(dir)
-		if err == nil {
-			d.list.RotateRight(-off)
+		if _, err := s.resolveDir(file, false); err == nil {
+			return file, base != ""
 		}
-	default:
-		err = d.Chdir(dir)
 	}
-	return err
+	return dir, false
+}
+
+// resolveDir gives the absolute path of dir. With physical, every symbolic link
+// is resolved instead of processing the .. components lexically.
+func (s *Shell) resolveDir(dir string, physical bool) (string, error) {
+	name := dir
+	if !filepath.IsAbs(dir) {
+		dir = s.Cwd() + "/" + dir
+	}
+	var err error
+	if physical {
+		dir, err = realpath(s.fs, dir)
+	} else {
+		dir = filepath.Clean(dir)
+	}
+	var i fs.FileInfo
+	if err == nil {
+		i, err = s.fs.Stat(dir)
+	}
+	if err != nil {
+		var perr *fs.PathError
+		if errors.As(err, &perr) {
+			err = fmt.Errorf("%s: %w", name, perr.Err)
+		}
+		return "", err
+	}
+	if !i.IsDir() {
+		return "", fmt.Errorf("%s: not a directory", name)
+	}
+	return dir, nil
 }
 
-func (d *dirsstack) Popd(dir string) error {
+// realpath gives the physical path of file by following each symbolic link found
+// along it
+func realpath(fsys FileSystem, file string) (string, error) {
 	var (
-		off int
-		err error
+		parts = strings.Split(file, "/")
+		curr  = "/"
+		links int
 	)
-	switch {
-	case strings.HasPrefix(dir, "+"):
-		off, err = strconv.Atoi(dir)
-		if err == nil {
-			d.list.RemoveLeft(off)
+	for len(parts) > 0 {
+		name := parts[0]
+		parts = parts[1:]
+		switch name {
+		case "", dirCurr:
+			continue
+		case dirParent:
+			curr = filepath.Dir(curr)
+			continue
+		default:
+		}
+		next := filepath.Join(curr, name)
+		i, err := fsys.Lstat(next)
+		if err != nil {
+			return "", err
 		}
-	case strings.HasPrefix(dir, "-"):
-		off, err = strconv.Atoi(dir)
-		if err == nil {
-			d.list.RemoveRight(off)
+		if i.Mode()&fs.ModeSymlink == 0 {
+			curr = next
+			continue
 		}
-	default:
-		d.list.Pop()
+		if links++; links > maxLinks {
+			return "", fmt.Errorf("%s: too many levels of symbolic links", file)
+		}
+		link, err := fsys.Readlink(next)
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(link) {
+			curr = "/"
+		}
+		parts = append(strings.Split(link, "/"), parts...)
 	}
-	return err
+	return curr, nil
 }
@@ -4,7 +4,11 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"sort"
+	"strconv"
+	"strings"
 
+	"github.com/midbel/tish/internal/parser"
 	"github.com/midbel/tish/internal/words"
 )
 
@@ -25,6 +29,7 @@ const (
 	varLastPid  = "!"
 	varArgsStr  = "*"
 	varArgsArr  = "@"
+	varPipe     = "PIPESTATUS"
 )
 
 var specials = map[string]struct{}{
@@ -44,18 +49,47 @@ var specials = map[string]struct{}{
 	varArgsArr:  {},
 	varArgsStr:  {},
 	varLastPid:  {},
+	varPipe:     {},
 }
 
+type Attribute uint8
+
+const (
+	AttrReadOnly Attribute = 1 << iota
+	AttrExport
+	AttrInteger
+	AttrLower
+	AttrUpper
+	AttrRef
+)
+
+const maxRefDepth = 16
+
 type Environment interface {
 	Resolve(string) ([]string, error)
 	Define(string, []string) error
 	Delete(string) error
-	// SetReadOnly(string)
+	SetAttributes(string, Attribute) error
+	Attributes(string) (Attribute, error)
+	Names() []string
+}
+
+type variable struct {
+	values []string
+	attrs  Attribute
+	// unset hides the variable of the same name defined in a parent scope
+	unset bool
+}
+
+// variableFinder gives access to a variable as it is stored by an environment,
+// without following name references
+type variableFinder interface {
+	lookup(string) (variable, bool)
 }
 
 type Env struct {
 	parent Environment
-	values map[string][]string
+	values map[string]variable
 }
 
 func EmptyEnv() Environment {
@@ -65,31 +99,238 @@ func EmptyEnv() Environment {
 func EnclosedEnv(parent Environment) Environment {
 	return &Env{
 		parent: parent,
-		values: make(map[string][]string),
+		values: make(map[string]variable),
 	}
 }
 
 func (e *Env) Resolve(ident string) ([]string, error) {
-	vs, ok := e.values[ident]
-	if ok {
-		return vs, nil
+	ident, err := e.follow(ident)
+	if err != nil {
+		return nil, err
 	}
-	if e.parent != nil {
-		return e.parent.Resolve(ident)
+	v, ok := e.lookup(ident)
+	if !ok || v.values == nil {
+		return nil, fmt.Errorf("%s: %w", ident, ErrUndefined)
 	}
-	return nil, fmt.Errorf("%s: undefined variable", ident)
+	str := make([]string, len(v.values))
+	copy(str, v.values)
+	return str, nil
 }
 
 func (e *Env) Define(ident string, vs []string) error {
-	e.values[ident] = vs
+	ident, err := e.follow(ident)
+	if err != nil {
+		return err
+	}
+	v, _ := e.lookup(ident)
+	if v.attrs&AttrReadOnly != 0 {
+		return fmt.Errorf("%s: %w", ident, ErrReadOnly)
+	}
+	if v.values, err = e.transform(v.attrs, vs); err != nil {
+		return err
+	}
+	if v.values == nil {
+		v.values = []string{}
+	}
+	e.values[ident] = v
 	return nil
 }
 
 func (e *Env) Delete(ident string) error {
-	delete(e.values, ident)
+	ident, err := e.follow(ident)
+	if err != nil {
+		return err
+	}
+	if v, _ := e.lookup(ident); v.attrs&AttrReadOnly != 0 {
+		return fmt.Errorf("%s: %w", ident, ErrReadOnly)
+	}
+	if e.parent == nil {
+		delete(e.values, ident)
+		return nil
+	}
+	e.values[ident] = variable{unset: true}
+	return nil
+}
+
+func (e *Env) SetAttributes(ident string, attr Attribute) error {
+	v, _ := e.lookup(ident)
+	if v.attrs&AttrReadOnly != 0 && attr != v.attrs {
+		return fmt.Errorf("%s: %w", ident, ErrReadOnly)
+	}
+	if attr&AttrRef != 0 && len(v.values) > 0 && v.values[0] == ident {
+		return fmt.Errorf("%s: circular name reference", ident)
+	}
+	v.attrs = attr
+	e.values[ident] = v
 	return nil
 }
 
+func (e *Env) Attributes(ident string) (Attribute, error) {
+	v, ok := e.lookup(ident)
+	if !ok {
+		return 0, fmt.Errorf("%s: %w", ident, ErrUndefined)
+	}
+	return v.attrs, nil
+}
+
+// implements words.RefEnv
+func (e *Env) Reference(ident string) (string, bool) {
+	return reference(e, ident)
+}
+
+func (e *Env) Names() []string {
+	var (
+		seen = make(map[string]struct{})
+		list []string
+	)
+	for n, v := range e.values {
+		seen[n] = struct{}{}
+		if v.unset {
+			continue
+		}
+		list = append(list, n)
+	}
+	if e.parent != nil {
+		for _, n := range e.parent.Names() {
+			if _, ok := seen[n]; ok {
+				continue
+			}
+			list = append(list, n)
+		}
+	}
+	sort.Strings(list)
+	return list
+}
+
+func (e *Env) lookup(ident string) (variable, bool) {
+	if v, ok := e.values[ident]; ok {
+		if v.unset {
+			return variable{}, false
+		}
+		return v, true
+	}
+	if e.parent == nil {
+		return variable{}, false
+	}
+	if f, ok := e.parent.(variableFinder); ok {
+		return f.lookup(ident)
+	}
+	vs, err := e.parent.Resolve(ident)
+	if err != nil {
+		return variable{}, false
+	}
+	attr, _ := e.parent.Attributes(ident)
+	return variable{values: vs, attrs: attr}, true
+}
+
+// reference gives the name referenced by ident when ident has the nameref
+// attribute
+func reference(f variableFinder, ident string) (string, bool) {
+	v, ok := f.lookup(ident)
+	if !ok || v.attrs&AttrRef == 0 || len(v.values) == 0 {
+		return "", false
+	}
+	return v.values[0], true
+}
+
+// follow returns the name of the variable finally referenced by ident when
+// ident has the nameref attribute, ident itself otherwise
+func (e *Env) follow(ident string) (string, error) {
+	for i := 0; i < maxRefDepth; i++ {
+		v, ok := e.lookup(ident)
+		if !ok || v.attrs&AttrRef == 0 || len(v.values) == 0 || v.values[0] == "" {
+			return ident, nil
+		}
+		ident = v.values[0]
+	}
+	return "", fmt.Errorf("%s: circular name reference", ident)
+}
+
+func (e *Env) transform(attr Attribute, vs []string) ([]string, error) {
+	if attr&(AttrInteger|AttrLower|AttrUpper) == 0 {
+		return vs, nil
+	}
+	list := make([]string, 0, len(vs))
+	for _, str := range vs {
+		switch {
+		case attr&AttrInteger != 0:
+			if strings.TrimSpace(str) == "" {
+				str = "0"
+				break
+			}
+			n, err := evaluate(e, str)
+			if err != nil {
+				return nil, err
+			}
+			str = strconv.FormatInt(int64(n), 10)
+		case attr&AttrLower != 0:
+			str = strings.ToLower(str)
+		case attr&AttrUpper != 0:
+			str = strings.ToUpper(str)
+		}
+		list = append(list, str)
+	}
+	return list, nil
+}
+
+// tempEnv is the scope of the variables assigned before the name of a command.
+// The other variables are defined in its parent so that they remain once the
+// command is done
+type tempEnv struct {
+	*Env
+}
+
+func temporaryEnv(parent Environment) tempEnv {
+	return tempEnv{
+		Env: &Env{
+			parent: parent,
+			values: make(map[string]variable),
+		},
+	}
+}
+
+func (e tempEnv) Define(ident string, vs []string) error {
+	if e.owns(ident) || e.parent == nil {
+		return e.Env.Define(ident, vs)
+	}
+	return e.parent.Define(ident, vs)
+}
+
+func (e tempEnv) Delete(ident string) error {
+	if e.owns(ident) || e.parent == nil {
+		return e.Env.Delete(ident)
+	}
+	return e.parent.Delete(ident)
+}
+
+func (e tempEnv) SetAttributes(ident string, attr Attribute) error {
+	if e.owns(ident) || e.parent == nil {
+		return e.Env.SetAttributes(ident, attr)
+	}
+	return e.parent.SetAttributes(ident, attr)
+}
+
+// owns reports whether ident is a variable of the temporary scope
+func (e tempEnv) owns(ident string) bool {
+	_, ok := e.values[ident]
+	return ok
+}
+
+// implements words.MathEnv
+func (e *Env) Evaluate(str string) (float64, error) {
+	return evaluate(e, str)
+}
+
+// evaluate gives the value of the arithmetic expression str using env to resolve
+// the variables it contains
+func evaluate(env words.Environment, str string) (float64, error) {
+	ex, err := parser.ParseArithmetic(str)
+	if err != nil {
+		return 0, err
+	}
+	return ex.Eval(env)
+}
+
 type execEnv struct {
 	*Shell
 }
@@ -0,0 +1,19 @@
+package tish
+
+import (
+	"github.com/midbel/tish/internal/words"
+)
+
+// FileSystem is the filesystem used by a Shell to expand filenames, to evaluate
+// file tests, to open the files of redirections and to change its working
+// directory. Relative names are resolved from the working directory of the
+// Shell before being given to the FileSystem.
+type FileSystem = words.FileSystem
+
+// File is a file opened by a FileSystem
+type File = words.File
+
+// OSFileSystem gives the FileSystem of the host
+func OSFileSystem() FileSystem {
+	return words.OSFileSystem()
+}
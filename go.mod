@@ -6,4 +6,5 @@ require (
 	github.com/midbel/rw v0.3.0
 	github.com/midbel/shlex v0.1.0
 	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c
+	golang.org/x/sys v0.30.0
 )
@@ -6,6 +6,7 @@ import (
 	"io"
 	"strings"
 
+	"github.com/midbel/tish/internal/token"
 	"github.com/midbel/tish/internal/words"
 )
 
@@ -43,6 +44,26 @@ func ExpandWith(str string, args []string, env words.Environment, with func([][]
 	return nil
 }
 
+// ParseArithmetic parses str as the content of an arithmetic expansion
+func ParseArithmetic(str string) (words.ExpandMath, error) {
+	var (
+		psr = createParser(Scan(strings.NewReader(fmt.Sprintf("$((%s))", str))))
+		ret words.ExpandMath
+	)
+	psr.next()
+	if psr.curr.Type != token.BegMath {
+		return ret, psr.unexpected()
+	}
+	ex, err := psr.parseArithmetic()
+	if err != nil {
+		return ret, err
+	}
+	if !psr.done() {
+		return ret, psr.unexpected()
+	}
+	return ex.(words.ExpandMath), nil
+}
+
 func expandExecuter(ex words.Executer, env words.Environment) ([][]string, error) {
 	var (
 		str [][]string
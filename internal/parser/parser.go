@@ -1,6 +1,7 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"strconv"
@@ -10,10 +11,20 @@ import (
 	"github.com/midbel/tish/internal/words"
 )
 
+// ErrIncomplete is returned when the input ends in the middle of a statement
+// (eg: unterminated quote, unclosed compound command). More input is needed to
+// parse it
+var ErrIncomplete = errors.New("incomplete input")
+
 type Parser struct {
 	scan *Scanner
 	curr token.Token
 	peek token.Token
+	// peeked tells that peek holds the token following curr
+	peeked bool
+	// advance tells that the terminator of the last statement still has to be
+	// consumed before parsing the next one
+	advance bool
 
 	quoted bool
 	prefix map[rune]func() (words.Expr, error)
@@ -30,9 +41,18 @@ func Parse(str string) (words.Executer, error) {
 	return p.Parse()
 }
 
+// NewParser creates a parser reading statements from r. The input is only read
+// when needed so that each statement is given by Parse as soon as it is
+// complete
 func NewParser(r io.Reader) *Parser {
+	p := createParser(Scan(r))
+	p.advance = true
+	return p
+}
+
+func createParser(scan *Scanner) *Parser {
 	var p Parser
-	p.scan = Scan(r)
+	p.scan = scan
 
 	p.prefix = map[rune]func() (words.Expr, error){
 		token.BegMath:  p.parseUnary,
@@ -49,6 +69,7 @@ func NewParser(r io.Reader) *Parser {
 		token.Sub:        p.parseBinary,
 		token.Mul:        p.parseBinary,
 		token.Div:        p.parseBinary,
+		token.Mod:        p.parseBinary,
 		token.Pow:        p.parseBinary,
 		token.LeftShift:  p.parseBinary,
 		token.RightShift: p.parseBinary,
@@ -66,6 +87,9 @@ func NewParser(r io.Reader) *Parser {
 		token.Or:         p.parseBinary,
 		token.Cond:       p.parseTernary,
 		token.Assign:     p.parseAssign,
+		token.Comma:      p.parseComma,
+		token.Inc:        p.parsePostfix,
+		token.Dec:        p.parsePostfix,
 	}
 
 	p.binary = map[rune]func(words.Expander) (words.Expander, error){
@@ -80,32 +104,52 @@ func NewParser(r io.Reader) *Parser {
 		token.NewerThan: p.parseBinaryTest,
 		token.OlderThan: p.parseBinaryTest,
 		token.SameFile:  p.parseBinaryTest,
+		token.NumEq:     p.parseBinaryTest,
+		token.NumNe:     p.parseBinaryTest,
+		token.NumLt:     p.parseBinaryTest,
+		token.NumLe:     p.parseBinaryTest,
+		token.NumGt:     p.parseBinaryTest,
+		token.NumGe:     p.parseBinaryTest,
+		token.ReMatch:   p.parseRegexTest,
 	}
 	p.unary = map[rune]func() (words.Expander, error){
-		token.Not:         p.parseUnaryTest,
-		token.BegMath:     p.parseUnaryTest,
-		token.FileExists:  p.parseUnaryTest,
-		token.FileRead:    p.parseUnaryTest,
-		token.FileLink:    p.parseUnaryTest,
-		token.FileDir:     p.parseUnaryTest,
-		token.FileWrite:   p.parseUnaryTest,
-		token.FileSize:    p.parseUnaryTest,
-		token.FileRegular: p.parseUnaryTest,
-		token.FileExec:    p.parseUnaryTest,
-		token.StrNotEmpty: p.parseUnaryTest,
-		token.StrEmpty:    p.parseUnaryTest,
-		token.Literal:     p.parseUnaryTest,
-		token.Variable:    p.parseUnaryTest,
-		token.Quote:       p.parseUnaryTest,
+		token.Not:          p.parseUnaryTest,
+		token.BegMath:      p.parseUnaryTest,
+		token.FileExists:   p.parseUnaryTest,
+		token.FileRead:     p.parseUnaryTest,
+		token.FileLink:     p.parseUnaryTest,
+		token.FileDir:      p.parseUnaryTest,
+		token.FileWrite:    p.parseUnaryTest,
+		token.FileSize:     p.parseUnaryTest,
+		token.FileRegular:  p.parseUnaryTest,
+		token.FileExec:     p.parseUnaryTest,
+		token.FileBlock:    p.parseUnaryTest,
+		token.FileChar:     p.parseUnaryTest,
+		token.FilePipe:     p.parseUnaryTest,
+		token.FileSocket:   p.parseUnaryTest,
+		token.FileSetuid:   p.parseUnaryTest,
+		token.FileSetgid:   p.parseUnaryTest,
+		token.FileSticky:   p.parseUnaryTest,
+		token.FileOwner:    p.parseUnaryTest,
+		token.FileGroup:    p.parseUnaryTest,
+		token.FileModified: p.parseUnaryTest,
+		token.FileTerm:     p.parseUnaryTest,
+		token.VarSet:       p.parseUnaryTest,
+		token.StrNotEmpty:  p.parseUnaryTest,
+		token.StrEmpty:     p.parseUnaryTest,
+		token.Literal:      p.parseUnaryTest,
+		token.Variable:     p.parseUnaryTest,
+		token.Quote:        p.parseUnaryTest,
 	}
 
-	p.next()
-	p.next()
-
 	return &p
 }
 
 func (p *Parser) Parse() (words.Executer, error) {
+	if p.advance {
+		p.next()
+		p.advance = false
+	}
 	if p.done() {
 		return nil, io.EOF
 	}
@@ -114,8 +158,9 @@ func (p *Parser) Parse() (words.Executer, error) {
 		return nil, err
 	}
 	switch p.curr.Type {
-	case token.List, token.Comment, token.EOF:
-		p.next()
+	case token.List, token.Comment:
+		p.advance = true
+	case token.EOF:
 	default:
 		return nil, p.unexpected()
 	}
@@ -124,37 +169,181 @@ func (p *Parser) Parse() (words.Executer, error) {
 
 func (p *Parser) parse() (words.Executer, error) {
 	switch {
-	case p.peek.Type == token.Assign:
-		return p.parseAssignment()
-	case p.curr.Type == token.Keyword:
-		return p.parseKeyword()
-	case p.curr.Type == token.BegTest:
-		return p.parseTest()
-	case p.curr.Type == token.BegSub:
-		return p.parseSubshell()
+	case p.curr.Type == token.Literal && p.lookahead().Type == token.BegSub:
+		return p.parseFunction()
 	default:
 	}
-	ex, err := p.parseSimple()
+	ex, err := p.parsePipeline()
 	if err != nil {
 		return nil, err
 	}
 	for {
 		switch p.curr.Type {
 		case token.And:
-			return p.parseAnd(ex)
+			ex, err = p.parseAnd(ex)
 		case token.Or:
-			return p.parseOr(ex)
-		case token.Pipe, token.PipeBoth:
-			ex, err = p.parsePipe(ex)
-			if err != nil {
-				return nil, err
-			}
+			ex, err = p.parseOr(ex)
 		default:
 			return ex, nil
 		}
+		if err != nil {
+			return nil, err
+		}
 	}
 }
 
+func (p *Parser) parsePipeline() (words.Executer, error) {
+	switch {
+	case p.isCommandKeyword(token.KwNot):
+		return p.parseNot()
+	case p.isCommandKeyword(token.KwTime):
+		return p.parseTime()
+	default:
+	}
+	ex, err := p.parseCommand()
+	if err != nil {
+		return nil, err
+	}
+	if p.curr.Type == token.Pipe || p.curr.Type == token.PipeBoth {
+		return p.parsePipe(ex)
+	}
+	return ex, nil
+}
+
+func (p *Parser) parseNot() (words.Executer, error) {
+	p.next()
+	p.skipBlank()
+	ex, err := p.parsePipeline()
+	if err != nil {
+		return nil, err
+	}
+	return words.CreateNot(ex), nil
+}
+
+func (p *Parser) parseTime() (words.Executer, error) {
+	p.next()
+	p.skipBlank()
+	var posix bool
+	if p.curr.Type == token.Literal && p.curr.Literal == "-p" {
+		posix = true
+		p.next()
+		p.skipBlank()
+	}
+	ex, err := p.parsePipeline()
+	if err != nil {
+		return nil, err
+	}
+	return words.CreateTime(ex, posix), nil
+}
+
+func (p *Parser) parseCommand() (words.Executer, error) {
+	switch {
+	case p.isCommandKeyword(token.KwSelect):
+		return p.parseSelect()
+	case p.isCommandKeyword(token.KwFunction):
+		return p.parseFunction()
+	default:
+	}
+	switch p.curr.Type {
+	case token.Keyword:
+		return p.parseKeyword()
+	case token.BegTest:
+		return p.parseTest()
+	case token.BegSub:
+		return p.parseSubshell()
+	case token.BegGroup:
+		return p.parseGroup()
+	case token.BegMath:
+		return p.parseMath()
+	default:
+		return p.parseSimple()
+	}
+}
+
+func (p *Parser) parseMath() (words.Executer, error) {
+	ex, err := p.parseArithmetic()
+	if err != nil {
+		return nil, err
+	}
+	p.skipBlank()
+	return words.ExecMath{
+		ExpandMath: ex.(words.ExpandMath),
+	}, nil
+}
+
+func (p *Parser) parseGroup() (words.Executer, error) {
+	p.next()
+	var ex words.ExecGroup
+	for !p.done() && p.curr.Type != token.EndGroup {
+		switch p.curr.Type {
+		case token.Blank:
+			p.skipBlank()
+			continue
+		case token.List, token.Comment:
+			p.next()
+			continue
+		default:
+		}
+		x, err := p.parse()
+		if err != nil {
+			return nil, err
+		}
+		ex.List = append(ex.List, x)
+		switch p.curr.Type {
+		case token.List, token.Comment:
+			p.next()
+		case token.EndGroup:
+		default:
+			return nil, p.unexpected()
+		}
+	}
+	if p.curr.Type != token.EndGroup {
+		return nil, p.unexpected()
+	}
+	p.next()
+	for p.curr.IsRedirect() {
+		r, err := p.parseRedirection()
+		if err != nil {
+			return nil, err
+		}
+		ex.Redirect = append(ex.Redirect, r)
+	}
+	return ex, nil
+}
+
+func (p *Parser) parseFunction() (words.Executer, error) {
+	if p.isCommandKeyword(token.KwFunction) {
+		p.next()
+		p.skipBlank()
+	}
+	if p.curr.Type != token.Literal {
+		return nil, p.unexpected()
+	}
+	ident := p.curr.Literal
+	p.next()
+	p.skipBlank()
+	if p.curr.Type == token.BegSub {
+		p.next()
+		if p.curr.Type != token.EndSub {
+			return nil, p.unexpected()
+		}
+		p.next()
+	}
+	for p.curr.Type == token.List || p.curr.Type == token.Blank {
+		p.next()
+	}
+	switch p.curr.Type {
+	case token.BegGroup, token.BegSub, token.BegTest, token.Keyword:
+	default:
+		return nil, p.unexpected()
+	}
+	body, err := p.parseCommand()
+	if err != nil {
+		return nil, err
+	}
+	return words.CreateFunction(ident, body), nil
+}
+
 func (p *Parser) parseSubshell() (words.Executer, error) {
 	p.next()
 	var list words.ExecSubshell
@@ -244,7 +433,19 @@ func (p *Parser) parseUnaryTest() (words.Expander, error) {
 			break
 		}
 		p.next()
-	case token.Not, token.FileExists, token.FileRead, token.FileWrite, token.FileExec, token.FileSize, token.FileLink, token.FileDir, token.FileRegular, token.StrEmpty, token.StrNotEmpty:
+	case token.Not:
+		p.next()
+		ex, err = p.parseTester(words.BindLogical)
+		if err != nil {
+			break
+		}
+		ex = words.UnaryTest{
+			Op:    op,
+			Right: ex,
+		}
+	case token.FileExists, token.FileRead, token.FileWrite, token.FileExec, token.FileSize, token.FileLink, token.FileDir, token.FileRegular, token.StrEmpty, token.StrNotEmpty,
+		token.FileBlock, token.FileChar, token.FilePipe, token.FileSocket, token.FileSetuid, token.FileSetgid, token.FileSticky,
+		token.FileOwner, token.FileGroup, token.FileModified, token.FileTerm, token.VarSet:
 		p.next()
 		ex, err = p.parseTester(words.BindPrefix)
 		if err != nil {
@@ -278,31 +479,116 @@ func (p *Parser) parseBinaryTest(left words.Expander) (words.Expander, error) {
 	return b, err
 }
 
+func (p *Parser) parseRegexTest(left words.Expander) (words.Expander, error) {
+	b := words.BinaryTest{
+		Left: left,
+		Op:   p.curr.Type,
+	}
+	p.next()
+
+	var list words.ExpandMulti
+	for {
+		var (
+			next words.Expander
+			err  error
+		)
+		switch p.curr.Type {
+		case token.Literal:
+			next, err = p.parseLiteral()
+		case token.Variable:
+			next, err = p.parseVariable()
+		case token.Quote:
+			next, err = p.parseQuote()
+		case token.BegExp:
+			next, err = p.parseExpansion()
+		case token.BegSub:
+			next, err = p.parseSubstitution()
+		default:
+			if len(list.List) == 0 {
+				return nil, p.unexpected()
+			}
+			b.Right = list.Expander()
+			return b, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		list.List = append(list.List, next)
+	}
+}
+
 func (p *Parser) parseSimple() (words.Executer, error) {
 	var (
 		ex   words.ExpandList
 		dirs []words.ExpandRedirect
 	)
+	assign, err := p.parsePrefix()
+	if err != nil {
+		return nil, err
+	}
 	for {
 		switch p.curr.Type {
-		case token.Literal, token.Quote, token.Variable, token.BegExp, token.BegBrace, token.BegSub, token.BegMath:
+		case token.Literal, token.Quote, token.Variable, token.BegExp, token.BegBrace, token.BegSub, token.BegMath, token.Assign:
 			next, err := p.parseWords()
 			if err != nil {
 				return nil, err
 			}
 			ex.List = append(ex.List, next)
-		case token.RedirectIn, token.RedirectOut, token.RedirectErr, token.RedirectBoth, token.AppendOut, token.AppendBoth:
+		case token.RedirectIn, token.RedirectOut, token.RedirectErr, token.RedirectBoth, token.AppendOut, token.AppendErr, token.AppendBoth:
 			next, err := p.parseRedirection()
 			if err != nil {
 				return nil, err
 			}
 			dirs = append(dirs, next)
 		default:
-			sg := words.CreateSimple(ex)
-			sg.Redirect = append(sg.Redirect, dirs...)
-			return sg, nil
+			return createSimple(ex, dirs, assign), nil
+		}
+	}
+}
+
+// createSimple gives the executer of a simple command. Assignments without any
+// command are executed on their own instead of being the temporary variables
+// of the command
+func createSimple(ex words.ExpandList, dirs []words.ExpandRedirect, assign []words.ExecAssign) words.Executer {
+	if len(ex.List) == 0 && len(dirs) == 0 && len(assign) > 0 {
+		var list words.ExecList
+		for i := range assign {
+			list = append(list, assign[i])
 		}
+		return list.Executer()
 	}
+	sg := words.CreateSimple(ex)
+	sg.Redirect = append(sg.Redirect, dirs...)
+	sg.Assign = append(sg.Assign, assign...)
+	return sg
+}
+
+// parsePrefix parses the assignments given before the name of a command. A word
+// is an assignment only when the equal sign follows its name without blank
+func (p *Parser) parsePrefix() ([]words.ExecAssign, error) {
+	var list []words.ExecAssign
+	for p.curr.Type == token.Literal && p.isAssign() {
+		a, err := p.parseAssignment()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, a)
+	}
+	return list, nil
+}
+
+// isCommandKeyword reports whether the current word is the reserved word kw
+// given in the position of a command name
+func (p *Parser) isCommandKeyword(kw string) bool {
+	if p.curr.Type != token.Literal || p.curr.Literal != kw || p.curr.Quoted {
+		return false
+	}
+	return p.lookahead().Eow()
+}
+
+func (p *Parser) isAssign() bool {
+	next := p.lookahead()
+	return next.Type == token.Assign && !next.BlankBefore
 }
 
 func (p *Parser) parseRedirection() (words.ExpandRedirect, error) {
@@ -315,26 +601,21 @@ func (p *Parser) parseRedirection() (words.ExpandRedirect, error) {
 	return words.CreateRedirect(e, kind), nil
 }
 
-func (p *Parser) parseAssignment() (words.Executer, error) {
-	if p.curr.Type != token.Literal {
-		return nil, p.unexpected()
-	}
+func (p *Parser) parseAssignment() (words.ExecAssign, error) {
 	var (
 		ident = p.curr.Literal
 		list  words.ExpandList
 	)
 	p.next()
 	if p.curr.Type != token.Assign {
-		return nil, p.unexpected()
+		return words.ExecAssign{}, p.unexpected()
 	}
+	empty := p.curr.BlankAfter
 	p.next()
-	for !p.done() {
-		if p.curr.IsSequence() {
-			break
-		}
+	if !empty && !p.done() && !p.curr.IsSequence() {
 		w, err := p.parseWords()
 		if err != nil {
-			return nil, err
+			return words.ExecAssign{}, err
 		}
 		list.List = append(list.List, w)
 	}
@@ -343,28 +624,31 @@ func (p *Parser) parseAssignment() (words.Executer, error) {
 
 func (p *Parser) parsePipe(left words.Executer) (words.Executer, error) {
 	var list []words.PipeItem
-	list = append(list, words.CreatePipeItem(left, p.curr.Type == token.PipeBoth))
 	for !p.done() {
 		if p.curr.Type != token.Pipe && p.curr.Type != token.PipeBoth {
 			break
 		}
-		var (
-			both = p.curr.Type == token.PipeBoth
-			ex   words.Executer
-			err  error
-		)
+		list = append(list, words.CreatePipeItem(left, p.curr.Type == token.PipeBoth))
 		p.next()
-		if ex, err = p.parseSimple(); err != nil {
+		if p.done() {
+			return nil, p.unexpected()
+		}
+
+		var err error
+		if left, err = p.parseCommand(); err != nil {
 			return nil, err
 		}
-		list = append(list, words.CreatePipeItem(ex, both))
 	}
+	list = append(list, words.CreatePipeItem(left, false))
 	return words.CreatePipe(list), nil
 }
 
 func (p *Parser) parseAnd(left words.Executer) (words.Executer, error) {
 	p.next()
-	right, err := p.parse()
+	if p.done() {
+		return nil, p.unexpected()
+	}
+	right, err := p.parsePipeline()
 	if err != nil {
 		return nil, err
 	}
@@ -373,7 +657,10 @@ func (p *Parser) parseAnd(left words.Executer) (words.Executer, error) {
 
 func (p *Parser) parseOr(left words.Executer) (words.Executer, error) {
 	p.next()
-	right, err := p.parse()
+	if p.done() {
+		return nil, p.unexpected()
+	}
+	right, err := p.parsePipeline()
 	if err != nil {
 		return nil, err
 	}
@@ -517,7 +804,7 @@ func (p *Parser) parseIf() (words.Executer, error) {
 
 func (p *Parser) parseClause() (words.ExecClause, error) {
 	var c words.ExecClause
-	if p.curr.Literal == "*" && p.peek.Type != token.EndSub {
+	if p.curr.Literal == "*" && p.lookahead().Type != token.EndSub {
 		return c, p.unexpected()
 	}
 	for !p.done() && p.curr.Type != token.EndSub {
@@ -549,7 +836,7 @@ func (p *Parser) parseClause() (words.ExecClause, error) {
 			p.next()
 			p.skipBlank()
 		}
-		if p.peek.Type == token.Comma || p.peek.Type == token.EndSub || (p.curr.Type == token.Keyword && p.curr.Literal == token.KwEsac) {
+		if p.lookahead().Type == token.Comma || p.lookahead().Type == token.EndSub || (p.curr.Type == token.Keyword && p.curr.Literal == token.KwEsac) {
 			break
 		}
 		p.skipBlank()
@@ -609,6 +896,9 @@ func (p *Parser) parseFor() (words.Executer, error) {
 
 	p.next()
 	p.skipBlank()
+	if p.curr.Type == token.BegMath {
+		return p.parseForMath()
+	}
 	if p.curr.Type != token.Literal {
 		return nil, p.unexpected()
 	}
@@ -651,6 +941,101 @@ func (p *Parser) parseFor() (words.Executer, error) {
 	return ex, nil
 }
 
+func (p *Parser) parseSelect() (words.Executer, error) {
+	p.enterLoop()
+	defer p.leaveLoop()
+
+	p.next()
+	p.skipBlank()
+	if p.curr.Type != token.Literal {
+		return nil, p.unexpected()
+	}
+	ex := words.ExecSelect{
+		Ident: p.curr.Literal,
+	}
+	p.next()
+	p.skipBlank()
+	switch {
+	case p.curr.Type == token.Keyword && p.curr.Literal == token.KwIn:
+		p.next()
+		p.skipBlank()
+		for !p.done() && p.curr.Type != token.List {
+			e, err := p.parseWords()
+			if err != nil {
+				return nil, err
+			}
+			ex.List = append(ex.List, e)
+		}
+	case p.curr.Type == token.List:
+		ex.List = append(ex.List, words.CreateVariable("@", false))
+	default:
+		return nil, p.unexpected()
+	}
+	if p.curr.Type != token.List {
+		return nil, p.unexpected()
+	}
+	p.next()
+	if p.curr.Type != token.Keyword || p.curr.Literal != token.KwDo {
+		return nil, p.unexpected()
+	}
+	var err error
+	ex.Body, err = p.parseBody(func(kw string) bool { return kw == token.KwElse || kw == token.KwDone })
+	if err != nil {
+		return nil, err
+	}
+	if p.curr.Type == token.Keyword && p.curr.Literal == token.KwElse {
+		ex.Alt, err = p.parseBody(func(kw string) bool { return kw == token.KwDone })
+		if err != nil {
+			return nil, err
+		}
+	}
+	p.next()
+	return ex, nil
+}
+
+func (p *Parser) parseForMath() (words.Executer, error) {
+	p.next()
+	var (
+		ex   words.ExecForMath
+		err  error
+		list = []*words.Expr{&ex.Init, &ex.Cond, &ex.Step}
+	)
+	for i, e := range list {
+		end := rune(token.List)
+		if i == len(list)-1 {
+			end = token.EndMath
+		}
+		if p.curr.Type != end {
+			if *e, err = p.parseExpression(words.BindLowest); err != nil {
+				return nil, err
+			}
+		}
+		if p.curr.Type != end {
+			return nil, p.unexpected()
+		}
+		p.next()
+	}
+	p.skipBlank()
+	if p.curr.Type == token.List {
+		p.next()
+	}
+	if p.curr.Type != token.Keyword || p.curr.Literal != token.KwDo {
+		return nil, p.unexpected()
+	}
+	ex.Body, err = p.parseBody(func(kw string) bool { return kw == token.KwElse || kw == token.KwDone })
+	if err != nil {
+		return nil, err
+	}
+	if p.curr.Type == token.Keyword && p.curr.Literal == token.KwElse {
+		ex.Alt, err = p.parseBody(func(kw string) bool { return kw == token.KwDone })
+		if err != nil {
+			return nil, err
+		}
+	}
+	p.next()
+	return ex, nil
+}
+
 func (p *Parser) parseBody(stop func(kw string) bool) (words.Executer, error) {
 	var list words.ExecList
 	p.next()
@@ -702,6 +1087,17 @@ func (p *Parser) parseWords() (words.Expander, error) {
 		switch p.curr.Type {
 		case token.Literal:
 			next, err = p.parseLiteral()
+		case token.Assign:
+			if p.curr.BlankBefore && len(list.List) > 0 {
+				return list.Expander(), nil
+			}
+			next = words.CreateWord(p.curr.Literal, p.quoted)
+			if p.curr.BlankAfter {
+				p.next()
+				list.List = append(list.List, next)
+				return list.Expander(), nil
+			}
+			p.next()
 		case token.Variable:
 			next, err = p.parseVariable()
 		case token.Quote:
@@ -713,7 +1109,7 @@ func (p *Parser) parseWords() (words.Expander, error) {
 		case token.BegMath:
 			next, err = p.parseArithmetic()
 		case token.BegBrace:
-			next, err = p.parseBraces(list.Pop())
+			return p.parseBraces(list, p.parseWords)
 		default:
 			err = p.unexpected()
 		}
@@ -824,6 +1220,15 @@ func (p *Parser) parseBinary(left words.Expr) (words.Expr, error) {
 	return b, err
 }
 
+func (p *Parser) parsePostfix(left words.Expr) (words.Expr, error) {
+	if _, ok := left.(words.ExpandVar); !ok {
+		return nil, p.unexpected()
+	}
+	ex := words.CreatePostfix(left, p.curr.Type)
+	p.next()
+	return ex, nil
+}
+
 func (p *Parser) parseAssign(left words.Expr) (words.Expr, error) {
 	var as words.Assignment
 	switch v := left.(type) {
@@ -832,16 +1237,54 @@ func (p *Parser) parseAssign(left words.Expr) (words.Expr, error) {
 	default:
 		return nil, p.unexpected()
 	}
+	op, ok := assignOps[p.curr.Literal]
+	if !ok {
+		return nil, p.unexpected()
+	}
 	p.next()
 
-	expr, err := p.parseExpression(words.BindLowest)
+	expr, err := p.parseExpression(words.BindComma)
 	if err != nil {
 		return nil, err
 	}
+	if op != token.Assign {
+		expr = words.Binary{
+			Op:    op,
+			Left:  left,
+			Right: expr,
+		}
+	}
 	as.Expr = expr
 	return as, nil
 }
 
+// assignOps maps the operator of a compound assignment (eg: += gives +) to the
+// token of the binary operation. A simple assignment has no operator
+var assignOps = map[string]rune{
+	"":   token.Assign,
+	"+":  token.Add,
+	"-":  token.Sub,
+	"*":  token.Mul,
+	"/":  token.Div,
+	"%":  token.Mod,
+	"<<": token.LeftShift,
+	">>": token.RightShift,
+	"&":  token.BitAnd,
+	"|":  token.BitOr,
+	"^":  token.BitXor,
+}
+
+// parseComma parses the comma operator: both expressions are evaluated and the
+// value of the right one is kept
+func (p *Parser) parseComma(left words.Expr) (words.Expr, error) {
+	p.next()
+	right, err := p.parseExpression(words.BindComma)
+	if err != nil {
+		return nil, err
+	}
+	return words.ExpandMath{List: []words.Expr{left, right}}, nil
+}
+
 func (p *Parser) parseTernary(left words.Expr) (words.Expr, error) {
 	p.next()
 	ter := words.Ternary{
@@ -886,7 +1329,9 @@ func (p *Parser) parseQuote() (words.Expander, error) {
 	p.enterQuote()
 	p.next()
 
-	var list words.ExpandMulti
+	list := words.ExpandMulti{
+		Quoted: true,
+	}
 	for !p.done() && p.curr.Type != token.Quote {
 		var (
 			next words.Expander
@@ -920,21 +1365,48 @@ func (p *Parser) parseQuote() (words.Expander, error) {
 }
 
 func (p *Parser) parseLiteral() (words.ExpandWord, error) {
-	ex := words.CreateWord(p.curr.Literal, p.quoted)
+	ex := words.CreateWord(p.curr.Literal, p.quoted || p.curr.Quoted)
 	p.next()
 	return ex, nil
 }
 
-func (p *Parser) parseBraces(prefix words.Expander) (words.Expander, error) {
+func (p *Parser) parseBraces(prefix words.ExpandMulti, suffix func() (words.Expander, error)) (words.Expander, error) {
+	var (
+		ex  words.Expander
+		err error
+	)
 	p.next()
-	if p.peek.Type == token.Range {
-		return p.parseRangeBraces(prefix)
+	if p.lookahead().Type == token.Range {
+		ex, err = p.parseRangeBraces()
+	} else {
+		ex, err = p.parseListBraces()
+	}
+	if err != nil {
+		return nil, err
 	}
-	return p.parseListBraces(prefix)
+	var before, after words.Expander
+	if len(prefix.List) > 0 {
+		before = prefix.Expander()
+	}
+	if after, err = suffix(); err != nil {
+		return nil, err
+	}
+	if m, ok := after.(words.ExpandMulti); ok && len(m.List) == 0 {
+		after = nil
+	}
+	switch b := ex.(type) {
+	case words.ExpandListBrace:
+		b.Prefix, b.Suffix = before, after
+		ex = b
+	case words.ExpandRangeBrace:
+		b.Prefix, b.Suffix = before, after
+		ex = b
+	}
+	return ex, nil
 }
 
 func (p *Parser) parseWordsInBraces() (words.Expander, error) {
-	var list words.ExpandList
+	var list words.ExpandMulti
 	for !p.done() {
 		if p.curr.Type == token.Seq || p.curr.Type == token.EndBrace {
 			break
@@ -946,8 +1418,21 @@ func (p *Parser) parseWordsInBraces() (words.Expander, error) {
 		switch p.curr.Type {
 		case token.Literal:
 			next, err = p.parseLiteral()
+		case token.Range:
+			next = words.CreateWord("..", p.quoted)
+			p.next()
+		case token.Variable:
+			next, err = p.parseVariable()
+		case token.Quote:
+			next, err = p.parseQuote()
+		case token.BegExp:
+			next, err = p.parseExpansion()
+		case token.BegSub:
+			next, err = p.parseSubstitution()
+		case token.BegMath:
+			next, err = p.parseArithmetic()
 		case token.BegBrace:
-			next, err = p.parseBraces(list.Pop())
+			return p.parseBraces(list, p.parseWordsInBraces)
 		default:
 			err = p.unexpected()
 		}
@@ -956,79 +1441,81 @@ func (p *Parser) parseWordsInBraces() (words.Expander, error) {
 		}
 		list.List = append(list.List, next)
 	}
-	return list, nil
+	return list.Expander(), nil
 }
 
-func (p *Parser) parseListBraces(prefix words.Expander) (words.Expander, error) {
-	ex := words.ExpandListBrace{
-		Prefix: prefix,
-	}
+func (p *Parser) parseListBraces() (words.Expander, error) {
+	var ex words.ExpandListBrace
 	for !p.done() {
-		if p.curr.Type == token.EndBrace {
-			break
-		}
 		x, err := p.parseWordsInBraces()
 		if err != nil {
 			return nil, err
 		}
 		ex.Words = append(ex.Words, x)
-		switch p.curr.Type {
-		case token.Seq:
-			p.next()
-		case token.EndBrace:
-		default:
-			return nil, p.unexpected()
+		if p.curr.Type != token.Seq {
+			break
 		}
+		p.next()
 	}
 	if p.curr.Type != token.EndBrace {
 		return nil, p.unexpected()
 	}
 	p.next()
-	suffix, err := p.parseWordsInBraces()
-	if err != nil {
-		return nil, err
-	}
-	ex.Suffix = suffix
 	return ex, nil
 }
 
-func (p *Parser) parseRangeBraces(prefix words.Expander) (words.Expander, error) {
-	parseInt := func() (int, error) {
+func (p *Parser) parseRangeBraces() (words.Expander, error) {
+	var (
+		ex    words.ExpandRangeBrace
+		width int
+	)
+	parseBound := func() (int, error) {
 		if p.curr.Type != token.Literal {
 			return 0, p.unexpected()
 		}
-		i, err := strconv.Atoi(p.curr.Literal)
+		str := p.curr.Literal
+		if n := len(str); n > width {
+			width = n
+		}
+		if x := strings.TrimPrefix(str, "-"); len(x) > 1 && x[0] == '0' {
+			ex.Pad = 1
+		}
+		if n := len(str); n == 1 && (str[0] < '0' || str[0] > '9') {
+			ex.Letter = true
+			p.next()
+			return int(str[0]), nil
+		}
+		i, err := strconv.Atoi(str)
 		if err == nil {
 			p.next()
 		}
 		return i, err
 	}
-	ex := words.ExpandRangeBrace{
-		Prefix: prefix,
-		Step:   1,
-	}
-	if p.curr.Type == token.Literal {
-		if n := len(p.curr.Literal); strings.HasPrefix(p.curr.Literal, "0") && n > 1 {
-			str := strings.TrimLeft(p.curr.Literal, "0")
-			ex.Pad = (n - len(str)) + 1
-		}
-	}
 	var err error
-	if ex.From, err = parseInt(); err != nil {
+	if ex.From, err = parseBound(); err != nil {
 		return nil, err
 	}
 	if p.curr.Type != token.Range {
 		return nil, p.unexpected()
 	}
 	p.next()
-	if ex.To, err = parseInt(); err != nil {
+	if ex.To, err = parseBound(); err != nil {
 		return nil, err
 	}
+	if ex.Pad > 0 && !ex.Letter {
+		ex.Pad = width
+	} else {
+		ex.Pad = 0
+	}
 	if p.curr.Type == token.Range {
 		p.next()
-		if ex.Step, err = parseInt(); err != nil {
+		if p.curr.Type != token.Literal {
+			return nil, p.unexpected()
+		}
+		if ex.Step, err = strconv.Atoi(p.curr.Literal); err != nil {
 			return nil, err
 		}
+		p.next()
 	}
 	if p.curr.Type != token.EndBrace {
 		return nil, p.unexpected()
@@ -1105,24 +1592,17 @@ func (p *Parser) parseReplace(ident token.Token) (words.Expander, error) {
 		Quoted: p.quoted,
 	}
 	p.next()
-	if p.curr.Type != token.Literal {
-		return nil, p.unexpected()
+
+	var err error
+	if e.From, err = p.parsePattern(); err != nil {
+		return nil, err
 	}
-	e.From = p.curr.Literal
-	p.next()
 	if p.curr.Type != token.Replace {
-		return nil, p.unexpected()
+		return e, nil
 	}
 	p.next()
-	switch p.curr.Type {
-	case token.Literal:
-		e.To = p.curr.Literal
-		p.next()
-	case token.EndExp:
-	default:
-		return nil, p.unexpected()
-	}
-	return e, nil
+	e.To, err = p.parseOperand()
+	return e, err
 }
 
 func (p *Parser) parseTrim(ident token.Token) (words.Expander, error) {
@@ -1132,12 +1612,10 @@ func (p *Parser) parseTrim(ident token.Token) (words.Expander, error) {
 		Quoted: p.quoted,
 	}
 	p.next()
-	if p.curr.Type != token.Literal {
-		return nil, p.unexpected()
-	}
-	e.Trim = p.curr.Literal
-	p.next()
-	return e, nil
+
+	var err error
+	e.Trim, err = p.parsePattern()
+	return e, err
 }
 
 func (p *Parser) parseLower(ident token.Token) (words.Expander, error) {
@@ -1160,6 +1638,86 @@ func (p *Parser) parseUpper(ident token.Token) (words.Expander, error) {
 	return e, nil
 }
 
+func (p *Parser) parseTransform(ident token.Token) (words.Expander, error) {
+	e := words.ExpandTransform{
+		Ident:  ident.Literal,
+		What:   p.curr.Type,
+		Quoted: p.quoted,
+	}
+	p.next()
+	return e, nil
+}
+
+func (p *Parser) parseValIf(ident token.Token) (words.Expander, error) {
+	op := p.curr.Type
+	p.next()
+
+	value, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	var ex words.Expander
+	switch op {
+	case token.ValIfUnset, token.ValIfUndef:
+		ex = words.CreateValIfUnset(ident.Literal, value, op == token.ValIfUnset, p.quoted)
+	case token.SetValIfUnset, token.SetValIfUndef:
+		ex = words.CreateSetValIfUnset(ident.Literal, value, op == token.SetValIfUnset, p.quoted)
+	case token.ValIfSet, token.ValIfDef:
+		ex = words.CreateExpandValIfSet(ident.Literal, value, op == token.ValIfSet, p.quoted)
+	case token.ExitIfUnset, token.ExitIfUndef:
+		ex = words.CreateExpandExitIfUnset(ident.Literal, value, op == token.ExitIfUnset, p.quoted)
+	default:
+		return nil, p.unexpected()
+	}
+	return ex, nil
+}
+
+// parsePattern parses the pattern of the trim and replace operators. Only the
+// parts quoted inside the braces are matched literally, the double quotes around
+// the expansion do not apply to the pattern
+func (p *Parser) parsePattern() (words.Expander, error) {
+	quoted := p.quoted
+	p.quoted = false
+	defer func() {
+		p.quoted = quoted
+	}()
+	return p.parseOperand()
+}
+
+// parseOperand parses the word given to the operator of a parameter expansion
+// until the end of the expansion or the separator of the replace operator
+func (p *Parser) parseOperand() (words.Expander, error) {
+	var list words.ExpandMulti
+	list.Quoted = p.quoted
+	for !p.done() && p.curr.Type != token.EndExp && p.curr.Type != token.Replace {
+		var (
+			next words.Expander
+			err  error
+		)
+		switch p.curr.Type {
+		case token.Literal:
+			next, err = p.parseLiteral()
+		case token.Variable:
+			next, err = p.parseVariable()
+		case token.Quote:
+			next, err = p.parseQuote()
+		case token.BegExp:
+			next, err = p.parseExpansion()
+		case token.BegSub:
+			next, err = p.parseSubstitution()
+		case token.BegMath:
+			next, err = p.parseArithmetic()
+		default:
+			err = p.unexpected()
+		}
+		if err != nil {
+			return nil, err
+		}
+		list.List = append(list.List, next)
+	}
+	return list.Expander(), nil
+}
+
 func (p *Parser) parseExpansion() (words.Expander, error) {
 	p.next()
 	if p.curr.Type == token.Length {
@@ -1177,6 +1735,9 @@ func (p *Parser) parseExpansion() (words.Expander, error) {
 		p.next()
 		return ex, nil
 	}
+	if p.curr.Type == token.Indirect {
+		return p.parseIndirect()
+	}
 	if p.curr.Type != token.Literal {
 		return nil, p.unexpected()
 	}
@@ -1186,9 +1747,29 @@ func (p *Parser) parseExpansion() (words.Expander, error) {
 		ex  words.Expander
 		err error
 	)
-	switch p.curr.Type {
-	case token.EndExp:
+	if p.curr.Type == token.EndExp {
 		ex = words.CreateVariable(ident.Literal, p.quoted)
+	} else {
+		ex, err = p.parseOperator(ident)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if p.curr.Type != token.EndExp {
+		return nil, p.unexpected()
+	}
+	p.next()
+	return ex, nil
+}
+
+// parseOperator parses the operator following the name of the parameter of an
+// expansion and its operands
+func (p *Parser) parseOperator(ident token.Token) (words.Expander, error) {
+	var (
+		ex  words.Expander
+		err error
+	)
+	switch p.curr.Type {
 	case token.Slice:
 		ex, err = p.parseSlice(ident)
 	case token.TrimSuffix, token.TrimSuffixLong, token.TrimPrefix, token.TrimPrefixLong:
@@ -1199,29 +1780,55 @@ func (p *Parser) parseExpansion() (words.Expander, error) {
 		ex, err = p.parseLower(ident)
 	case token.Upper, token.UpperAll:
 		ex, err = p.parseUpper(ident)
+	case token.QuoteVal, token.EscapeVal, token.PromptVal, token.AssignVal:
+		ex, err = p.parseTransform(ident)
+	case token.UpperVal, token.LowerVal, token.UpperFirst:
+		ex, err = p.parseTransform(ident)
 	case token.PadLeft, token.PadRight:
 		ex, err = p.parsePadding(ident)
-	case token.ValIfUnset:
-		p.next()
-		ex = words.CreateValIfUnset(ident.Literal, p.curr.Literal, p.quoted)
-		p.next()
-	case token.SetValIfUnset:
-		p.next()
-		ex = words.CreateSetValIfUnset(ident.Literal, p.curr.Literal, p.quoted)
-		p.next()
-	case token.ValIfSet:
-		p.next()
-		ex = words.CreateExpandValIfSet(ident.Literal, p.curr.Literal, p.quoted)
-		p.next()
-	case token.ExitIfUnset:
-		p.next()
-		ex = words.CreateExpandExitIfUnset(ident.Literal, p.curr.Literal, p.quoted)
-		p.next()
+	case token.ValIfUnset, token.SetValIfUnset, token.ValIfSet, token.ExitIfUnset:
+		ex, err = p.parseValIf(ident)
+	case token.ValIfUndef, token.SetValIfUndef, token.ValIfDef, token.ExitIfUndef:
+		ex, err = p.parseValIf(ident)
 	default:
 		err = p.unexpected()
 	}
-	if err != nil {
-		return nil, err
+	return ex, err
+}
+
+// parseIndirect parses ${!ident}, ${!prefix*} and ${!prefix@}. The operators
+// of the other expansions can follow the name of an indirect expansion (eg:
+// ${!ident:-default}) and apply to the variable it refers to
+func (p *Parser) parseIndirect() (words.Expander, error) {
+	p.next()
+	if p.curr.Type != token.Literal {
+		return nil, p.unexpected()
+	}
+	ident := p.curr
+	if x := strings.IndexFunc(ident.Literal, func(r rune) bool { return !isIdent(r) }); x > 0 && x < len(ident.Literal)-1 {
+		return nil, fmt.Errorf("shell: %s not supported with indirect expansion", ident.Literal[x:])
+	}
+	p.next()
+	var ex words.Expander
+	if n := len(ident.Literal) - 1; n > 0 && (ident.Literal[n] == '*' || ident.Literal[n] == '@') {
+		ex = words.ExpandPrefix{
+			Prefix: ident.Literal[:n],
+			All:    ident.Literal[n] == '@',
+			Quoted: p.quoted,
+		}
+	} else {
+		ind := words.ExpandIndirect{
+			Ident:  ident.Literal,
+			Quoted: p.quoted,
+		}
+		if p.curr.Type != token.EndExp {
+			op, err := p.parseOperator(ident)
+			if err != nil {
+				return nil, err
+			}
+			ind.Expander = op
+		}
+		ex = ind
 	}
 	if p.curr.Type != token.EndExp {
 		return nil, p.unexpected()
@@ -1257,8 +1864,20 @@ func (p *Parser) leaveQuote() {
 }
 
 func (p *Parser) next() {
-	p.curr = p.peek
-	p.peek = p.scan.Scan()
+	if p.peeked {
+		p.curr, p.peeked = p.peek, false
+		return
+	}
+	p.curr = p.scan.Scan()
+}
+
+// lookahead gives the token following the current one. It is only scanned on
+// demand to not read more input than needed
+func (p *Parser) lookahead() token.Token {
+	if !p.peeked {
+		p.peek, p.peeked = p.scan.Scan(), true
+	}
+	return p.peek
 }
 
 func (p *Parser) done() bool {
@@ -1272,5 +1891,8 @@ func (p *Parser) skipBlank() {
 }
 
 func (p *Parser) unexpected() error {
+	if p.done() || (p.curr.Type == token.Invalid && p.scan.done()) {
+		return fmt.Errorf("shell: unexpected end of input: %w", ErrIncomplete)
+	}
 	return fmt.Errorf("shell: unexpected token %s", p.curr)
 }
@@ -5,6 +5,7 @@ import (
 	"io"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/midbel/tish/internal/parser"
 )
@@ -42,6 +43,14 @@ var list = []struct {
 		Input: `foobar="foo"; echo $foobar`,
 		Len:   2,
 	},
+	{
+		Input: `FOO=foo BAR="bar" env | grep FOO`,
+		Len:   1,
+	},
+	{
+		Input: `FOO=foo BAR=; echo $FOO`,
+		Len:   2,
+	},
 	{
 		Input: `echo foobar | cat | cut -d b -f 1`,
 		Len:   1,
@@ -82,6 +91,26 @@ var list = []struct {
 		Input: `for ident in {1..5}; do echo $ident else echo zero; done`,
 		Len:   1,
 	},
+	{
+		Input: `for ((i=0; i<5; i++)); do echo $i; done`,
+		Len:   1,
+	},
+	{
+		Input: `for (( ; ; )) do break; else echo zero; done`,
+		Len:   1,
+	},
+	{
+		Input: `(( x = 1 + 2; y-- )) && echo $x; ((x++))`,
+		Len:   2,
+	},
+	{
+		Input: `select ident in foo bar; do echo $ident; break; done`,
+		Len:   1,
+	},
+	{
+		Input: "select ident\ndo echo $ident\ndone",
+		Len:   1,
+	},
 	{
 		Input: `while true; do echo foo; done`,
 		Len:   1,
@@ -142,6 +171,50 @@ var list = []struct {
 		Input: "[[ $var ]]",
 		Len:   1,
 	},
+	{
+		Input: "[[ -d $dir ]] && echo $dir || echo none",
+		Len:   1,
+	},
+	{
+		Input: "! cat foo | grep -q bar; echo $PIPESTATUS",
+		Len:   2,
+	},
+	{
+		Input: "time cat foo |& grep bar && echo ok",
+		Len:   1,
+	},
+	{
+		Input: "time -p ! true",
+		Len:   1,
+	},
+	{
+		Input: "{ echo foo; echo bar; } > foobar.out",
+		Len:   1,
+	},
+	{
+		Input: "{ echo foo; echo bar; } | cat && { cat foo; }",
+		Len:   1,
+	},
+	{
+		Input: "greet() { echo hello $1; }; greet world",
+		Len:   2,
+	},
+	{
+		Input: "function greet { echo hello $1; return 1; }",
+		Len:   1,
+	},
+	{
+		Input: "function greet() {\n\techo hello $1\n}",
+		Len:   1,
+	},
+	{
+		Input: "echo foo |\n\ttr o a",
+		Len:   1,
+	},
+	{
+		Input: "true &&\n\necho foo ||\necho bar",
+		Len:   1,
+	},
 }
 
 func TestParse(t *testing.T) {
@@ -153,6 +226,119 @@ func TestParse(t *testing.T) {
 	}
 }
 
+func TestParseIncomplete(t *testing.T) {
+	data := []string{
+		"echo 'foo",
+		"echo \"foo",
+		"echo $(echo foo",
+		"echo ${foo",
+		"echo foo |",
+		"echo foo &&",
+		"(echo foo",
+		"if true; then echo foo",
+		"for i in 1 2 3; do\necho $i\n",
+	}
+	for _, in := range data {
+		_, err := parser.NewParser(strings.NewReader(in)).Parse()
+		if !errors.Is(err, parser.ErrIncomplete) {
+			t.Errorf("%q: expected incomplete input error! got %v", in, err)
+		}
+	}
+}
+
+func TestParseIndirect(t *testing.T) {
+	data := []string{
+		"echo ${!foo:-bar}",
+		"echo ${!foo-bar}",
+		"echo ${!foo#bar}",
+		"echo ${!foo/bar/baz}",
+		"echo ${!foo@Q}",
+		"echo ${!foo:1:2}",
+		"echo ${!#} ${!?} ${!@} ${!foo@} ${!foo*}",
+	}
+	for _, in := range data {
+		if _, err := parser.NewParser(strings.NewReader(in)).Parse(); err != nil {
+			t.Errorf("%q: unexpected error: %s", in, err)
+		}
+	}
+	if _, err := parser.NewParser(strings.NewReader("echo ${!foo*bar}")).Parse(); err == nil || !strings.Contains(err.Error(), "indirect expansion") {
+		t.Errorf("expected unsupported indirect expansion error! got %v", err)
+	}
+	if _, err := parser.NewParser(strings.NewReader("echo ${!foo")).Parse(); !errors.Is(err, parser.ErrIncomplete) {
+		t.Errorf("expected incomplete input error! got %v", err)
+	}
+}
+
+func TestParseStream(t *testing.T) {
+	data := []struct {
+		Input string
+		Len   int
+	}{
+		{
+			Input: "echo foo\n",
+			Len:   1,
+		},
+		{
+			Input: "echo foo; echo bar # comment\n",
+			Len:   2,
+		},
+		{
+			Input: "\n\n",
+			Len:   0,
+		},
+		{
+			Input: "if true; then\n",
+			Len:   0,
+		},
+		{
+			Input: "echo foo\nfi\n",
+			Len:   1,
+		},
+		{
+			Input: "echo foo \\\n",
+			Len:   0,
+		},
+		{
+			Input: "bar\n",
+			Len:   1,
+		},
+	}
+	var (
+		rs     = make(chan error)
+		pr, pw = io.Pipe()
+		p      = parser.NewParser(pr)
+	)
+	go func() {
+		defer close(rs)
+		for {
+			_, err := p.Parse()
+			rs <- err
+			if err != nil {
+				return
+			}
+		}
+	}()
+	for _, d := range data {
+		if _, err := io.WriteString(pw, d.Input); err != nil {
+			t.Fatalf("%q: fail to write input: %s", d.Input, err)
+		}
+		for i := 0; i < d.Len; i++ {
+			select {
+			case err := <-rs:
+				if err != nil {
+					t.Fatalf("%q: expected no error parsing! got %s", d.Input, err)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("%q: statement not available before next input", d.Input)
+			}
+		}
+	}
+	pw.Close()
+	if err := <-rs; !errors.Is(err, io.EOF) {
+		t.Fatalf("expected end of input! got %v", err)
+	}
+}
+
 func parse(t *testing.T, in string, invalid bool) int {
 	t.Helper()
 	var (
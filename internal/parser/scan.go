@@ -1,11 +1,14 @@
 package parser
 
 import (
+	"bufio"
 	"bytes"
 	"io"
+	"strings"
 	"unicode/utf8"
 
 	"github.com/midbel/tish/internal/token"
+	"github.com/midbel/tish/internal/words"
 )
 
 var colonOps = map[rune]rune{
@@ -17,36 +20,29 @@ var colonOps = map[rune]rune{
 	rangle:   token.PadRight,
 }
 
+var valueOps = map[rune]rune{
+	minus:    token.ValIfUndef,
+	plus:     token.ValIfDef,
+	equal:    token.SetValIfUndef,
+	question: token.ExitIfUndef,
+}
+
+var transformOps = map[rune]rune{
+	'Q': token.QuoteVal,
+	'E': token.EscapeVal,
+	'P': token.PromptVal,
+	'U': token.UpperVal,
+	'L': token.LowerVal,
+	'u': token.UpperFirst,
+	'A': token.AssignVal,
+}
+
 var slashOps = map[rune]rune{
 	slash:   token.ReplaceAll,
 	percent: token.ReplaceSuffix,
 	pound:   token.ReplacePrefix,
 }
 
-var testops = map[string]rune{
-	// binary operators
-	"-eq": token.Eq,
-	"-ne": token.Ne,
-	"-lt": token.Lt,
-	"-le": token.Le,
-	"-gt": token.Gt,
-	"-ge": token.Ge,
-	"-nt": token.NewerThan,
-	"-ot": token.OlderThan,
-	"-ef": token.SameFile,
-	// unary operators
-	"-e": token.FileExists,
-	"-r": token.FileRead,
-	"-h": token.FileLink,
-	"-d": token.FileDir,
-	"-w": token.FileWrite,
-	"-s": token.FileSize,
-	"-f": token.FileRegular,
-	"-x": token.FileExec,
-	"-z": token.StrNotEmpty,
-	"-n": token.StrEmpty,
-}
-
 const (
 	zero       = 0
 	space      = ' '
@@ -81,33 +77,55 @@ const (
 	star       = '*'
 	arobase    = '@'
 	bang       = '!'
+	backquote  = '`'
 	nl         = '\n'
 	cr         = '\r'
 	tilde      = '~'
 )
 
 type Scanner struct {
-	input []byte
-	char  rune
-	curr  int
-	next  int
+	// reader gives the input line by line. It is released once exhausted
+	reader *bufio.Reader
+	input  []byte
+	char   rune
+	curr   int
+	next   int
 
 	str   bytes.Buffer
 	state scanstack
+	// ranges tells for each opened brace expansion if it is a range
+	ranges []bool
+	// raw disables the line continuations (eg: in single quotes)
+	raw bool
+	// escaped tells that the current character is escaped by a backslash
+	escaped bool
+	// eol tells that the current newline ends a list. It is only consumed when
+	// the next token is requested to not wait for the next line of the input
+	eol bool
 }
 
 func Scan(r io.Reader) *Scanner {
-	buf, _ := io.ReadAll(r)
 	s := Scanner{
-		input: buf,
-		state: defaultStack(),
+		reader: bufio.NewReader(r),
+		state:  defaultStack(),
 	}
-	s.read()
 	return &s
 }
 
 func (s *Scanner) Scan() token.Token {
 	s.reset()
+	switch {
+	case s.next == 0:
+		s.read()
+	case s.eol:
+		s.eol = false
+		if s.state.Len() == 1 {
+			s.discard()
+		}
+		s.read()
+		s.skipNL()
+	default:
+	}
 	var tok token.Token
 	if s.char == zero || s.char == utf8.RuneError {
 		tok.Type = token.EOF
@@ -121,7 +139,27 @@ func (s *Scanner) Scan() token.Token {
 		s.scanTest(&tok)
 		return tok
 	}
+	if s.state.Regex() {
+		s.scanRegex(&tok)
+		return tok
+	}
+	if s.state.Value() {
+		s.scanValue(&tok)
+		return tok
+	}
+	if s.state.Pattern() {
+		s.scanPattern(&tok)
+		return tok
+	}
 	switch {
+	case isSpecial(s.char) && s.state.Expansion() && s.peek() == rcurly && s.afterIndirect():
+		tok.Type = token.Literal
+		tok.Literal = string(s.char)
+		s.read()
+	case (isValueOp(s.char) || s.char == arobase) && s.state.Expansion() && s.afterIdent():
+		s.scanOperator(&tok)
+	case s.char == bang && s.state.Expansion() && s.prev() == lcurly && s.peek() != rcurly:
+		s.scanOperator(&tok)
 	case isBraces(s.char) && s.state.AcceptBraces():
 		s.scanBraces(&tok)
 	case isList(s.char) && s.state.Braces():
@@ -135,17 +173,17 @@ func (s *Scanner) Scan() token.Token {
 		s.scanSequence(&tok)
 	case isRedirectBis(s.char, s.peek()) && !s.state.Quoted():
 		s.scanRedirect(&tok)
-	case isAssign(s.char) && !s.state.Quoted():
+	case isAssign(s.char) && !isAssign(s.peek()) && !s.state.Quoted():
 		s.scanAssignment(&tok)
 	case isDouble(s.char):
 		s.scanQuote(&tok)
-	case isSingle(s.char):
+	case isSingle(s.char) && !s.state.Quoted():
 		s.scanString(&tok)
-	case isComment(s.char):
+	case isComment(s.char) && s.atWordStart() && !s.state.Quoted():
 		s.scanComment(&tok)
 	case isVariable(s.char):
 		s.scanDollar(&tok)
-	case isTest(s.char, s.peek()):
+	case s.isTestDelimiter():
 		s.scanTest(&tok)
 	default:
 		s.scanLiteral(&tok)
@@ -153,11 +191,26 @@ func (s *Scanner) Scan() token.Token {
 	return tok
 }
 
+// isTestDelimiter reports whether the scanner is at the start or at the end of
+// a [[ ]] command. A double bracket followed by a colon starts a character class
+// of a pattern (eg: [[:alpha:]]) and the closing double brackets only end a
+// word within a test
+func (s *Scanner) isTestDelimiter() bool {
+	if !isTest(s.char, s.peek()) {
+		return false
+	}
+	if s.char == rsquare {
+		return s.state.Test() && s.prev() != colon
+	}
+	r, _ := utf8.DecodeRune(s.input[s.next+1:])
+	return r != colon
+}
+
 func (s *Scanner) scanTest(tok *token.Token) {
 	tok.Type = token.Invalid
 	var skip bool
 	switch k := s.peek(); {
-	case s.char == lsquare && s.char == k:
+	case s.char == lsquare && s.char == k && s.isTestDelimiter():
 		s.read()
 		tok.Type = token.BegTest
 		s.state.EnterTest()
@@ -178,6 +231,15 @@ func (s *Scanner) scanTest(tok *token.Token) {
 	case s.char == equal && k == s.char:
 		tok.Type = token.Eq
 		s.read()
+	case s.char == equal && k == tilde:
+		tok.Type = token.ReMatch
+		s.read()
+		s.read()
+		s.skipBlank()
+		s.state.EnterRegex()
+		return
+	case s.char == equal && isBlank(k):
+		tok.Type = token.Eq
 	case s.char == bang && k == equal:
 		tok.Type = token.Ne
 		s.read()
@@ -210,7 +272,7 @@ func (s *Scanner) scanTest(tok *token.Token) {
 		s.scanLiteral(tok)
 		skip = true
 
-		if k, ok := testops[tok.Literal]; ok {
+		if k, ok := token.LookupTest(tok.Literal); ok {
 			tok.Type = k
 		}
 	}
@@ -222,6 +284,49 @@ func (s *Scanner) scanTest(tok *token.Token) {
 	}
 }
 
+// scanRegex scans the right operand of =~ in a [[ ]] test. Blanks end the
+// operand only outside of parentheses and the special characters of the test
+// lose their meaning
+func (s *Scanner) scanRegex(tok *token.Token) {
+	if s.done() || isBlank(s.char) || isTest(s.char, s.peek()) {
+		s.state.LeaveRegex()
+		s.skipBlank()
+		s.scanTest(tok)
+		return
+	}
+	switch k := s.peek(); {
+	case isDouble(s.char):
+		s.scanQuote(tok)
+	case isSingle(s.char):
+		s.scanString(tok)
+	case isVariable(s.char) && (isLetter(k) || k == lcurly || k == lparen):
+		s.scanDollar(tok)
+	default:
+		var depth int
+		for !s.done() && !isQuote(s.char) {
+			if depth == 0 && (isBlank(s.char) || isTest(s.char, s.peek())) {
+				break
+			}
+			if isVariable(s.char) && (isLetter(s.peek()) || s.peek() == lcurly || s.peek() == lparen) {
+				break
+			}
+			switch s.char {
+			case lparen:
+				depth++
+			case rparen:
+				depth--
+			case backslash:
+				s.write()
+				s.read()
+			}
+			s.write()
+			s.read()
+		}
+		tok.Type = token.Literal
+		tok.Literal = s.string()
+	}
+}
+
 func (s *Scanner) scanArithmetic(tok *token.Token) {
 	s.skipBlank()
 	switch {
@@ -231,6 +336,9 @@ func (s *Scanner) scanArithmetic(tok *token.Token) {
 		s.scanDigit(tok)
 	case isLetter(s.char):
 		s.scanVariable(tok)
+	case isVariable(s.char) && (isIdent(s.peek()) || isSpecial(s.peek())):
+		s.read()
+		s.scanVariable(tok)
 	default:
 		tok.Type = token.Invalid
 	}
@@ -258,14 +366,13 @@ func (s *Scanner) scanVariable(tok *token.Token) {
 		tok.Literal = "!"
 		s.read()
 	case isDigit(s.char):
-		for isDigit(s.char) {
-			s.write()
-			s.read()
-		}
-		tok.Literal = s.string()
+		tok.Literal = string(s.char)
+		s.read()
 	default:
-		if !isLetter(s.char) {
-			tok.Type = token.Invalid
+		if !isLetter(s.char) && s.char != underscore {
+			// a dollar not followed by a name is kept as is
+			tok.Type = token.Literal
+			tok.Literal = string(dollar)
 			return
 		}
 		for isIdent(s.char) {
@@ -297,6 +404,8 @@ func (s *Scanner) scanMath(tok *token.Token) {
 	switch s.char {
 	case semicolon:
 		tok.Type = token.List
+	case comma:
+		tok.Type = token.Comma
 	case caret:
 		tok.Type = token.BitXor
 	case tilde:
@@ -385,6 +494,12 @@ func (s *Scanner) scanMath(tok *token.Token) {
 	default:
 		tok.Type = token.Invalid
 	}
+	if op, ok := compoundOps[tok.Type]; ok && s.peek() == equal {
+		// compound assignment (eg: +=): the operator is kept as literal
+		tok.Type = token.Assign
+		tok.Literal = op
+		s.read()
+	}
 	s.read()
 }
 
@@ -392,37 +507,185 @@ func (s *Scanner) scanQuote(tok *token.Token) {
 	tok.Type = token.Quote
 	s.read()
 	s.state.ToggleQuote()
-	if s.state.Quoted() {
+	if s.state.Quoted() || s.state.Operand() {
 		return
 	}
 	s.skipBlankUntil(func(r rune) bool {
-		return isSequence(r) || isAssign(r) || isComment(r) || isRedirectBis(r, s.peek())
+		return isSequence(r) || (isAssign(r) && !isAssign(s.peek())) || isComment(r) || isRedirectBis(r, s.peek())
 	})
 }
 
 func (s *Scanner) scanBraces(tok *token.Token) {
 	switch k := s.peek(); {
+	case s.char == lcurly && (isBlank(k) || isNL(k)) && !s.state.Braces():
+		tok.Type = token.BegGroup
+	case s.char == rcurly && !s.state.Braces():
+		tok.Type = token.EndGroup
 	case s.char == rcurly:
 		tok.Type = token.EndBrace
 		s.state.LeaveBrace()
-	case s.char == lcurly && k != rcurly:
+		if n := len(s.ranges); n > 0 {
+			s.ranges = s.ranges[:n-1]
+		}
+	case s.char == lcurly && s.isBraceExpansion():
+		_, rg := s.braceExpansion()
 		tok.Type = token.BegBrace
 		s.state.EnterBrace()
+		s.ranges = append(s.ranges, rg)
 	default:
 		s.scanLiteral(tok)
 		return
 	}
 	s.read()
-	s.skipBlank()
+	if tok.Type == token.BegGroup || tok.Type == token.EndGroup {
+		s.skipBlank()
+	}
+}
+
+func (s *Scanner) isBraceExpansion() bool {
+	ok, _ := s.braceExpansion()
+	return ok
+}
+
+// braceExpansion looks ahead from the current opening brace for its closing
+// brace. The braces are a brace expansion only when they enclose a comma or a
+// valid range. Otherwise they are kept as is in the word like bash does
+func (s *Scanner) braceExpansion() (bool, bool) {
+	var (
+		str   = s.input[s.curr:]
+		depth int
+		start int
+		list  bool
+	)
+	for i := 0; i < len(str); {
+		r, n := utf8.DecodeRune(str[i:])
+		switch {
+		case r == backslash:
+			_, z := utf8.DecodeRune(str[i+n:])
+			n += z
+		case isQuote(r):
+			x := matchQuote(str[i+n:], r)
+			if x < 0 {
+				return s.braceExpansionMore()
+			}
+			n += x
+		case r == dollar && i+n < len(str) && str[i+n] == lparen:
+			x := matchParen(str[i+n:])
+			if x < 0 {
+				return s.braceExpansionMore()
+			}
+			n += x
+		case isBlank(r) || isNL(r) || isRedirect(r) || (isSequence(r) && r != comma):
+			return false, false
+		case r == lcurly:
+			depth++
+			if depth == 1 {
+				start = i + n
+			}
+		case r == rcurly:
+			depth--
+			if depth == 0 {
+				if list {
+					return true, false
+				}
+				ok := isBraceRange(string(str[start:i]))
+				return ok, ok
+			}
+		case r == comma && depth == 1:
+			list = true
+		}
+		i += n
+	}
+	return s.braceExpansionMore()
+}
+
+// braceExpansionMore reads the next line of the input when the braces span
+// multiple lines (eg: line continuation, quoted string) and looks again for
+// the closing brace
+func (s *Scanner) braceExpansionMore() (bool, bool) {
+	if !s.fill(len(s.input)) {
+		return false, false
+	}
+	return s.braceExpansion()
+}
+
+func (s *Scanner) inRange() bool {
+	n := len(s.ranges)
+	return n > 0 && s.ranges[n-1]
+}
+
+func matchQuote(str []byte, quote rune) int {
+	for i := 0; i < len(str); i++ {
+		switch {
+		case rune(str[i]) == quote:
+			return i + 1
+		case str[i] == backslash && quote == dquote:
+			i++
+		}
+	}
+	return -1
+}
+
+func matchParen(str []byte) int {
+	var depth int
+	for i, b := range str {
+		switch b {
+		case lparen:
+			depth++
+		case rparen:
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return -1
+}
+
+func isBraceRange(str string) bool {
+	parts := strings.Split(str, "..")
+	if len(parts) != 2 && len(parts) != 3 {
+		return false
+	}
+	if len(parts) == 3 && !isBraceNumber(parts[2]) {
+		return false
+	}
+	if isBraceNumber(parts[0]) && isBraceNumber(parts[1]) {
+		return true
+	}
+	isChar := func(str string) bool {
+		return len(str) == 1 && isLetter(rune(str[0]))
+	}
+	return isChar(parts[0]) && isChar(parts[1])
+}
+
+func isBraceNumber(str string) bool {
+	str = strings.TrimPrefix(str, string(minus))
+	if str == "" {
+		return false
+	}
+	for _, r := range str {
+		if !isDigit(r) {
+			return false
+		}
+	}
+	return true
 }
 
 func (s *Scanner) scanList(tok *token.Token) {
 	switch k := s.peek(); {
 	case s.char == comma:
 		tok.Type = token.Seq
-	case s.char == dot && k == s.char:
+	case s.char == dot && k == s.char && s.inRange():
 		tok.Type = token.Range
 		s.read()
+	case s.char == dot && k == s.char:
+		tok.Type = token.Literal
+		tok.Literal = strings.Repeat(string(dot), 2)
+		s.read()
+	case s.char == dot:
+		tok.Type = token.Literal
+		tok.Literal = string(dot)
 	default:
 	}
 	if tok.Type == token.Invalid {
@@ -432,9 +695,15 @@ func (s *Scanner) scanList(tok *token.Token) {
 	s.skipBlank()
 }
 
+// scanAssignment records the blanks around the equal sign in the token so that
+// the parser can tell the operand of test apart from assignment
 func (s *Scanner) scanAssignment(tok *token.Token) {
 	tok.Type = token.Assign
+	tok.BlankBefore = isBlank(s.prev())
+	s.write()
 	s.read()
+	tok.BlankAfter = isBlank(s.char)
+	tok.Literal = s.string()
 	s.skipBlank()
 }
 
@@ -493,13 +762,14 @@ func (s *Scanner) scanRedirect(tok *token.Token) {
 }
 
 func (s *Scanner) scanSequence(tok *token.Token) {
+	if isNL(s.char) {
+		tok.Type = token.List
+		s.eol = true
+		return
+	}
 	switch k := s.peek(); {
 	case s.char == semicolon:
 		tok.Type = token.List
-	case s.char == nl:
-		tok.Type = token.List
-		s.skipNL()
-		return
 	case s.char == ampersand && k == s.char:
 		tok.Type = token.And
 		s.read()
@@ -519,6 +789,12 @@ func (s *Scanner) scanSequence(tok *token.Token) {
 		if s.state.Substitution() {
 			s.state.LeaveSubstitution()
 		}
+	case s.char == lparen && k == lparen:
+		tok.Type = token.BegMath
+		s.read()
+		s.read()
+		s.state.EnterArithmetic()
+		return
 	case s.char == lparen:
 		tok.Type = token.BegSub
 	case s.char == comma:
@@ -527,7 +803,15 @@ func (s *Scanner) scanSequence(tok *token.Token) {
 		tok.Type = token.Invalid
 	}
 	s.read()
-	s.skipBlank()
+	switch tok.Type {
+	case token.And, token.Or, token.Pipe, token.PipeBoth:
+		// the command following the operator can be given on the next lines
+		for isBlank(s.char) || isNL(s.char) {
+			s.read()
+		}
+	default:
+		s.skipBlank()
+	}
 }
 
 func (s *Scanner) scanOperator(tok *token.Token) {
@@ -536,6 +820,11 @@ func (s *Scanner) scanOperator(tok *token.Token) {
 		s.read()
 		return
 	}
+	if k := s.prev(); s.char == bang && k == lcurly {
+		tok.Type = token.Indirect
+		s.read()
+		return
+	}
 	switch s.char {
 	case rcurly:
 		tok.Type = token.EndExp
@@ -546,6 +835,8 @@ func (s *Scanner) scanOperator(tok *token.Token) {
 			s.read()
 			tok.Type = t
 		}
+	case minus, plus, equal, question:
+		tok.Type = valueOps[s.char]
 	case slash:
 		tok.Type = token.Replace
 		if t, ok := slashOps[s.peek()]; ok {
@@ -576,14 +867,139 @@ func (s *Scanner) scanOperator(tok *token.Token) {
 			tok.Type = token.UpperAll
 			s.read()
 		}
+	case arobase:
+		tok.Type = token.Invalid
+		if t, ok := transformOps[s.peek()]; ok {
+			s.read()
+			tok.Type = t
+		}
 	default:
 		tok.Type = token.Invalid
 	}
 	s.read()
+	switch tok.Type {
+	case token.ValIfUnset, token.SetValIfUnset, token.ValIfSet, token.ExitIfUnset:
+	case token.ValIfUndef, token.SetValIfUndef, token.ValIfDef, token.ExitIfUndef:
+	case token.TrimPrefix, token.TrimPrefixLong, token.TrimSuffix, token.TrimSuffixLong:
+		s.state.LeaveExpansion()
+		s.state.EnterPattern()
+		return
+	case token.Replace, token.ReplaceAll, token.ReplacePrefix, token.ReplaceSuffix:
+		s.state.LeaveExpansion()
+		s.state.EnterReplace()
+		return
+	default:
+		return
+	}
+	s.state.LeaveExpansion()
+	s.state.EnterValue()
+}
+
+// scanPattern scans the pattern given to the trim and replace operators. Unlike
+// scanValue, escaped characters are kept as is for the pattern matcher
+func (s *Scanner) scanPattern(tok *token.Token) {
+	switch {
+	case s.char == rcurly:
+		tok.Type = token.EndExp
+		s.state.LeavePattern()
+		s.read()
+	case s.char == slash && s.state.Replace():
+		tok.Type = token.Replace
+		s.state.LeavePattern()
+		s.state.EnterPattern()
+		s.read()
+	case isDouble(s.char):
+		s.scanQuote(tok)
+	case isSingle(s.char):
+		s.scanString(tok)
+	case isVariable(s.char):
+		s.scanDollar(tok)
+	default:
+		for !s.done() && s.char != rcurly && !isQuote(s.char) && !isVariable(s.char) {
+			if s.char == slash && s.state.Replace() {
+				break
+			}
+			if s.char == backslash && s.peek() != zero {
+				s.write()
+				s.read()
+			}
+			s.write()
+			s.read()
+		}
+		tok.Type = token.Literal
+		tok.Literal = s.string()
+	}
+}
+
+// scanValue scans the word given to the operators ${var:-word} and friends
+// where the others expansion operators lose their meaning
+func (s *Scanner) scanValue(tok *token.Token) {
+	switch {
+	case s.char == rcurly:
+		tok.Type = token.EndExp
+		s.state.LeaveValue()
+		s.read()
+	case isDouble(s.char):
+		s.scanQuote(tok)
+	case isSingle(s.char):
+		s.scanString(tok)
+	case isVariable(s.char):
+		s.scanDollar(tok)
+	default:
+		for !s.done() && s.char != rcurly && !isQuote(s.char) && !isVariable(s.char) {
+			if k := s.peek(); s.char == backslash && (canEscape(k) || k == rcurly) {
+				s.read()
+			}
+			s.write()
+			s.read()
+		}
+		tok.Type = token.Literal
+		tok.Literal = s.string()
+	}
+}
+
+// afterIdent reports whether the current character directly follows the name
+// of the parameter of an expansion (eg: ${name or ${!name). The @ ending the
+// prefix of ${!prefix@} is not an operator
+func (s *Scanner) afterIdent() bool {
+	str := s.input[:s.curr]
+	x := bytes.LastIndex(str, []byte("${"))
+	if x < 0 {
+		return false
+	}
+	str = str[x+2:]
+	if len(str) > 1 && str[0] == bang {
+		if s.char == arobase && s.peek() == rcurly {
+			return false
+		}
+		str = str[1:]
+	}
+	if len(str) == 1 && isSpecial(rune(str[0])) {
+		return true
+	}
+	if len(str) == 0 || isDigit(rune(str[0])) {
+		return len(str) > 0 && len(bytes.TrimLeft(str, "0123456789")) == 0
+	}
+	for _, b := range str {
+		if !isIdent(rune(b)) {
+			return false
+		}
+	}
+	return true
+}
+
+// afterIndirect reports whether the current character directly follows the
+// opening of an indirect expansion (eg: ${!)
+func (s *Scanner) afterIndirect() bool {
+	return bytes.HasSuffix(s.input[:s.curr], []byte("${!"))
 }
 
 func (s *Scanner) scanDollar(tok *token.Token) {
 	s.read()
+	if isSingle(s.char) && !s.state.Quoted() {
+		s.scanEscapedString(tok)
+		return
+	}
 	if !s.state.Test() {
 		if s.char == lcurly {
 			tok.Type = token.BegExp
@@ -609,36 +1025,59 @@ func (s *Scanner) scanDollar(tok *token.Token) {
 }
 
 func (s *Scanner) scanComment(tok *token.Token) {
+	s.raw = true
 	s.read()
 	s.skipBlank()
 	for !s.done() && !isNL(s.char) {
 		s.write()
 		s.read()
 	}
-	if isNL(s.char) {
-		s.read()
-	}
+	s.raw = false
+	s.eol = isNL(s.char)
 	tok.Type = token.Comment
 	tok.Literal = s.string()
 }
 
 func (s *Scanner) scanString(tok *token.Token) {
+	s.raw = true
 	s.read()
 	for !isSingle(s.char) && !s.done() {
 		s.write()
 		s.read()
 	}
+	s.endString(tok, s.string())
+}
+
+// scanEscapedString scans a string quoted with $'...'. The backslash escapes of
+// the string are decoded by the scanner
+func (s *Scanner) scanEscapedString(tok *token.Token) {
+	s.raw = true
+	s.read()
+	for !isSingle(s.char) && !s.done() {
+		if s.char == backslash && s.peek() != zero {
+			s.write()
+			s.read()
+		}
+		s.write()
+		s.read()
+	}
+	s.endString(tok, words.UnescapeQuote(s.string()))
+}
+
+func (s *Scanner) endString(tok *token.Token, str string) {
 	tok.Type = token.Literal
-	tok.Literal = s.string()
+	tok.Literal = str
+	tok.Quoted = true
 	if !isSingle(s.char) {
 		tok.Type = token.Invalid
 	}
+	s.raw = false
 	s.read()
-	if s.state.Test() {
+	if s.state.Test() || s.state.Operand() {
 		return
 	}
 	s.skipBlankUntil(func(r rune) bool {
-		return isSequence(r) || isAssign(r) || isComment(r) || isRedirectBis(r, s.peek())
+		return isSequence(r) || (isAssign(r) && !isAssign(s.peek())) || isComment(r) || isRedirectBis(r, s.peek())
 	})
 }
 
@@ -648,6 +1087,10 @@ func (s *Scanner) scanLiteral(tok *token.Token) {
 		return
 	}
 	for !s.done() && !s.stopLiteral(s.char) {
+		if isExtglob(s.char) && s.peek() == lparen && !s.state.Expansion() {
+			s.scanExtglob()
+			continue
+		}
 		if s.char == backslash && canEscape(s.peek()) {
 			s.read()
 		}
@@ -656,7 +1099,7 @@ func (s *Scanner) scanLiteral(tok *token.Token) {
 	}
 	tok.Type = token.Literal
 	tok.Literal = s.string()
-	if token.IsKeyword(tok.Literal) {
+	if token.IsKeyword(tok.Literal) && !s.state.Expansion() {
 		tok.Type = token.Keyword
 		s.skipBlank()
 	}
@@ -664,10 +1107,34 @@ func (s *Scanner) scanLiteral(tok *token.Token) {
 		return
 	}
 	s.skipBlankUntil(func(r rune) bool {
-		return isSequence(r) || isAssign(r) || isComment(r) || isRedirectBis(r, s.peek())
+		return isSequence(r) || (isAssign(r) && !isAssign(s.peek())) || isComment(r) || isRedirectBis(r, s.peek())
 	})
 }
 
+// scanExtglob writes an extended pattern like @(foo|bar) in the literal being
+// scanned. The parentheses and the pipes of the pattern are not operators
+func (s *Scanner) scanExtglob() {
+	var depth int
+	s.write()
+	s.read()
+	for !s.done() {
+		switch s.char {
+		case backslash:
+			s.write()
+			s.read()
+		case lparen:
+			depth++
+		case rparen:
+			depth--
+		}
+		s.write()
+		s.read()
+		if depth == 0 {
+			return
+		}
+	}
+}
+
 func (s *Scanner) scanQuotedLiteral(tok *token.Token) {
 	for !s.done() {
 		if isDouble(s.char) || isVariable(s.char) {
@@ -676,6 +1143,9 @@ func (s *Scanner) scanQuotedLiteral(tok *token.Token) {
 		if s.state.Expansion() && isOperator(s.char) {
 			break
 		}
+		if s.char == backslash && canEscapeQuoted(s.peek()) {
+			s.read()
+		}
 		s.write()
 		s.read()
 	}
@@ -695,27 +1165,89 @@ func (s *Scanner) string() string {
 	return s.str.String()
 }
 
+// peek returns the character following the current one. It never looks past a
+// newline to not wait for the next line of the input
 func (s *Scanner) peek() rune {
-	r, _ := utf8.DecodeRune(s.input[s.next:])
+	if isNL(s.char) {
+		return zero
+	}
+	next := s.next
+	if !s.raw && (s.char != backslash || s.escaped) {
+		next = s.skipContinuation(next)
+	}
+	if !s.fill(next) {
+		return zero
+	}
+	r, _ := utf8.DecodeRune(s.input[next:])
 	return r
 }
 
+// atWordStart reports whether the current character starts a new word
+func (s *Scanner) atWordStart() bool {
+	if s.curr == 0 {
+		return true
+	}
+	switch r := s.prev(); {
+	case isBlank(r) || isNL(r):
+		return true
+	case r == semicolon || r == ampersand || r == pipe || r == lparen || r == rparen:
+		return true
+	default:
+		return false
+	}
+}
+
 func (s *Scanner) prev() rune {
 	r, _ := utf8.DecodeLastRune(s.input[:s.curr])
 	return r
 }
 
+// read moves to the next character of the input. A backslash followed by a
+// newline is a line continuation: both characters are skipped unless the
+// backslash is itself escaped or the scanner is in raw mode
 func (s *Scanner) read() {
-	if s.curr >= len(s.input) {
-		s.char = 0
+	var (
+		esc  = s.char == backslash && !s.escaped
+		next = s.next
+	)
+	if !esc && !s.raw {
+		next = s.skipContinuation(next)
+	}
+	if !s.fill(next) {
+		s.char, s.curr, s.next = zero, len(s.input), len(s.input)
 		return
 	}
-	r, n := utf8.DecodeRune(s.input[s.next:])
-	if r == utf8.RuneError {
-		s.char = 0
-		s.next = len(s.input)
+	r, n := utf8.DecodeRune(s.input[next:])
+	s.char, s.curr, s.next = r, next, next+n
+	s.escaped = esc
+}
+
+func (s *Scanner) skipContinuation(pos int) int {
+	for s.fill(pos) && s.input[pos] == backslash && s.fill(pos+1) && s.input[pos+1] == nl {
+		pos += 2
+	}
+	return pos
+}
+
+// fill reads the input line by line until pos is available in the buffer. It
+// reports whether pos is in the input
+func (s *Scanner) fill(pos int) bool {
+	for pos >= len(s.input) && s.reader != nil {
+		line, err := s.reader.ReadBytes(nl)
+		s.input = append(s.input, line...)
+		if err != nil {
+			s.reader = nil
+		}
 	}
-	s.char, s.curr, s.next = r, s.next, s.next+n
+	return pos < len(s.input)
+}
+
+// discard drops the already scanned input except the current character
+func (s *Scanner) discard() {
+	n := copy(s.input, s.input[s.curr:])
+	s.input = s.input[:n]
+	s.next -= s.curr
+	s.curr = 0
 }
 
 func (s *Scanner) done() bool {
@@ -755,22 +1287,57 @@ func (s *Scanner) stopLiteral(r rune) bool {
 	if s.state.Braces() && (s.char == dot || s.char == comma || s.char == rcurly) {
 		return true
 	}
-	if s.state.Expansion() && isOperator(r) {
+	if s.state.Expansion() && (isOperator(r) || ((isValueOp(r) || r == arobase) && s.afterIdent())) {
 		return true
 	}
 	if s.char == lcurly {
-		return s.peek() != rcurly
+		return s.isBraceExpansion()
 	}
 	if isTest(s.char, s.peek()) {
-		return true
+		return s.isTestDelimiter()
+	}
+	if isAssign(s.char) {
+		return isTarget(s.string())
 	}
-	ok := isBlank(s.char) || isSequence(s.char) || isDouble(s.char) ||
-		isVariable(s.char) || isAssign(s.char)
+	if s.char == comma {
+		return false
+	}
+	ok := isBlank(s.char) || isSequence(s.char) || isDouble(s.char) || isSingle(s.char) ||
+		isVariable(s.char)
 	return ok
 }
 
+// isTarget reports whether str can be the left side of an assignment: an
+// identifier optionally followed by an index between brackets
+func isTarget(str string) bool {
+	if x := strings.IndexRune(str, lsquare); x > 0 && strings.HasSuffix(str, "]") {
+		str = str[:x]
+	}
+	if str == "" || isDigit(rune(str[0])) {
+		return false
+	}
+	for _, r := range str {
+		if !isIdent(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// canEscapeQuoted reports whether r keeps its literal value when preceded by a
+// backslash between double quotes
+func canEscapeQuoted(r rune) bool {
+	return r == backslash || r == dquote || r == dollar || r == backquote
+}
+
 func canEscape(r rune) bool {
-	return r == backslash || r == semicolon || r == dquote || r == dollar
+	switch r {
+	case backslash, semicolon, dquote, squote, dollar, space, tab:
+	case lparen, rparen, langle, rangle, pipe, ampersand, bang:
+	default:
+		return false
+	}
+	return true
 }
 
 func isBlank(r rune) bool {
@@ -809,6 +1376,20 @@ func isDigit(r rune) bool {
 	return r >= '0' && r <= '9'
 }
 
+func isValueOp(r rune) bool {
+	_, ok := valueOps[r]
+	return ok
+}
+
+func isSpecial(r rune) bool {
+	switch r {
+	case dollar, pound, question, star, arobase, bang:
+		return true
+	default:
+		return false
+	}
+}
+
 func isOperator(r rune) bool {
 	switch r {
 	case caret, pound, colon, slash, percent, comma, rcurly:
@@ -858,6 +1439,10 @@ func isBraces(r rune) bool {
 	return r == lcurly || r == rcurly
 }
 
+func isExtglob(r rune) bool {
+	return r == question || r == star || r == plus || r == arobase || r == bang
+}
+
 func isList(r rune) bool {
 	return r == comma || r == dot
 }
@@ -866,9 +1451,24 @@ func isNL(r rune) bool {
 	return r == cr || r == nl
 }
 
+// compoundOps gives the operators that can be combined with an assignment in
+// an arithmetic expression
+var compoundOps = map[rune]string{
+	token.Add:        "+",
+	token.Sub:        "-",
+	token.Mul:        "*",
+	token.Div:        "/",
+	token.Mod:        "%",
+	token.LeftShift:  "<<",
+	token.RightShift: ">>",
+	token.BitAnd:     "&",
+	token.BitOr:      "|",
+	token.BitXor:     "^",
+}
+
 func isMath(r rune) bool {
 	switch r {
-	case lparen, rparen, plus, minus, star, slash, percent, langle, rangle, equal, bang, ampersand, pipe, question, colon, caret, semicolon, tilde:
+	case lparen, rparen, plus, minus, star, slash, percent, langle, rangle, equal, bang, ampersand, pipe, question, colon, caret, semicolon, tilde, comma:
 		return true
 	default:
 		return false
@@ -885,6 +1485,10 @@ const (
 	scanBrace
 	scanMath
 	scanTest
+	scanValue
+	scanPattern
+	scanReplace
+	scanRegex
 )
 
 func (s scanState) String() string {
@@ -905,6 +1509,14 @@ func (s scanState) String() string {
 		return "arithmetic"
 	case scanTest:
 		return "test"
+	case scanValue:
+		return "value"
+	case scanPattern:
+		return "pattern"
+	case scanReplace:
+		return "replace"
+	case scanRegex:
+		return "regex"
 	}
 }
 
@@ -956,6 +1568,63 @@ func (s *scanstack) LeaveExpansion() {
 	}
 }
 
+func (s *scanstack) Value() bool {
+	return s.Curr() == scanValue
+}
+
+func (s *scanstack) EnterValue() {
+	s.Push(scanValue)
+}
+
+func (s *scanstack) LeaveValue() {
+	if s.Value() {
+		s.Pop()
+	}
+}
+
+func (s *scanstack) Pattern() bool {
+	curr := s.Curr()
+	return curr == scanPattern || curr == scanReplace
+}
+
+func (s *scanstack) Replace() bool {
+	return s.Curr() == scanReplace
+}
+
+func (s *scanstack) EnterPattern() {
+	s.Push(scanPattern)
+}
+
+func (s *scanstack) EnterReplace() {
+	s.Push(scanReplace)
+}
+
+func (s *scanstack) LeavePattern() {
+	if s.Pattern() {
+		s.Pop()
+	}
+}
+
+// Operand reports whether the scanner is in the word that follows the
+// operator of a parameter expansion
+func (s *scanstack) Operand() bool {
+	return s.Value() || s.Pattern() || s.Regex()
+}
+
+func (s *scanstack) Regex() bool {
+	return s.Curr() == scanRegex
+}
+
+func (s *scanstack) EnterRegex() {
+	s.Push(scanRegex)
+}
+
+func (s *scanstack) LeaveRegex() {
+	if s.Regex() {
+		s.Pop()
+	}
+}
+
 func (s *scanstack) Arithmetic() bool {
 	return s.Curr() == scanMath
 }
@@ -20,6 +20,10 @@ var tokens = []struct {
 		Input:  `echo "$foobar" # a comment`,
 		Tokens: []rune{token.Literal, token.Blank, token.Quote, token.Variable, token.Quote, token.Comment},
 	},
+	{
+		Input:  `echo $10 ${10}`,
+		Tokens: []rune{token.Literal, token.Blank, token.Variable, token.Literal, token.Blank, token.BegExp, token.Literal, token.EndExp},
+	},
 	{
 		Input:  `echo err 2> err.txt`,
 		Tokens: []rune{token.Literal, token.Blank, token.Literal, token.RedirectErr, token.Literal},
@@ -60,6 +64,30 @@ var tokens = []struct {
 		Input:  `echo -F'/'`,
 		Tokens: []rune{token.Literal, token.Blank, token.Literal, token.Literal},
 	},
+	{
+		Input:  `echo ${foo-bar}`,
+		Tokens: []rune{token.Literal, token.Blank, token.BegExp, token.Literal, token.ValIfUndef, token.Literal, token.EndExp},
+	},
+	{
+		Input:  `echo ${foo:?must be set}`,
+		Tokens: []rune{token.Literal, token.Blank, token.BegExp, token.Literal, token.ExitIfUnset, token.Literal, token.EndExp},
+	},
+	{
+		Input:  `echo ${foo:-$bar}`,
+		Tokens: []rune{token.Literal, token.Blank, token.BegExp, token.Literal, token.ValIfUnset, token.Variable, token.EndExp},
+	},
+	{
+		Input:  `echo ${path##*/}`,
+		Tokens: []rune{token.Literal, token.Blank, token.BegExp, token.Literal, token.TrimPrefixLong, token.Literal, token.EndExp},
+	},
+	{
+		Input:  `echo ${path//a b/$c}`,
+		Tokens: []rune{token.Literal, token.Blank, token.BegExp, token.Literal, token.ReplaceAll, token.Literal, token.Replace, token.Variable, token.EndExp},
+	},
+	{
+		Input:  `[[ $x =~ ^(a| b)$ ]]`,
+		Tokens: []rune{token.BegTest, token.Variable, token.ReMatch, token.Literal, token.EndTest},
+	},
 	{
 		Input:  `[[test]]`,
 		Tokens: []rune{token.BegTest, token.Literal, token.EndTest},
@@ -72,6 +100,38 @@ var tokens = []struct {
 		Input:  `if [[-s testdata/foobar.txt]]; then echo ok fi`,
 		Tokens: []rune{token.Keyword, token.BegTest, token.FileSize, token.Literal, token.EndTest, token.List, token.Keyword, token.Literal, token.Blank, token.Literal, token.Blank, token.Keyword},
 	},
+	{
+		Input:  `echo $'a\tb' "$'c'"`,
+		Tokens: []rune{token.Literal, token.Blank, token.Literal, token.Blank, token.Quote, token.Literal, token.Literal, token.Quote},
+	},
+	{
+		Input:  `echo @(foo|bar).go [[:alpha:]]*`,
+		Tokens: []rune{token.Literal, token.Blank, token.Literal, token.Blank, token.Literal},
+	},
+	{
+		Input:  `[[ x1 == [[:alpha:]][[:digit:]] ]]`,
+		Tokens: []rune{token.BegTest, token.Literal, token.Eq, token.Literal, token.EndTest},
+	},
+	{
+		Input:  `test foo == bar -a ! -z foo`,
+		Tokens: []rune{token.Literal, token.Blank, token.Literal, token.Blank, token.Literal, token.Blank, token.Literal, token.Blank, token.Literal, token.Blank, token.Literal, token.Blank, token.Literal, token.Blank, token.Literal},
+	},
+	{
+		Input:  `foo = bar`,
+		Tokens: []rune{token.Literal, token.Assign, token.Literal},
+	},
+	{
+		Input:  `echo ${foo@Q} ${@@A} ${1@u}`,
+		Tokens: []rune{token.Literal, token.Blank, token.BegExp, token.Literal, token.QuoteVal, token.EndExp, token.Blank, token.BegExp, token.Literal, token.AssignVal, token.EndExp, token.Blank, token.BegExp, token.Literal, token.UpperFirst, token.EndExp},
+	},
+	{
+		Input:  `echo ${!foo} ${!foo_*} ${!}`,
+		Tokens: []rune{token.Literal, token.Blank, token.BegExp, token.Indirect, token.Literal, token.EndExp, token.Blank, token.BegExp, token.Indirect, token.Literal, token.EndExp, token.Blank, token.BegExp, token.Literal, token.EndExp},
+	},
+	{
+		Input:  `echo a'b c'd`,
+		Tokens: []rune{token.Literal, token.Blank, token.Literal, token.Literal, token.Literal},
+	},
 }
 
 func TestScan(t *testing.T) {
@@ -95,3 +155,212 @@ func TestScan(t *testing.T) {
 		})
 	}
 }
+
+func TestScanAssign(t *testing.T) {
+	data := []struct {
+		Input  string
+		Before bool
+		After  bool
+	}{
+		{Input: "foo=bar"},
+		{Input: "foo= bar", After: true},
+		{Input: "foo =bar", Before: true},
+		{Input: "foo = bar", Before: true, After: true},
+	}
+	for _, d := range data {
+		scan := parser.Scan(strings.NewReader(d.Input))
+		scan.Scan()
+		tok := scan.Scan()
+		if tok.Type != token.Assign || tok.Literal != "=" {
+			t.Errorf("%q: expected assign token! got %s", d.Input, tok)
+			continue
+		}
+		if tok.BlankBefore != d.Before || tok.BlankAfter != d.After {
+			t.Errorf("%q: blanks mismatched! want %t/%t, got %t/%t", d.Input, d.Before, d.After, tok.BlankBefore, tok.BlankAfter)
+		}
+	}
+}
+
+func TestScanContinuation(t *testing.T) {
+	data := []struct {
+		State  string
+		Input  string
+		Tokens []token.Token
+	}{
+		{
+			State: "default",
+			Input: "echo foo\\\nbar a#b # comment \\\necho",
+			Tokens: []token.Token{
+				createToken("echo", token.Literal),
+				createToken("", token.Blank),
+				createToken("foobar", token.Literal),
+				createToken("", token.Blank),
+				createToken("a#b", token.Literal),
+				createToken("comment \\", token.Comment),
+				createToken("echo", token.Literal),
+			},
+		},
+		{
+			State: "default",
+			Input: "echo a\\\\\necho $x#",
+			Tokens: []token.Token{
+				createToken("echo", token.Literal),
+				createToken("", token.Blank),
+				createToken("a\\", token.Literal),
+				createToken("", token.List),
+				createToken("echo", token.Literal),
+				createToken("", token.Blank),
+				createToken("x", token.Variable),
+				createToken("#", token.Literal),
+			},
+		},
+		{
+			State: "single",
+			Input: "echo 'a\\\nb'",
+			Tokens: []token.Token{
+				createToken("echo", token.Literal),
+				createToken("", token.Blank),
+				{Literal: "a\\\nb", Type: token.Literal, Quoted: true},
+			},
+		},
+		{
+			State: "quote",
+			Input: "echo \"a\\\nb\\$x#\"",
+			Tokens: []token.Token{
+				createToken("echo", token.Literal),
+				createToken("", token.Blank),
+				createToken("", token.Quote),
+				createToken("ab$x#", token.Literal),
+				createToken("", token.Quote),
+			},
+		},
+		{
+			State: "sub",
+			Input: "$(echo \\\na)",
+			Tokens: []token.Token{
+				createToken("", token.BegSub),
+				createToken("echo", token.Literal),
+				createToken("", token.Blank),
+				createToken("a", token.Literal),
+				createToken("", token.EndSub),
+			},
+		},
+		{
+			State: "exp",
+			Input: "${x\\\n}",
+			Tokens: []token.Token{
+				createToken("", token.BegExp),
+				createToken("x", token.Literal),
+				createToken("", token.EndExp),
+			},
+		},
+		{
+			State: "brace",
+			Input: "{a,\\\nb}",
+			Tokens: []token.Token{
+				createToken("", token.BegBrace),
+				createToken("a", token.Literal),
+				createToken("", token.Seq),
+				createToken("b", token.Literal),
+				createToken("", token.EndBrace),
+			},
+		},
+		{
+			State: "math",
+			Input: "$((1+\\\n2))",
+			Tokens: []token.Token{
+				createToken("", token.BegMath),
+				createToken("1", token.Numeric),
+				createToken("", token.Add),
+				createToken("2", token.Numeric),
+				createToken("", token.EndMath),
+			},
+		},
+		{
+			State: "test",
+			Input: "[[ a ==\\\n a ]]",
+			Tokens: []token.Token{
+				createToken("", token.BegTest),
+				createToken("a", token.Literal),
+				createToken("", token.Eq),
+				createToken("a", token.Literal),
+				createToken("", token.EndTest),
+			},
+		},
+		{
+			State: "value",
+			Input: "${x:-a\\\nb}",
+			Tokens: []token.Token{
+				createToken("", token.BegExp),
+				createToken("x", token.Literal),
+				createToken("", token.ValIfUnset),
+				createToken("ab", token.Literal),
+				createToken("", token.EndExp),
+			},
+		},
+		{
+			State: "pattern",
+			Input: "${x#a\\\nb}",
+			Tokens: []token.Token{
+				createToken("", token.BegExp),
+				createToken("x", token.Literal),
+				createToken("", token.TrimPrefix),
+				createToken("ab", token.Literal),
+				createToken("", token.EndExp),
+			},
+		},
+		{
+			State: "replace",
+			Input: "${x/a\\\nb/c\\\nd}",
+			Tokens: []token.Token{
+				createToken("", token.BegExp),
+				createToken("x", token.Literal),
+				createToken("", token.Replace),
+				createToken("ab", token.Literal),
+				createToken("", token.Replace),
+				createToken("cd", token.Literal),
+				createToken("", token.EndExp),
+			},
+		},
+		{
+			State: "regex",
+			Input: "[[ a =~ a\\\nb ]]",
+			Tokens: []token.Token{
+				createToken("", token.BegTest),
+				createToken("a", token.Literal),
+				createToken("", token.ReMatch),
+				createToken("ab", token.Literal),
+				createToken("", token.EndTest),
+			},
+		},
+	}
+	for _, d := range data {
+		t.Run(d.State, func(t *testing.T) {
+			scan := parser.Scan(strings.NewReader(d.Input))
+			for i := 0; ; i++ {
+				tok := scan.Scan()
+				if tok.Type == token.EOF {
+					if i < len(d.Tokens) {
+						t.Errorf("not enough tokens generated! expected %d, got %d", len(d.Tokens), i)
+					}
+					break
+				}
+				if i >= len(d.Tokens) {
+					t.Errorf("too many token generated! expected %d, got %d", len(d.Tokens), i)
+					break
+				}
+				if tok != d.Tokens[i] {
+					t.Errorf("token mismatched %d! want %s (%q), got %s (%q)", i+1, d.Tokens[i], d.Tokens[i].Literal, tok, tok.Literal)
+					break
+				}
+			}
+		})
+	}
+}
+
+func createToken(str string, kind rune) token.Token {
+	return token.Token{
+		Literal: str,
+		Type:    kind,
+	}
+}
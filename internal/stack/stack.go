@@ -54,6 +54,13 @@ func (s *Stack[T]) RemoveLeft(n int) {
 	s.list = append(s.list[:n], s.list[n+1:]...)
 }
 
+func (s *Stack[T]) Insert(n int, item T) {
+	if n < 0 || n > s.Len() {
+		return
+	}
+	s.list = append(s.list[:n], append([]T{item}, s.list[n:]...)...)
+}
+
 func (s *Stack[T]) Push(item T) {
 	s.list = append(s.list, item)
 }
@@ -19,6 +19,10 @@ const (
 	KwEsac     = "esac"
 	KwBreak    = "break"
 	KwContinue = "continue"
+	KwNot      = "!"
+	KwTime     = "time"
+	KwFunction = "function"
+	KwSelect   = "select"
 )
 
 var list = []string{
@@ -38,11 +42,27 @@ var list = []string{
 	KwContinue,
 }
 
+// commands are the reserved words only recognised by the parser as the first
+// word of a command. The scanner gives them as literals so that they remain
+// usable as arguments
+var commands = []string{
+	KwNot,
+	KwTime,
+	KwFunction,
+	KwSelect,
+}
+
 func init() {
 	sort.Strings(list)
+	sort.Strings(commands)
 }
 
 func IsKeyword(str string) bool {
 	i := sort.SearchStrings(list, str)
 	return i < len(list) && list[i] == str
 }
+
+func IsCommandKeyword(str string) bool {
+	i := sort.SearchStrings(commands, str)
+	return i < len(commands) && commands[i] == str
+}
@@ -0,0 +1,68 @@
+package token
+
+var tests = map[string]rune{
+	// binary operators
+	"-eq": NumEq,
+	"-ne": NumNe,
+	"-lt": NumLt,
+	"-le": NumLe,
+	"-gt": NumGt,
+	"-ge": NumGe,
+	"-nt": NewerThan,
+	"-ot": OlderThan,
+	"-ef": SameFile,
+	// unary operators
+	"-a": FileExists,
+	"-e": FileExists,
+	"-r": FileRead,
+	"-w": FileWrite,
+	"-x": FileExec,
+	"-h": FileLink,
+	"-L": FileLink,
+	"-d": FileDir,
+	"-f": FileRegular,
+	"-s": FileSize,
+	"-b": FileBlock,
+	"-c": FileChar,
+	"-p": FilePipe,
+	"-S": FileSocket,
+	"-u": FileSetuid,
+	"-g": FileSetgid,
+	"-k": FileSticky,
+	"-O": FileOwner,
+	"-G": FileGroup,
+	"-N": FileModified,
+	"-t": FileTerm,
+	"-v": VarSet,
+	"-z": StrEmpty,
+	"-n": StrNotEmpty,
+}
+
+// LookupTest gives the operator of a test corresponding to str (eg: -f, -eq)
+func LookupTest(str string) (rune, bool) {
+	op, ok := tests[str]
+	return op, ok
+}
+
+// IsUnaryTest reports whether op is an unary operator of a test
+func IsUnaryTest(op rune) bool {
+	switch op {
+	case FileExists, FileRead, FileWrite, FileExec, FileLink, FileDir, FileRegular, FileSize:
+	case FileBlock, FileChar, FilePipe, FileSocket, FileSetuid, FileSetgid, FileSticky:
+	case FileOwner, FileGroup, FileModified, FileTerm, VarSet, StrEmpty, StrNotEmpty:
+	default:
+		return false
+	}
+	return true
+}
+
+// IsBinaryTest reports whether op is a binary operator of a test
+func IsBinaryTest(op rune) bool {
+	switch op {
+	case Eq, Ne, Lt, Gt, NumEq, NumNe, NumLt, NumLe, NumGt, NumGe, ReMatch:
+	case SameFile, NewerThan, OlderThan:
+	default:
+		return false
+	}
+	return true
+}
@@ -52,6 +52,8 @@ const (
 	BitXor
 	BegSub
 	EndSub
+	BegGroup // {
+	EndGroup // }
 	Assign
 	RedirectIn   // < | 0<
 	RedirectOut  // > | 1>
@@ -67,6 +69,13 @@ const (
 	SameFile
 	OlderThan
 	NewerThan
+	NumEq   // -eq
+	NumNe   // -ne
+	NumLt   // -lt
+	NumLe   // -le
+	NumGt   // -gt
+	NumGe   // -ge
+	ReMatch // =~
 	FileExists
 	FileLink
 	FileDir
@@ -75,7 +84,20 @@ const (
 	FileRead
 	FileWrite
 	FileSize
+	FileBlock      // -b
+	FileChar       // -c
+	FilePipe       // -p
+	FileSocket     // -S
+	FileSetuid     // -u
+	FileSetgid     // -g
+	FileSticky     // -k
+	FileOwner      // -O
+	FileGroup      // -G
+	FileModified   // -N
+	FileTerm       // -t
+	VarSet         // -v
 	Length         // ${#var}
+	Indirect       // ${!var}
 	Slice          // ${var:from:to}
 	Replace        // ${var/from/to}
 	ReplaceAll     // ${var//from/to}
@@ -89,18 +111,34 @@ const (
 	LowerAll       // ${var,,}
 	Upper          // ${var^}
 	UpperAll       // ${var^^}
+	QuoteVal       // ${var@Q}
+	EscapeVal      // ${var@E}
+	PromptVal      // ${var@P}
+	UpperVal       // ${var@U}
+	LowerVal       // ${var@L}
+	UpperFirst     // ${var@u}
+	AssignVal      // ${var@A}
 	PadLeft        // ${var:<10:0}
 	PadRight       // ${var:>10:0}
 	ValIfUnset     // ${var:-val}
 	SetValIfUnset  // ${var:=val}
 	ValIfSet       // ${var:+val}
 	ExitIfUnset    // ${var:?val}
+	ValIfUndef     // ${var-val}
+	SetValIfUndef  // ${var=val}
+	ValIfDef       // ${var+val}
+	ExitIfUndef    // ${var?val}
 	Invalid
 )
 
 type Token struct {
 	Literal string
 	Type    rune
+	// literal given between single quotes or with $'...'
+	Quoted bool
+	// blanks found before and after the equal sign of an Assign token
+	BlankBefore bool
+	BlankAfter  bool
 }
 
 func (t Token) IsSequence() bool {
@@ -129,7 +167,7 @@ func (t Token) IsRedirect() bool {
 }
 
 func (t Token) Eow() bool {
-	return t.Type == EndSub || t.Type == Blank || t.IsSequence() || t.IsRedirect() || t.IsEOF() || t.IsComment()
+	return t.Type == EndSub || t.Type == EndGroup || t.Type == Blank || t.IsSequence() || t.IsRedirect() || t.IsEOF() || t.IsComment()
 }
 
 func (t Token) IsEOF() bool {
@@ -155,6 +193,20 @@ func (t Token) String() string {
 		return "<eq>"
 	case Ne:
 		return "<ne>"
+	case NumEq:
+		return "<num-eq>"
+	case NumNe:
+		return "<num-ne>"
+	case NumLt:
+		return "<num-lt>"
+	case NumLe:
+		return "<num-le>"
+	case NumGt:
+		return "<num-gt>"
+	case NumGe:
+		return "<num-ge>"
+	case ReMatch:
+		return "<re-match>"
 	case And:
 		return "<and>"
 	case Or:
@@ -167,6 +219,10 @@ func (t Token) String() string {
 		return "<beg-sub>"
 	case EndSub:
 		return "<end-sub>"
+	case BegGroup:
+		return "<beg-group>"
+	case EndGroup:
+		return "<end-group>"
 	case List:
 		return "<list>"
 	case BegExp:
@@ -219,6 +275,8 @@ func (t Token) String() string {
 		return "<sequence>"
 	case Length:
 		return "<length>"
+	case Indirect:
+		return "<indirect>"
 	case Slice:
 		return "<slice>"
 	case Replace:
@@ -245,6 +303,20 @@ func (t Token) String() string {
 		return "<upper>"
 	case UpperAll:
 		return "<upper-all>"
+	case QuoteVal:
+		return "<quote-val>"
+	case EscapeVal:
+		return "<escape-val>"
+	case PromptVal:
+		return "<prompt-val>"
+	case UpperVal:
+		return "<upper-val>"
+	case LowerVal:
+		return "<lower-val>"
+	case UpperFirst:
+		return "<upper-first>"
+	case AssignVal:
+		return "<assign-val>"
 	case PadLeft:
 		return "<padding-left>"
 	case PadRight:
@@ -257,6 +329,14 @@ func (t Token) String() string {
 		return "<val-if-set>"
 	case ExitIfUnset:
 		return "<exit-if-unset>"
+	case ValIfUndef:
+		return "<val-if-undef>"
+	case SetValIfUndef:
+		return "<set-val-if-undef>"
+	case ValIfDef:
+		return "<val-if-def>"
+	case ExitIfUndef:
+		return "<exit-if-undef>"
 	case Assign:
 		return "<assignment>"
 	case RedirectIn:
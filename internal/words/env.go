@@ -16,3 +16,68 @@ type ShellEnv interface {
 	// SetErr(io.Writer)
 	Execute(context.Context, Executer, io.Writer, io.Writer) error
 }
+
+// MathEnv is implemented by environments that can evaluate the string form of
+// an arithmetic expression (eg: operands of numeric tests)
+type MathEnv interface {
+	Environment
+	Evaluate(string) (float64, error)
+}
+
+// DirsEnv is implemented by environments keeping a stack of directories. The
+// entries of the stack are used by the ~N, ~+N and ~-N expansions
+type DirsEnv interface {
+	Environment
+	Dirs() []string
+}
+
+// StrictEnv is implemented by environments that can treat the expansion of
+// unset variables as an error
+type StrictEnv interface {
+	Environment
+	Nounset() bool
+}
+
+// NamesEnv is implemented by environments that can enumerate the names of their
+// variables, including the ones defined by their parents
+type NamesEnv interface {
+	Environment
+	Names() []string
+}
+
+// RefEnv is implemented by environments supporting name references. Reference
+// gives the name of the variable referenced by a nameref
+type RefEnv interface {
+	Environment
+	Reference(string) (string, bool)
+}
+
+// AttrEnv is implemented by environments keeping the attributes of their
+// variables. Flags gives them as the options of the declare builtin (eg: rx)
+type AttrEnv interface {
+	Environment
+	Flags(string) (string, error)
+}
+
+// GlobOptions are the options of the filename expansion
+type GlobOptions struct {
+	// patterns matching no files expand to nothing
+	Nullglob bool
+	// patterns matching no files are an error
+	Failglob bool
+	// hidden files are matched by the wildcards
+	Dotglob bool
+	// names of files are matched ignoring case
+	Nocaseglob bool
+	// ** matches files and directories recursively
+	Globstar bool
+	// ?(), *(), +(), @() and !() patterns are recognized
+	Extglob bool
+}
+
+// GlobEnv is implemented by environments that can change the behaviour of the
+// filename expansion
+type GlobEnv interface {
+	Environment
+	GlobOptions() GlobOptions
+}
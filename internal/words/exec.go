@@ -16,6 +16,8 @@ type Executer interface{}
 type ExecSimple struct {
 	Expander
 	Redirect []ExpandRedirect
+	// variables defined only for the execution of the command
+	Assign []ExecAssign
 }
 
 func CreateSimple(ex Expander) ExecSimple {
@@ -91,6 +93,28 @@ func CreatePipeItem(ex Executer, both bool) PipeItem {
 	}
 }
 
+type ExecNot struct {
+	Executer
+}
+
+func CreateNot(ex Executer) ExecNot {
+	return ExecNot{
+		Executer: ex,
+	}
+}
+
+type ExecTime struct {
+	Executer
+	Posix bool
+}
+
+func CreateTime(ex Executer, posix bool) ExecTime {
+	return ExecTime{
+		Executer: ex,
+		Posix:    posix,
+	}
+}
+
 type ExecSubshell []Executer
 
 func (e ExecSubshell) Executer() Executer {
@@ -100,6 +124,23 @@ func (e ExecSubshell) Executer() Executer {
 	return e
 }
 
+type ExecGroup struct {
+	List     []Executer
+	Redirect []ExpandRedirect
+}
+
+type ExecFunction struct {
+	Ident string
+	Body  Executer
+}
+
+func CreateFunction(ident string, body Executer) ExecFunction {
+	return ExecFunction{
+		Ident: ident,
+		Body:  body,
+	}
+}
+
 type ExecBreak struct{}
 
 type ExecContinue struct{}
@@ -123,6 +164,37 @@ func (e ExecFor) Expand(env Environment, _ bool) ([]string, error) {
 	return list, nil
 }
 
+// ExecSelect is the select loop. Without list, the words are the positional
+// parameters
+type ExecSelect struct {
+	Ident string
+	List  []Expander
+	Body  Executer
+	Alt   Executer
+}
+
+func (e ExecSelect) Expand(env Environment, _ bool) ([]string, error) {
+	var list []string
+	for i := range e.List {
+		str, err := e.List[i].Expand(env, false)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, str...)
+	}
+	return list, nil
+}
+
+// ExecForMath is the for loop whose header is made of three arithmetic
+// expressions. Each of them can be nil when missing
+type ExecForMath struct {
+	Init Expr
+	Cond Expr
+	Step Expr
+	Body Executer
+	Alt  Executer
+}
+
 type ExecWhile struct {
 	Cond Executer
 	Body Executer
@@ -155,3 +227,8 @@ type ExecClause struct {
 type ExecTest struct {
 	Tester
 }
+
+// ExecMath is the arithmetic command (( expr ))
+type ExecMath struct {
+	ExpandMath
+}
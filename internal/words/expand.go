@@ -6,15 +6,22 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"path/filepath"
+	"os/user"
 	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/midbel/shlex"
 	"github.com/midbel/tish/internal/token"
 )
 
-var ErrExpansion = errors.New("bad expansion")
+var (
+	ErrExpansion = errors.New("bad expansion")
+	ErrUnset     = errors.New("parameter not set")
+	ErrUndefined = errors.New("undefined variable")
+	ErrNoMatch   = errors.New("no match")
+)
 
 type Expander interface {
 	Expand(env Environment, top bool) ([]string, error)
@@ -39,7 +46,7 @@ func (e ExpandSub) Expand(env Environment, top bool) ([]string, error) {
 		pr, pw = io.Pipe()
 		buf    bytes.Buffer
 		err    error
-		wait = make(chan struct{})
+		wait   = make(chan struct{})
 	)
 	go func() {
 		io.Copy(&buf, pr)
@@ -77,8 +84,10 @@ func (e ExpandList) Expand(env Environment, top bool) ([]string, error) {
 		if err != nil {
 			return nil, err
 		}
-		if top && !e.List[i].IsQuoted() {
-			ws = expandList(ws)
+		if top && !hasQuote(e.List[i]) {
+			if ws, err = expandList(env, ws); err != nil {
+				return nil, err
+			}
 		}
 		str = append(str, ws...)
 	}
@@ -116,7 +125,7 @@ func (m ExpandMulti) Expand(env Environment, top bool) ([]string, error) {
 	}
 	str := strings.Join(words, "")
 	if top && !m.IsQuoted() {
-		return expandFilename(str), nil
+		return expandFilename(env, str)
 	}
 	return []string{str}, nil
 }
@@ -145,6 +154,15 @@ type ExpandMath struct {
 }
 
 func (e ExpandMath) Expand(env Environment, _ bool) ([]string, error) {
+	ret, err := e.Eval(env)
+	if err != nil {
+		return nil, err
+	}
+	str := strconv.FormatFloat(ret, 'f', -1, 64)
+	return []string{str}, nil
+}
+
+func (e ExpandMath) Eval(env Environment) (float64, error) {
 	var (
 		ret float64
 		err error
@@ -152,11 +170,10 @@ func (e ExpandMath) Expand(env Environment, _ bool) ([]string, error) {
 	for i := range e.List {
 		ret, err = e.List[i].Eval(env)
 		if err != nil {
-			return nil, err
+			return ret, err
 		}
 	}
-	str := strconv.FormatFloat(ret, 'f', -1, 64)
-	return []string{str}, nil
+	return ret, nil
 }
 
 func (e ExpandMath) IsQuoted() bool {
@@ -183,7 +200,7 @@ func (w ExpandWord) Expand(env Environment, top bool) ([]string, error) {
 	if w.Quoted || !top {
 		return []string{w.Literal}, nil
 	}
-	return expandFilename(w.Literal), nil
+	return expandFilename(env, w.Literal)
 }
 
 type ExpandRedirect struct {
@@ -220,35 +237,23 @@ type ExpandListBrace struct {
 	Words  []Expander
 }
 
-func (_ ExpandListBrace) IsQuoted() bool {
-	return false
+func (b ExpandListBrace) IsQuoted() bool {
+	return hasQuote(b)
 }
 
 func (b ExpandListBrace) Expand(env Environment, _ bool) ([]string, error) {
-	var (
-		prefix []string
-		suffix []string
-		words  []string
-		err    error
-	)
-	if b.Prefix != nil {
-		if prefix, err = b.Prefix.Expand(env, false); err != nil {
-			return nil, err
-		}
-	}
-	if b.Suffix != nil {
-		if suffix, err = b.Suffix.Expand(env, false); err != nil {
-			return nil, err
-		}
-	}
+	var words []string
 	for i := range b.Words {
 		str, err := b.Words[i].Expand(env, false)
 		if err != nil {
 			return nil, err
 		}
+		if len(str) == 0 {
+			str = append(str, "")
+		}
 		words = append(words, str...)
 	}
-	return combineStrings(words, prefix, suffix), nil
+	return expandBraces(env, words, b.Prefix, b.Suffix)
 }
 
 type ExpandRangeBrace struct {
@@ -258,28 +263,17 @@ type ExpandRangeBrace struct {
 	From   int
 	To     int
 	Step   int
+	Letter bool
 }
 
-func (_ ExpandRangeBrace) IsQuoted() bool {
-	return false
+func (b ExpandRangeBrace) IsQuoted() bool {
+	return hasQuote(b)
 }
 
 func (b ExpandRangeBrace) Expand(env Environment, _ bool) ([]string, error) {
-	var (
-		prefix []string
-		suffix []string
-		words  []string
-		err    error
-	)
-	if b.Prefix != nil {
-		if prefix, err = b.Prefix.Expand(env, false); err != nil {
-			return nil, err
-		}
-	}
-	if b.Suffix != nil {
-		if suffix, err = b.Suffix.Expand(env, false); err != nil {
-			return nil, err
-		}
+	var words []string
+	if b.Step < 0 {
+		b.Step = -b.Step
 	}
 	if b.Step == 0 {
 		b.Step = 1
@@ -291,19 +285,71 @@ func (b ExpandRangeBrace) Expand(env Environment, _ bool) ([]string, error) {
 		cmp = func(from, to int) bool {
 			return from >= to
 		}
-		if b.Step > 0 {
-			b.Step = -b.Step
-		}
+		b.Step = -b.Step
 	}
 	for cmp(b.From, b.To) {
-		str := strconv.Itoa(b.From)
-		if z := len(str); b.Pad > 0 && z < b.Pad {
-			str = fmt.Sprintf("%s%s", strings.Repeat("0", b.Pad-z), str)
+		var str string
+		if b.Letter {
+			str = string(rune(b.From))
+		} else {
+			str = fmt.Sprintf("%0*d", b.Pad, b.From)
 		}
 		words = append(words, str)
 		b.From += b.Step
 	}
-	return combineStrings(words, prefix, suffix), nil
+	return expandBraces(env, words, b.Prefix, b.Suffix)
+}
+
+// expandBraces combines the words generated by a brace with the words of its
+// prefix and of its suffix
+func expandBraces(env Environment, words []string, prefix, suffix Expander) ([]string, error) {
+	var (
+		before []string
+		after  []string
+		err    error
+	)
+	if prefix != nil {
+		if before, err = prefix.Expand(env, false); err != nil {
+			return nil, err
+		}
+	}
+	if suffix != nil {
+		if after, err = suffix.Expand(env, false); err != nil {
+			return nil, err
+		}
+	}
+	return combineStrings(words, before, after), nil
+}
+
+// hasQuote tells if one of the given expanders or one of their parts is quoted.
+// The words of such expanders are not subject to filename expansion
+func hasQuote(list ...Expander) bool {
+	for _, e := range list {
+		switch e := e.(type) {
+		case nil:
+		case ExpandMulti:
+			if e.Quoted || hasQuote(e.List...) {
+				return true
+			}
+		case ExpandList:
+			if e.Quoted || hasQuote(e.List...) {
+				return true
+			}
+		case ExpandListBrace:
+			if hasQuote(e.Prefix, e.Suffix) || hasQuote(e.Words...) {
+				return true
+			}
+		case ExpandRangeBrace:
+			if hasQuote(e.Prefix, e.Suffix) {
+				return true
+			}
+		default:
+			if e.IsQuoted() {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 type ExpandVar struct {
@@ -323,13 +369,12 @@ func (v ExpandVar) IsQuoted() bool {
 }
 
 func (v ExpandVar) Expand(env Environment, _ bool) ([]string, error) {
-	str, err := env.Resolve(v.Ident)
+	str, err := resolveVariable(env, v.Ident)
 	if err != nil {
 		return nil, err
 	}
-	if v.Quoted && len(str) > 0 {
-		str[0] = strings.Join(str, " ")
-		str = str[:1]
+	if v.Quoted && (len(str) > 0 || v.Ident != "@") {
+		str = []string{strings.Join(str, " ")}
 	}
 	return str, nil
 }
@@ -339,6 +384,9 @@ func (v ExpandVar) Eval(env Environment) (float64, error) {
 	if err != nil {
 		return 0, err
 	}
+	if len(str) == 0 {
+		return 0, nil
+	}
 	if len(str) != 1 {
 		return 0, fmt.Errorf("expansion returns too many words")
 	}
@@ -355,7 +403,7 @@ func (_ ExpandLength) IsQuoted() bool {
 
 func (v ExpandLength) Expand(env Environment, _ bool) ([]string, error) {
 	var (
-		ws, err = env.Resolve(v.Ident)
+		ws, err = resolveVariable(env, v.Ident)
 		sz      int
 	)
 	if err != nil {
@@ -368,10 +416,139 @@ func (v ExpandLength) Expand(env Environment, _ bool) ([]string, error) {
 	return []string{s}, nil
 }
 
+// ExpandIndirect is the indirect expansion ${!ident}: the value of ident is the
+// name of the variable to expand. If ident is a nameref, it gives the name of
+// the referenced variable. Expander is the operator following ident if any
+// (eg: ${!ident:-default}): it is applied to the variable named by ident
+type ExpandIndirect struct {
+	Ident    string
+	Expander Expander
+	Quoted   bool
+}
+
+func (v ExpandIndirect) IsQuoted() bool {
+	return v.Quoted
+}
+
+func (v ExpandIndirect) Expand(env Environment, _ bool) ([]string, error) {
+	if e, ok := env.(RefEnv); ok {
+		if ident, ok := e.Reference(v.Ident); ok {
+			return []string{ident}, nil
+		}
+	}
+	str, err := resolveVariable(env, v.Ident)
+	if err != nil {
+		return nil, err
+	}
+	ident := strings.Join(str, " ")
+	if ident == "" {
+		return nil, ExpansionError{
+			Ident:   v.Ident,
+			Message: "invalid indirect expansion",
+		}
+	}
+	if !isParameter(ident) {
+		return nil, ExpansionError{
+			Ident:   ident,
+			Message: "invalid variable name",
+		}
+	}
+	if v.Expander != nil {
+		return withIdent(v.Expander, ident).Expand(env, false)
+	}
+	return CreateVariable(ident, v.Quoted).Expand(env, false)
+}
+
+// withIdent gives a copy of the expansion ex operating on the variable ident
+func withIdent(ex Expander, ident string) Expander {
+	switch e := ex.(type) {
+	case ExpandSlice:
+		e.Ident = ident
+		return e
+	case ExpandTrim:
+		e.Ident = ident
+		return e
+	case ExpandReplace:
+		e.Ident = ident
+		return e
+	case ExpandLower:
+		e.Ident = ident
+		return e
+	case ExpandUpper:
+		e.Ident = ident
+		return e
+	case ExpandTransform:
+		e.Ident = ident
+		return e
+	case ExpandPad:
+		e.Ident = ident
+		return e
+	case ExpandValIfUnset:
+		e.Ident = ident
+		return e
+	case ExpandSetValIfUnset:
+		e.Ident = ident
+		return e
+	case ExpandValIfSet:
+		e.Ident = ident
+		return e
+	case ExpandExitIfUnset:
+		e.Ident = ident
+		return e
+	default:
+		return ex
+	}
+}
+
+// ExpandPrefix is the expansion ${!prefix*} or ${!prefix@} giving the names of
+// the variables starting with prefix. With All (@), the names remain separate
+// words between double quotes
+type ExpandPrefix struct {
+	Prefix string
+	All    bool
+	Quoted bool
+}
+
+func (v ExpandPrefix) IsQuoted() bool {
+	return v.Quoted
+}
+
+func (v ExpandPrefix) Expand(env Environment, _ bool) ([]string, error) {
+	var list []string
+	if e, ok := env.(NamesEnv); ok {
+		for _, n := range e.Names() {
+			if strings.HasPrefix(n, v.Prefix) {
+				list = append(list, n)
+			}
+		}
+	}
+	if v.Quoted && !v.All {
+		list = []string{strings.Join(list, " ")}
+	}
+	return list, nil
+}
+
+// isParameter reports whether str is the name of a variable, of a positional
+// parameter or of a special parameter
+func isParameter(str string) bool {
+	if len(str) == 1 && strings.ContainsAny(str, "@*#?-$!0") {
+		return true
+	}
+	if strings.Trim(str, "0123456789") == "" {
+		return true
+	}
+	for i, r := range str {
+		if r != '_' && !unicode.IsLetter(r) && (i == 0 || !unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return true
+}
+
 type ExpandReplace struct {
 	Ident  string
-	From   string
-	To     string
+	From   Expander
+	To     Expander
 	What   rune
 	Quoted bool
 }
@@ -381,48 +558,97 @@ func (v ExpandReplace) IsQuoted() bool {
 }
 
 func (v ExpandReplace) Expand(env Environment, _ bool) ([]string, error) {
-	str, err := env.Resolve(v.Ident)
+	str, err := resolveVariable(env, v.Ident)
 	if err != nil {
 		return nil, err
 	}
-	switch v.What {
-	case token.Replace:
-		str = v.replace(str)
-	case token.ReplaceAll:
-		str = v.replaceAll(str)
-	case token.ReplacePrefix:
-		str = v.replacePrefix(str)
-	case token.ReplaceSuffix:
-		str = v.replaceSuffix(str)
+	from, err := expandPattern(env, v.From)
+	if err != nil || from == "" {
+		return str, err
+	}
+	var to string
+	if v.To != nil {
+		ws, err := v.To.Expand(env, false)
+		if err != nil {
+			return nil, err
+		}
+		to = strings.Join(ws, " ")
 	}
-	return str, nil
-}
-
-func (v ExpandReplace) replace(str []string) []string {
 	for i := range str {
-		str[i] = strings.Replace(str[i], v.From, v.To, 1)
+		str[i] = v.replace(str[i], from, to)
 	}
-	return str
+	return str, nil
 }
 
-func (v ExpandReplace) replaceAll(str []string) []string {
-	for i := range str {
-		str[i] = strings.ReplaceAll(str[i], v.From, v.To)
+func (v ExpandReplace) replace(str, from, to string) string {
+	var (
+		buf  strings.Builder
+		rs   = []rune(str)
+		last int
+	)
+	for i := 0; i <= len(rs); i++ {
+		if v.What == token.ReplacePrefix && i > 0 {
+			break
+		}
+		j := matchAt(from, rs, i, v.What == token.ReplaceSuffix)
+		if j <= i {
+			continue
+		}
+		buf.WriteString(string(rs[last:i]))
+		buf.WriteString(substitute(to, string(rs[i:j])))
+		last = j
+		if v.What != token.ReplaceAll {
+			break
+		}
+		i = j - 1
 	}
-	return str
+	buf.WriteString(string(rs[last:]))
+	return buf.String()
 }
 
-func (v ExpandReplace) replacePrefix(str []string) []string {
-	return v.replace(str)
+// matchAt gives the end of the longest match of pattern starting at offset
+// in str or -1 if there is none. If suffix is true, the match should end at the
+// end of str.
+func matchAt(pattern string, str []rune, offset int, suffix bool) int {
+	for j := len(str); j >= offset; j-- {
+		if Match(pattern, string(str[offset:j])) {
+			return j
+		}
+		if suffix {
+			break
+		}
+	}
+	return -1
 }
 
-func (v ExpandReplace) replaceSuffix(str []string) []string {
-	return v.replace(str)
+// substitute replaces the unescaped & in str by match
+func substitute(str, match string) string {
+	if !strings.ContainsAny(str, "&\\") {
+		return str
+	}
+	var (
+		buf strings.Builder
+		rs  = []rune(str)
+	)
+	for i := 0; i < len(rs); i++ {
+		switch rs[i] {
+		case '&':
+			buf.WriteString(match)
+		case '\\':
+			if i+1 < len(rs) {
+				i++
+			}
+			buf.WriteRune(rs[i])
+		default:
+			buf.WriteRune(rs[i])
+		}
+	}
+	return buf.String()
 }
 
 type ExpandTrim struct {
 	Ident  string
-	Trim   string
+	Trim   Expander
 	What   rune
 	Quoted bool
 }
@@ -432,53 +658,79 @@ func (v ExpandTrim) IsQuoted() bool {
 }
 
 func (v ExpandTrim) Expand(env Environment, _ bool) ([]string, error) {
-	str, err := env.Resolve(v.Ident)
+	str, err := resolveVariable(env, v.Ident)
 	if err != nil {
 		return nil, err
 	}
-	switch v.What {
-	case token.TrimSuffix:
-		str = v.trimSuffix(str)
-	case token.TrimSuffixLong:
-		str = v.trimSuffixLong(str)
-	case token.TrimPrefix:
-		str = v.trimPrefix(str)
-	case token.TrimPrefixLong:
-		str = v.trimPrefixLong(str)
+	pattern, err := expandPattern(env, v.Trim)
+	if err != nil {
+		return nil, err
 	}
-	return str, nil
-}
-
-func (v ExpandTrim) trimSuffix(str []string) []string {
 	for i := range str {
-		str[i] = strings.TrimSuffix(str[i], v.Trim)
+		switch rs := []rune(str[i]); v.What {
+		case token.TrimSuffix:
+			str[i] = v.trimSuffix(rs, pattern, false)
+		case token.TrimSuffixLong:
+			str[i] = v.trimSuffix(rs, pattern, true)
+		case token.TrimPrefix:
+			str[i] = v.trimPrefix(rs, pattern, false)
+		case token.TrimPrefixLong:
+			str[i] = v.trimPrefix(rs, pattern, true)
+		}
 	}
-	return str
+	return str, nil
 }
 
-func (v ExpandTrim) trimSuffixLong(str []string) []string {
-	for i := range str {
-		for strings.HasSuffix(str[i], v.Trim) {
-			str[i] = strings.TrimSuffix(str[i], v.Trim)
+func (v ExpandTrim) trimSuffix(str []rune, pattern string, long bool) string {
+	for i := 0; i <= len(str); i++ {
+		j := len(str) - i
+		if long {
+			j = i
+		}
+		if Match(pattern, string(str[j:])) {
+			return string(str[:j])
 		}
 	}
-	return str
+	return string(str)
 }
 
-func (v ExpandTrim) trimPrefix(str []string) []string {
-	for i := range str {
-		str[i] = strings.TrimPrefix(str[i], v.Trim)
+func (v ExpandTrim) trimPrefix(str []rune, pattern string, long bool) string {
+	for i := 0; i <= len(str); i++ {
+		j := i
+		if long {
+			j = len(str) - i
+		}
+		if Match(pattern, string(str[:j])) {
+			return string(str[j:])
+		}
 	}
-	return str
+	return string(str)
 }
 
-func (v ExpandTrim) trimPrefixLong(str []string) []string {
-	for i := range str {
-		for strings.HasPrefix(str[i], v.Trim) {
-			str[i] = strings.TrimPrefix(str[i], v.Trim)
+// expandPattern expands ex to a pattern suitable for Match. Quoted parts of ex
+// are matched literally.
+func expandPattern(env Environment, ex Expander) (string, error) {
+	if ex == nil {
+		return "", nil
+	}
+	list := []Expander{ex}
+	if m, ok := ex.(ExpandMulti); ok {
+		list = m.List
+	}
+	var buf strings.Builder
+	for _, e := range list {
+		str, err := e.Expand(env, false)
+		if err != nil {
+			return "", err
+		}
+		for i := range str {
+			if e.IsQuoted() {
+				str[i] = QuotePattern(str[i])
+			}
+			buf.WriteString(str[i])
 		}
 	}
-	return str
+	return buf.String(), nil
 }
 
 type ExpandSlice struct {
@@ -493,7 +745,7 @@ func (v ExpandSlice) IsQuoted() bool {
 }
 
 func (v ExpandSlice) Expand(env Environment, _ bool) ([]string, error) {
-	str, err := env.Resolve(v.Ident)
+	str, err := resolveVariable(env, v.Ident)
 	if err == nil {
 		str = v.expandSlice(str)
 	}
@@ -566,7 +818,7 @@ func (v ExpandPad) IsQuoted() bool {
 }
 
 func (v ExpandPad) Expand(env Environment, _ bool) ([]string, error) {
-	str, err := env.Resolve(v.Ident)
+	str, err := resolveVariable(env, v.Ident)
 	if err != nil || len(str) >= v.Len {
 		return str, err
 	}
@@ -603,7 +855,7 @@ func (v ExpandLower) IsQuoted() bool {
 }
 
 func (v ExpandLower) Expand(env Environment, _ bool) ([]string, error) {
-	str, err := env.Resolve(v.Ident)
+	str, err := resolveVariable(env, v.Ident)
 	if err != nil {
 		return nil, err
 	}
@@ -647,7 +899,7 @@ func (v ExpandUpper) IsQuoted() bool {
 }
 
 func (v ExpandUpper) Expand(env Environment, _ bool) ([]string, error) {
-	str, err := env.Resolve(v.Ident)
+	str, err := resolveVariable(env, v.Ident)
 	if err != nil {
 		return nil, err
 	}
@@ -680,16 +932,87 @@ func (v ExpandUpper) upperAll(str []string) []string {
 	return str
 }
 
+// ExpandTransform is the parameter transformation ${var@op}. What is the token
+// of the operator
+type ExpandTransform struct {
+	Ident  string
+	What   rune
+	Quoted bool
+}
+
+func (v ExpandTransform) IsQuoted() bool {
+	return v.Quoted
+}
+
+func (v ExpandTransform) Expand(env Environment, _ bool) ([]string, error) {
+	str, err := resolveVariable(env, v.Ident)
+	if err != nil {
+		return nil, err
+	}
+	if len(str) == 0 && v.Ident != "@" && v.Ident != "*" {
+		// a variable set to the empty string is transformed like its value
+		if _, err := env.Resolve(v.Ident); err == nil {
+			str = []string{""}
+		}
+	}
+	if v.What == token.AssignVal && len(str) > 0 {
+		str = []string{v.assign(env, str)}
+	}
+	for i := 0; v.What != token.AssignVal && i < len(str); i++ {
+		switch v.What {
+		case token.QuoteVal:
+			str[i] = QuoteValue(str[i])
+		case token.EscapeVal:
+			str[i] = UnescapeQuote(str[i])
+		case token.PromptVal:
+			str[i] = expandPrompt(env, str[i])
+		case token.UpperVal:
+			str[i] = strings.ToUpper(str[i])
+		case token.LowerVal:
+			str[i] = strings.ToLower(str[i])
+		case token.UpperFirst:
+			r, n := utf8.DecodeRuneInString(str[i])
+			str[i] = string(unicode.ToUpper(r)) + str[i][n:]
+		default:
+		}
+	}
+	if v.Quoted && v.Ident != "@" {
+		str = []string{strings.Join(str, " ")}
+	}
+	return str, nil
+}
+
+// assign gives the statement recreating the variable with its value and its
+// attributes
+func (v ExpandTransform) assign(env Environment, str []string) string {
+	if v.Ident == "@" || v.Ident == "*" {
+		list := make([]string, len(str))
+		for i := range str {
+			list[i] = QuoteValue(str[i])
+		}
+		return fmt.Sprintf("set -- %s", strings.Join(list, " "))
+	}
+	stmt := fmt.Sprintf("%s=%s", v.Ident, QuoteValue(strings.Join(str, " ")))
+	if e, ok := env.(AttrEnv); ok {
+		if flags, _ := e.Flags(v.Ident); flags != "" {
+			stmt = fmt.Sprintf("declare -%s %s", flags, stmt)
+		}
+	}
+	return stmt
+}
+
 type ExpandValIfUnset struct {
 	Ident  string
-	Value  string
+	Value  Expander
+	Null   bool
 	Quoted bool
 }
 
-func CreateValIfUnset(ident, value string, quoted bool) ExpandValIfUnset {
+func CreateValIfUnset(ident string, value Expander, null, quoted bool) ExpandValIfUnset {
 	return ExpandValIfUnset{
 		Ident:  ident,
 		Value:  value,
+		Null:   null,
 		Quoted: quoted,
 	}
 }
@@ -699,23 +1022,24 @@ func (v ExpandValIfUnset) IsQuoted() bool {
 }
 
 func (v ExpandValIfUnset) Expand(env Environment, _ bool) ([]string, error) {
-	str, err := env.Resolve(v.Ident)
-	if err == nil && len(str) > 0 {
-		return str, nil
+	if str, ok := resolveParameter(env, v.Ident, v.Null); ok {
+		return joinQuoted(str, v.Quoted), nil
 	}
-	return []string{v.Value}, nil
+	return expandValue(env, v.Value, v.Quoted)
 }
 
 type ExpandSetValIfUnset struct {
 	Ident  string
-	Value  string
+	Value  Expander
+	Null   bool
 	Quoted bool
 }
 
-func CreateSetValIfUnset(ident, value string, quoted bool) ExpandSetValIfUnset {
+func CreateSetValIfUnset(ident string, value Expander, null, quoted bool) ExpandSetValIfUnset {
 	return ExpandSetValIfUnset{
 		Ident:  ident,
 		Value:  value,
+		Null:   null,
 		Quoted: quoted,
 	}
 }
@@ -725,24 +1049,32 @@ func (v ExpandSetValIfUnset) IsQuoted() bool {
 }
 
 func (v ExpandSetValIfUnset) Expand(env Environment, _ bool) ([]string, error) {
-	str, err := env.Resolve(v.Ident)
+	if str, ok := resolveParameter(env, v.Ident, v.Null); ok {
+		return joinQuoted(str, v.Quoted), nil
+	}
+	str, err := v.Value.Expand(env, false)
 	if err != nil {
-		str = []string{v.Value}
-		env.Define(v.Ident, str)
+		return nil, err
+	}
+	str = []string{strings.Join(str, " ")}
+	if err := env.Define(v.Ident, str); err != nil {
+		return nil, err
 	}
 	return str, nil
 }
 
 type ExpandValIfSet struct {
 	Ident  string
-	Value  string
+	Value  Expander
+	Null   bool
 	Quoted bool
 }
 
-func CreateExpandValIfSet(ident, value string, quoted bool) ExpandValIfSet {
+func CreateExpandValIfSet(ident string, value Expander, null, quoted bool) ExpandValIfSet {
 	return ExpandValIfSet{
 		Ident:  ident,
 		Value:  value,
+		Null:   null,
 		Quoted: quoted,
 	}
 }
@@ -752,23 +1084,24 @@ func (v ExpandValIfSet) IsQuoted() bool {
 }
 
 func (v ExpandValIfSet) Expand(env Environment, _ bool) ([]string, error) {
-	str, err := env.Resolve(v.Ident)
-	if err == nil {
-		str = []string{v.Value}
+	if _, ok := resolveParameter(env, v.Ident, v.Null); !ok {
+		return nil, nil
 	}
-	return str, nil
+	return expandValue(env, v.Value, v.Quoted)
 }
 
 type ExpandExitIfUnset struct {
 	Ident  string
-	Value  string
+	Value  Expander
+	Null   bool
 	Quoted bool
 }
 
-func CreateExpandExitIfUnset(ident, value string, quoted bool) ExpandExitIfUnset {
+func CreateExpandExitIfUnset(ident string, value Expander, null, quoted bool) ExpandExitIfUnset {
 	return ExpandExitIfUnset{
 		Ident:  ident,
 		Value:  value,
+		Null:   null,
 		Quoted: quoted,
 	}
 }
@@ -778,9 +1111,121 @@ func (v ExpandExitIfUnset) IsQuoted() bool {
 }
 
 func (v ExpandExitIfUnset) Expand(env Environment, _ bool) ([]string, error) {
+	if str, ok := resolveParameter(env, v.Ident, v.Null); ok {
+		return joinQuoted(str, v.Quoted), nil
+	}
+	str, err := v.Value.Expand(env, false)
+	if err != nil {
+		return nil, err
+	}
+	msg := strings.Join(str, " ")
+	if msg == "" {
+		msg = "parameter not set"
+		if v.Null {
+			msg = "parameter null or not set"
+		}
+	}
+	return nil, UnsetError{
+		Ident:   v.Ident,
+		Message: msg,
+	}
+}
+
+// UnsetError is returned by ${var:?message} and ${var?message} when var is
+// unset (or null for the former). Shells should abort the current script when
+// they get it.
+type UnsetError struct {
+	Ident   string
+	Message string
+}
+
+func (e UnsetError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Ident, e.Message)
+}
+
+func (e UnsetError) Unwrap() error {
+	return ErrUnset
+}
+
+// NoMatchError is returned by the filename expansion when a pattern matches no
+// files and the failglob option is set. Like for UnsetError, shells should abort
+// the current script when they get it.
+type NoMatchError struct {
+	Pattern string
+}
+
+func (e NoMatchError) Error() string {
+	return fmt.Sprintf("no match: %s", e.Pattern)
+}
+
+func (e NoMatchError) Unwrap() error {
+	return ErrNoMatch
+}
+
+// ExpansionError is returned when a parameter expansion can not be performed
+// (eg: invalid indirect expansion). Unlike for UnsetError, shells should only
+// give up the current command when they get it.
+type ExpansionError struct {
+	Ident   string
+	Message string
+}
+
+func (e ExpansionError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Ident, e.Message)
+}
+
+func (e ExpansionError) Unwrap() error {
+	return ErrExpansion
+}
+
+// resolveVariable gives the values of ident. An unset variable has no value
+// unless env reports the expansion of unset variables as an error (set -u)
+func resolveVariable(env Environment, ident string) ([]string, error) {
+	str, err := env.Resolve(ident)
+	if err == nil || !errors.Is(err, ErrUndefined) {
+		return str, err
+	}
+	if e, ok := env.(StrictEnv); ok && e.Nounset() {
+		return nil, UnsetError{
+			Ident:   ident,
+			Message: "unbound variable",
+		}
+	}
 	return nil, nil
 }
 
+// resolveParameter gives the values of ident and reports whether ident is set.
+// If null is true, a variable set to the empty string is considered as unset.
+func resolveParameter(env Environment, ident string, null bool) ([]string, bool) {
+	str, err := env.Resolve(ident)
+	if err != nil {
+		return nil, false
+	}
+	if null && (len(str) == 0 || (len(str) == 1 && str[0] == "")) {
+		return nil, false
+	}
+	return str, true
+}
+
+func expandValue(env Environment, value Expander, quoted bool) ([]string, error) {
+	str, err := value.Expand(env, false)
+	if err != nil {
+		return nil, err
+	}
+	if !quoted && len(str) == 1 && str[0] == "" {
+		return nil, nil
+	}
+	return str, nil
+}
+
+func joinQuoted(str []string, quoted bool) []string {
+	if quoted && len(str) > 0 {
+		str[0] = strings.Join(str, " ")
+		str = str[:1]
+	}
+	return str
+}
+
 func combineStrings(words, prefix, suffix []string) []string {
 	if len(prefix) == 0 && len(suffix) == 0 {
 		return words
@@ -811,35 +1256,105 @@ func combineStringsWith(ws *strings.Builder, all, with []string) []string {
 	return str
 }
 
-func expandList(str []string) []string {
+func expandList(env Environment, str []string) ([]string, error) {
 	var list []string
 	for i := range str {
-		list = append(list, expandFilename(str[i])...)
+		if str[i] == "" {
+			continue
+		}
+		ws, err := expandFilename(env, str[i])
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, ws...)
 	}
-	return list
+	return list, nil
 }
 
-func expandFilename(str string) []string {
+func expandFilename(env Environment, str string) ([]string, error) {
 	if strings.HasPrefix(str, "~") {
-		return expandTilde(str)
+		str = expandTilde(env, str)
+	}
+	opts := globOptions(env)
+	if !isGlob(str, opts.Extglob) {
+		return []string{str}, nil
+	}
+	return expandPath(env, str, opts)
+}
+
+// expandTilde replaces the tilde prefix of str by the directory it refers to:
+// the home directory of the current user (~) or of another user (~name), the
+// current (~+) or previous (~-) working directory or an entry of the directory
+// stack (~N, ~+N, ~-N). str is left unchanged if the prefix can not be resolved
+func expandTilde(env Environment, str string) string {
+	prefix, rest := str[1:], ""
+	if x := strings.IndexByte(prefix, '/'); x >= 0 {
+		prefix, rest = prefix[:x], prefix[x:]
+	}
+	var dir string
+	switch prefix {
+	case "":
+		dir = resolveFirst(env, "HOME")
+	case "+":
+		dir = resolveFirst(env, "PWD")
+	case "-":
+		dir = resolveFirst(env, "OLDPWD")
+	default:
+		if n, err := strconv.Atoi(prefix); err == nil {
+			dir = stackDir(env, n, prefix[0] == '-')
+		} else if u, err := user.Lookup(prefix); err == nil {
+			dir = u.HomeDir
+		}
 	}
-	if strings.ContainsAny(str, "[?*") {
-		dir, file := filepath.Split(str)
-		str = filepath.Join(filepath.Dir(dir), file)
-		return expandPath(str)
+	if dir == "" {
+		return str
 	}
-	return []string{str}
+	return dir + rest
 }
 
-func expandTilde(str string) []string {
-	// TODO: replace tilde by home directory of current user
-	return []string{str}
+func stackDir(env Environment, n int, bottom bool) string {
+	e, ok := env.(DirsEnv)
+	if !ok {
+		return ""
+	}
+	dirs := e.Dirs()
+	if bottom {
+		n = len(dirs) - 1 + n
+	}
+	if n < 0 || n >= len(dirs) {
+		return ""
+	}
+	return dirs[n]
 }
 
-func expandPath(str string) []string {
-	list, err := filepath.Glob(str)
-	if err != nil || len(list) == 0 {
-		list = append(list[:0], str)
+func resolveFirst(env Environment, ident string) string {
+	str, err := env.Resolve(ident)
+	if err != nil || len(str) == 0 {
+		return ""
 	}
-	return list
+	return str[0]
+}
+
+func expandPath(env Environment, str string, opts GlobOptions) ([]string, error) {
+	list := glob(WorkingFS(env), str, opts)
+	if len(list) > 0 {
+		return list, nil
+	}
+	switch {
+	case opts.Failglob:
+		return nil, NoMatchError{
+			Pattern: str,
+		}
+	case opts.Nullglob:
+		return nil, nil
+	default:
+		return []string{str}, nil
+	}
+}
+
+func globOptions(env Environment) GlobOptions {
+	if e, ok := env.(GlobEnv); ok {
+		return e.GlobOptions()
+	}
+	return GlobOptions{}
 }
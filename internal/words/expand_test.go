@@ -1,9 +1,13 @@
 package words_test
 
 import (
+	"errors"
+	"strings"
 	"testing"
 
 	"github.com/midbel/tish"
+	"github.com/midbel/tish/internal/parser"
+	"github.com/midbel/tish/internal/token"
 	"github.com/midbel/tish/internal/words"
 )
 
@@ -78,9 +82,152 @@ func TestExpander(t *testing.T) {
 			Expander: createRangeBrace(1, 3, 1, "pre-", "-post"),
 			Want:     []string{"pre-1-post", "pre-2-post", "pre-3-post"},
 		},
+		{
+			Name:     "range-brace",
+			Expander: createRangeBrace(5, -5, 2, "", ""),
+			Want:     []string{"5", "3", "1", "-1", "-3", "-5"},
+		},
+		{
+			Name: "range-brace",
+			Expander: words.ExpandRangeBrace{
+				From:   'a',
+				To:     'e',
+				Step:   2,
+				Letter: true,
+			},
+			Want: []string{"a", "c", "e"},
+		},
+		{
+			Name: "range-brace",
+			Expander: words.ExpandRangeBrace{
+				From: 98,
+				To:   100,
+				Pad:  3,
+			},
+			Want: []string{"098", "099", "100"},
+		},
+		{
+			Name: "list-brace",
+			Expander: words.ExpandListBrace{
+				Words: []words.Expander{
+					createWord("a"),
+					createRangeBrace(1, 2, 1, "b", ""),
+				},
+				Suffix: words.CreateVariable("foobar", false),
+			},
+			Want: []string{"afoobar", "b1foobar", "b2foobar"},
+		},
+		{
+			Name:     "trim-suffix",
+			Expander: createTrim("file", ".*", token.TrimSuffix),
+			Want:     []string{"archive.tar"},
+		},
+		{
+			Name:     "trim-suffix-long",
+			Expander: createTrim("file", ".*", token.TrimSuffixLong),
+			Want:     []string{"archive"},
+		},
+		{
+			Name:     "trim-prefix",
+			Expander: createTrim("file", "*.", token.TrimPrefix),
+			Want:     []string{"tar.gz"},
+		},
+		{
+			Name:     "trim-prefix-long",
+			Expander: createTrim("file", "*.", token.TrimPrefixLong),
+			Want:     []string{"gz"},
+		},
+		{
+			Name:     "replace",
+			Expander: createReplace("file", "[.]", "-", token.Replace),
+			Want:     []string{"archive-tar.gz"},
+		},
+		{
+			Name:     "replace-all",
+			Expander: createReplace("file", "[.]", "<&>", token.ReplaceAll),
+			Want:     []string{"archive<.>tar<.>gz"},
+		},
+		{
+			Name:     "replace-prefix",
+			Expander: createReplace("file", "a*e", "file", token.ReplacePrefix),
+			Want:     []string{"file.tar.gz"},
+		},
+		{
+			Name:     "replace-suffix",
+			Expander: createReplace("file", ".*z", `\&`, token.ReplaceSuffix),
+			Want:     []string{"archive&"},
+		},
+		{
+			Name:     "val-if-unset",
+			Expander: words.CreateValIfUnset("empty", createWord("default"), false, false),
+			Want:     []string{""},
+		},
+		{
+			Name:     "val-if-unset",
+			Expander: words.CreateValIfUnset("empty", createWord("default"), true, false),
+			Want:     []string{"default"},
+		},
+		{
+			Name:     "val-if-unset",
+			Expander: words.CreateValIfUnset("unknown", createWord("default"), false, false),
+			Want:     []string{"default"},
+		},
+		{
+			Name:     "val-if-set",
+			Expander: words.CreateExpandValIfSet("empty", createWord("alt"), false, false),
+			Want:     []string{"alt"},
+		},
+		{
+			Name:     "val-if-set",
+			Expander: words.CreateExpandValIfSet("empty", createWord("alt"), true, false),
+			Want:     []string{},
+		},
+		{
+			Name:     "val-if-set",
+			Expander: words.CreateExpandValIfSet("unknown", createWord("alt"), false, false),
+			Want:     []string{},
+		},
+		{
+			Name:     "exit-if-unset",
+			Expander: words.CreateExpandExitIfUnset("foobar", createWord("not set"), true, false),
+			Want:     []string{"foobar"},
+		},
+		{
+			Name:     "transform-quote",
+			Expander: words.ExpandTransform{Ident: "file", What: token.QuoteVal},
+			Want:     []string{"'archive.tar.gz'"},
+		},
+		{
+			Name:     "transform-escape",
+			Expander: words.ExpandTransform{Ident: "escape", What: token.EscapeVal},
+			Want:     []string{"a\tb"},
+		},
+		{
+			Name:     "transform-upper",
+			Expander: words.ExpandTransform{Ident: "foobar", What: token.UpperVal},
+			Want:     []string{"FOOBAR"},
+		},
+		{
+			Name:     "transform-upper-first",
+			Expander: words.ExpandTransform{Ident: "foobar", What: token.UpperFirst},
+			Want:     []string{"Foobar"},
+		},
+		{
+			Name:     "transform-assign",
+			Expander: words.ExpandTransform{Ident: "foobar", What: token.AssignVal},
+			Want:     []string{"foobar='foobar'"},
+		},
+		{
+			Name:     "transform-unknown",
+			Expander: words.ExpandTransform{Ident: "unknown", What: token.QuoteVal},
+			Want:     []string{},
+		},
 	}
 	env := tish.EmptyEnv()
 	env.Define("foobar", []string{"foobar"})
+	env.Define("empty", []string{""})
+	env.Define("file", []string{"archive.tar.gz"})
+	env.Define("escape", []string{`a\tb`})
 	for i, d := range data {
 		t.Run(d.Name, func(t *testing.T) {
 			got, err := d.Expand(env, false)
@@ -99,6 +246,180 @@ func TestExpander(t *testing.T) {
 	}
 }
 
+func TestExpanderBraces(t *testing.T) {
+	data := []struct {
+		Input string
+		Want  []string
+	}{
+		{Input: "{a..e}", Want: []string{"a", "b", "c", "d", "e"}},
+		{Input: "{Z..V}", Want: []string{"Z", "Y", "X", "W", "V"}},
+		{Input: "{a..z..8}", Want: []string{"a", "i", "q", "y"}},
+		{Input: "{08..11}", Want: []string{"08", "09", "10", "11"}},
+		{Input: "{1..20..3}", Want: []string{"1", "4", "7", "10", "13", "16", "19"}},
+		{Input: "{1..10..-4}", Want: []string{"1", "5", "9"}},
+		{Input: "{-05..5..5}", Want: []string{"-05", "000", "005"}},
+		{Input: "{2..-2}", Want: []string{"2", "1", "0", "-1", "-2"}},
+		{Input: "{a,b{1..3},c}", Want: []string{"a", "b1", "b2", "b3", "c"}},
+		{Input: "x{a,b}y{1,2}", Want: []string{"xay1", "xay2", "xby1", "xby2"}},
+		{Input: "pre{1..3}post", Want: []string{"pre1post", "pre2post", "pre3post"}},
+		{Input: "a{,b}c", Want: []string{"ac", "abc"}},
+		{Input: "{,b}", Want: []string{"b"}},
+		{Input: "{a..b,c}", Want: []string{"a..b", "c"}},
+		{Input: "{$foo,bar}", Want: []string{"foo", "bar"}},
+		{Input: "$foo-{a,b}", Want: []string{"foo-a", "foo-b"}},
+		{Input: "{a,b}\"$foo\"", Want: []string{"afoo", "bfoo"}},
+		{Input: "${foo}{1,2}", Want: []string{"foo1", "foo2"}},
+		{Input: "{a}", Want: []string{"{a}"}},
+		{Input: "{a,b", Want: []string{"{a,b"}},
+		{Input: "{1..a}", Want: []string{"{1..a}"}},
+		{Input: "{}", Want: []string{"{}"}},
+		{Input: "\"{a,b}\"", Want: []string{"{a,b}"}},
+	}
+	env := tish.EmptyEnv()
+	env.Define("foo", []string{"foo"})
+	for _, d := range data {
+		ex, err := parser.NewParser(strings.NewReader(d.Input)).Parse()
+		if err != nil {
+			t.Errorf("%s: unexpected error parsing: %s", d.Input, err)
+			continue
+		}
+		got, err := ex.(words.ExecSimple).Expand(env, true)
+		if err != nil {
+			t.Errorf("%s: unexpected error expanding: %s", d.Input, err)
+			continue
+		}
+		if strings.Join(got, " ") != strings.Join(d.Want, " ") {
+			t.Errorf("%s: strings mismatched! want %q, got %q", d.Input, d.Want, got)
+		}
+	}
+}
+
+func TestExpanderIndirect(t *testing.T) {
+	data := []struct {
+		Input string
+		Want  []string
+	}{
+		{Input: "${!name}", Want: []string{"linux"}},
+		{Input: "${!ref}", Want: []string{"os"}},
+		{Input: "${!TARGET_*}", Want: []string{"TARGET_darwin", "TARGET_linux"}},
+		{Input: "\"${!TARGET_*}\"", Want: []string{"TARGET_darwin TARGET_linux"}},
+		{Input: "\"${!TARGET_@}\"", Want: []string{"TARGET_darwin", "TARGET_linux"}},
+		{Input: "${!UNKNOWN_*}", Want: []string{}},
+	}
+	parent := tish.EmptyEnv()
+	parent.Define("TARGET_linux", []string{"linux"})
+	parent.Define("os", []string{"linux"})
+
+	env := tish.EnclosedEnv(parent)
+	env.Define("TARGET_darwin", []string{"darwin"})
+	env.Define("name", []string{"TARGET_linux"})
+	env.Define("ref", []string{"os"})
+	env.SetAttributes("ref", tish.AttrRef)
+	env.Define("bad", []string{"bad name"})
+	for _, d := range data {
+		ex, err := parser.NewParser(strings.NewReader(d.Input)).Parse()
+		if err != nil {
+			t.Errorf("%s: unexpected error parsing: %s", d.Input, err)
+			continue
+		}
+		got, err := ex.(words.ExecSimple).Expand(env, true)
+		if err != nil {
+			t.Errorf("%s: unexpected error expanding: %s", d.Input, err)
+			continue
+		}
+		if strings.Join(got, "|") != strings.Join(d.Want, "|") {
+			t.Errorf("%s: strings mismatched! want %q, got %q", d.Input, d.Want, got)
+		}
+	}
+	for _, ident := range []string{"unknown", "bad"} {
+		ex := words.ExpandIndirect{Ident: ident}
+		if _, err := ex.Expand(env, false); !errors.Is(err, words.ErrExpansion) {
+			t.Errorf("%s: expected ErrExpansion, got %v", ident, err)
+		}
+	}
+}
+
+func TestExpanderExitIfUnset(t *testing.T) {
+	data := []struct {
+		Ident string
+		Null  bool
+	}{
+		{Ident: "unknown", Null: false},
+		{Ident: "unknown", Null: true},
+		{Ident: "empty", Null: true},
+	}
+	env := tish.EmptyEnv()
+	env.Define("empty", []string{""})
+	for _, d := range data {
+		ex := words.CreateExpandExitIfUnset(d.Ident, createWord("not set"), d.Null, false)
+		_, err := ex.Expand(env, false)
+		if !errors.Is(err, words.ErrUnset) {
+			t.Errorf("%s: expected ErrUnset, got %v", d.Ident, err)
+		}
+	}
+}
+
+type dirsEnv struct {
+	tish.Environment
+	dirs []string
+}
+
+func (e dirsEnv) Dirs() []string {
+	return e.dirs
+}
+
+func TestExpanderTilde(t *testing.T) {
+	data := []struct {
+		Word string
+		Want string
+	}{
+		{Word: "~", Want: "/home/foo"},
+		{Word: "~/bin", Want: "/home/foo/bin"},
+		{Word: "~+", Want: "/tmp"},
+		{Word: "~-/src", Want: "/var/src"},
+		{Word: "~0", Want: "/tmp"},
+		{Word: "~+1", Want: "/usr"},
+		{Word: "~-0", Want: "/etc"},
+		{Word: "~-1/x", Want: "/usr/x"},
+		{Word: "~3", Want: "~3"},
+		{Word: "foo~", Want: "foo~"},
+	}
+	env := dirsEnv{
+		Environment: tish.EmptyEnv(),
+		dirs:        []string{"/tmp", "/usr", "/etc"},
+	}
+	env.Define("HOME", []string{"/home/foo"})
+	env.Define("PWD", []string{"/tmp"})
+	env.Define("OLDPWD", []string{"/var"})
+	for _, d := range data {
+		got, err := createWord(d.Word).Expand(env, true)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", d.Word, err)
+			continue
+		}
+		if len(got) != 1 || got[0] != d.Want {
+			t.Errorf("%s: strings mismatched! want %s, got %s", d.Word, d.Want, got)
+		}
+	}
+}
+
+func createTrim(ident, pattern string, what rune) words.Expander {
+	return words.ExpandTrim{
+		Ident: ident,
+		Trim:  createWord(pattern),
+		What:  what,
+	}
+}
+
+func createReplace(ident, from, to string, what rune) words.Expander {
+	return words.ExpandReplace{
+		Ident: ident,
+		From:  createWord(from),
+		To:    createWord(to),
+		What:  what,
+	}
+}
+
 func createRangeBrace(from, to, step int, prefix, suffix string) words.Expander {
 	if step == 0 {
 		step = 1
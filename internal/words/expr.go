@@ -48,15 +48,19 @@ func (u Unary) Eval(env Environment) (float64, error) {
 	}
 	switch u.Op {
 	case token.Not:
-		if ret != 0 {
+		if ret == 0 {
 			ret = 1
+		} else {
+			ret = 0
 		}
 	case token.Sub:
 		ret = -ret
 	case token.Inc:
 		ret = ret + 1
+		return ret, storeExpr(env, u.Expr, ret)
 	case token.Dec:
 		ret = ret - 1
+		return ret, storeExpr(env, u.Expr, ret)
 	case token.BitNot:
 		x := ^int64(ret)
 		ret = float64(x)
@@ -66,6 +70,43 @@ func (u Unary) Eval(env Environment) (float64, error) {
 	return ret, nil
 }
 
+// Postfix increments or decrements its variable and gives the value it had
+// before
+type Postfix struct {
+	Op rune
+	Expr
+}
+
+func CreatePostfix(ex Expr, op rune) Expr {
+	return Postfix{
+		Op:   op,
+		Expr: ex,
+	}
+}
+
+func (p Postfix) Eval(env Environment) (float64, error) {
+	ret, err := p.Expr.Eval(env)
+	if err != nil {
+		return ret, err
+	}
+	next := ret + 1
+	if p.Op == token.Dec {
+		next = ret - 1
+	}
+	return ret, storeExpr(env, p.Expr, next)
+}
+
+// storeExpr defines the variable of ex with value. Nothing is stored when ex is
+// not a variable
+func storeExpr(env Environment, ex Expr, value float64) error {
+	v, ok := ex.(ExpandVar)
+	if !ok {
+		return nil
+	}
+	str := strconv.FormatFloat(value, 'f', -1, 64)
+	return env.Define(v.Ident, []string{str})
+}
+
 type Binary struct {
 	Op    rune
 	Left  Expr
@@ -123,6 +164,7 @@ type Bind int8
 
 const (
 	BindLowest Bind = iota
+	BindComma
 	BindAssign
 	BindBit
 	BindShift
@@ -134,6 +176,7 @@ const (
 	BindMul
 	BindPow
 	BindPrefix
+	BindPostfix
 )
 
 var bindings = map[rune]Bind{
@@ -156,12 +199,22 @@ var bindings = map[rune]Bind{
 	token.Le:         BindCmp,
 	token.Gt:         BindCmp,
 	token.Ge:         BindCmp,
+	token.NumEq:      BindEq,
+	token.NumNe:      BindEq,
+	token.NumLt:      BindCmp,
+	token.NumLe:      BindCmp,
+	token.NumGt:      BindCmp,
+	token.NumGe:      BindCmp,
+	token.ReMatch:    BindEq,
 	token.SameFile:   BindCmp,
 	token.NewerThan:  BindCmp,
 	token.OlderThan:  BindCmp,
 	token.Cond:       BindTernary,
 	token.Alt:        BindTernary,
 	token.Assign:     BindAssign,
+	token.Comma:      BindComma,
+	token.Inc:        BindPostfix,
+	token.Dec:        BindPostfix,
 }
 
 func BindPower(tok token.Token) Bind {
@@ -283,14 +336,14 @@ func doGe(left, right float64) (float64, error) {
 }
 
 func doAnd(left, right float64) (float64, error) {
-	if left == 0 && right == 0 {
-		return left, nil
+	if left == 0 || right == 0 {
+		return 0, nil
 	}
 	return 1, nil
 }
 
 func doOr(left, right float64) (float64, error) {
-	if left == 0 || right == 0 {
+	if left == 0 && right == 0 {
 		return 0, nil
 	}
 	return 1, nil
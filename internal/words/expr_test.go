@@ -37,8 +37,29 @@ func TestExpr(t *testing.T) {
 			Expr: createBinary(createVariable("sum1"), createVariable("sum2"), token.Add),
 			Want: 2,
 		},
+		{
+			Expr: createUnary(createNumber("1"), token.Not),
+			Want: 0,
+		},
+		{
+			Expr: createBinary(createNumber("1"), createNumber("0"), token.And),
+			Want: 0,
+		},
+		{
+			Expr: createBinary(createNumber("1"), createNumber("0"), token.Or),
+			Want: 1,
+		},
+		{
+			Expr: words.CreatePostfix(createVariable("inc"), token.Inc),
+			Want: 1,
+		},
+		{
+			Expr: createUnary(createVariable("inc"), token.Inc),
+			Want: 3,
+		},
 	}
 	env := tish.EmptyEnv()
+	env.Define("inc", []string{"1"})
 	env.Define("sum1", []string{"1"})
 	env.Define("sum2", []string{"1"})
 	for _, d := range data {
@@ -0,0 +1,117 @@
+package words
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// File is a file opened by a redirection
+type File interface {
+	io.Reader
+	io.Writer
+	io.Closer
+}
+
+// FileSystem gives access to the files used by the filename expansion, the file
+// tests and the redirections. The names given to a FileSystem are absolute when
+// it is obtained from an environment with WorkingFS
+type FileSystem interface {
+	Stat(string) (fs.FileInfo, error)
+	Lstat(string) (fs.FileInfo, error)
+	ReadDir(string) ([]fs.DirEntry, error)
+	Access(string, uint32) error
+	Readlink(string) (string, error)
+	OpenFile(string, int, fs.FileMode) (File, error)
+}
+
+// FileEnv is implemented by environments having their own working directory
+// and filesystem. Relative filenames are resolved from the directory given by
+// Cwd instead of the working directory of the process
+type FileEnv interface {
+	Environment
+	Cwd() string
+	FileSystem() FileSystem
+}
+
+// OSFileSystem gives the FileSystem of the host
+func OSFileSystem() FileSystem {
+	return osfs{}
+}
+
+// WorkingFS gives the FileSystem of env resolving relative filenames from its
+// working directory. The FileSystem of the host and the working directory of
+// the process are used when env does not implement FileEnv
+func WorkingFS(env Environment) FileSystem {
+	e, ok := env.(FileEnv)
+	if !ok {
+		return osfs{}
+	}
+	fsys := e.FileSystem()
+	if fsys == nil {
+		fsys = osfs{}
+	}
+	return workfs{
+		FileSystem: fsys,
+		cwd:        e.Cwd(),
+	}
+}
+
+type workfs struct {
+	FileSystem
+	cwd string
+}
+
+func (w workfs) Stat(file string) (fs.FileInfo, error) {
+	return w.FileSystem.Stat(w.resolve(file))
+}
+
+func (w workfs) Lstat(file string) (fs.FileInfo, error) {
+	return w.FileSystem.Lstat(w.resolve(file))
+}
+
+func (w workfs) ReadDir(dir string) ([]fs.DirEntry, error) {
+	return w.FileSystem.ReadDir(w.resolve(dir))
+}
+
+func (w workfs) Access(file string, mode uint32) error {
+	return w.FileSystem.Access(w.resolve(file), mode)
+}
+
+func (w workfs) Readlink(file string) (string, error) {
+	return w.FileSystem.Readlink(w.resolve(file))
+}
+
+func (w workfs) OpenFile(file string, flag int, perm fs.FileMode) (File, error) {
+	return w.FileSystem.OpenFile(w.resolve(file), flag, perm)
+}
+
+func (w workfs) resolve(file string) string {
+	if filepath.IsAbs(file) || w.cwd == "" {
+		return file
+	}
+	return filepath.Join(w.cwd, file)
+}
+
+type osfs struct{}
+
+func (_ osfs) Stat(file string) (fs.FileInfo, error) {
+	return os.Stat(file)
+}
+
+func (_ osfs) Lstat(file string) (fs.FileInfo, error) {
+	return os.Lstat(file)
+}
+
+func (_ osfs) ReadDir(dir string) ([]fs.DirEntry, error) {
+	return os.ReadDir(dir)
+}
+
+func (_ osfs) Readlink(file string) (string, error) {
+	return os.Readlink(file)
+}
+
+func (_ osfs) OpenFile(file string, flag int, perm fs.FileMode) (File, error) {
+	return os.OpenFile(file, flag, perm)
+}
@@ -0,0 +1,28 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package words
+
+import (
+	"io/fs"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Access checks the permissions of file with access(2) against the effective
+// user and groups of the process
+func (_ osfs) Access(file string, mode uint32) error {
+	if err := unix.Faccessat(unix.AT_FDCWD, file, mode, unix.AT_EACCESS); err != nil {
+		return &fs.PathError{Op: "access", Path: file, Err: err}
+	}
+	return nil
+}
+
+// fileOwner gives the user and the group owning the file described by fi
+func fileOwner(fi fs.FileInfo) (int, int, bool) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return int(st.Uid), int(st.Gid), true
+}
@@ -0,0 +1,556 @@
+package words
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const metachars = `*?[\(`
+
+// Match reports whether str matches the shell pattern. It is the matcher used by
+// filename expansion as well as the trim and replace operators of parameter
+// expansion.
+//
+// The pattern syntax is:
+//
+//	'*'         matches any sequence of characters
+//	'?'         matches any single character
+//	'[' class ']' matches any single character in class ('!' or '^' negates)
+//	'\' c       matches character c
+//	'?(' list ')' matches zero or one occurrence of the patterns in list
+//	'*(' list ')' matches zero or more occurrences of the patterns in list
+//	'+(' list ')' matches one or more occurrences of the patterns in list
+//	'@(' list ')' matches one occurrence of the patterns in list
+//	'!(' list ')' matches anything except one of the patterns in list
+//
+// The patterns of a list are separated by '|'. A class can contain character
+// classes like [:alpha:] or [:digit:].
+func Match(pattern, str string) bool {
+	m := matcher{
+		extglob: true,
+	}
+	return m.Match(pattern, str)
+}
+
+// IsPattern reports whether str contains any of the special characters
+// recognized by Match
+func IsPattern(str string) bool {
+	return isGlob(str, true)
+}
+
+// QuotePattern escapes the special characters of str so that Match only
+// matches it literally
+func QuotePattern(str string) string {
+	if !strings.ContainsAny(str, metachars) {
+		return str
+	}
+	var buf strings.Builder
+	for _, r := range str {
+		if strings.ContainsRune(metachars, r) {
+			buf.WriteRune('\\')
+		}
+		buf.WriteRune(r)
+	}
+	return buf.String()
+}
+
+func isGlob(str string, extglob bool) bool {
+	if strings.ContainsAny(str, "*?[") {
+		return true
+	}
+	return extglob && hasExtglob([]rune(str))
+}
+
+type matcher struct {
+	extglob bool
+	fold    bool
+}
+
+func (m matcher) Match(pattern, str string) bool {
+	pat := []rune(pattern)
+	if m.extglob && hasExtglob(pat) {
+		return m.matchExt(pat, []rune(str))
+	}
+	return m.match(pat, []rune(str))
+}
+
+func (m matcher) equal(c, r rune) bool {
+	if c == r {
+		return true
+	}
+	return m.fold && unicode.ToLower(c) == unicode.ToLower(r)
+}
+
+func (m matcher) match(pat, str []rune) bool {
+	var (
+		px, sx int
+		starx  = -1
+		nextx  int
+	)
+	for px < len(pat) || sx < len(str) {
+		if px < len(pat) {
+			switch c := pat[px]; c {
+			case '*':
+				starx, nextx = px, sx
+				px++
+				continue
+			case '?':
+				if sx < len(str) {
+					px++
+					sx++
+					continue
+				}
+			case '[':
+				if sx >= len(str) {
+					break
+				}
+				ok, n, valid := m.matchClass(pat[px:], str[sx])
+				if !valid && str[sx] == c {
+					px++
+					sx++
+					continue
+				}
+				if valid && ok {
+					px += n
+					sx++
+					continue
+				}
+			case '\\':
+				if px+1 < len(pat) {
+					px++
+					c = pat[px]
+				}
+				if sx < len(str) && m.equal(c, str[sx]) {
+					px++
+					sx++
+					continue
+				}
+			default:
+				if sx < len(str) && m.equal(c, str[sx]) {
+					px++
+					sx++
+					continue
+				}
+			}
+		}
+		if starx >= 0 && nextx < len(str) {
+			nextx++
+			px, sx = starx+1, nextx
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// matchExt is the backtracking version of match used for patterns containing
+// extended patterns
+func (m matcher) matchExt(pat, str []rune) bool {
+	for len(pat) > 0 {
+		if n := extglobEnd(pat); n > 0 {
+			return m.matchGroup(pat[0], splitAlternatives(pat[2:n-1]), pat[n:], str)
+		}
+		c := pat[0]
+		switch c {
+		case '*':
+			for len(pat) > 0 && pat[0] == '*' && extglobEnd(pat) == 0 {
+				pat = pat[1:]
+			}
+			for i := 0; i <= len(str); i++ {
+				if m.matchExt(pat, str[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(str) == 0 {
+				return false
+			}
+			pat, str = pat[1:], str[1:]
+			continue
+		case '[':
+			if len(str) == 0 {
+				return false
+			}
+			ok, n, valid := m.matchClass(pat, str[0])
+			if valid {
+				if !ok {
+					return false
+				}
+				pat, str = pat[n:], str[1:]
+				continue
+			}
+		case '\\':
+			if len(pat) > 1 {
+				pat = pat[1:]
+				c = pat[0]
+			}
+		}
+		if len(str) == 0 || !m.equal(c, str[0]) {
+			return false
+		}
+		pat, str = pat[1:], str[1:]
+	}
+	return len(str) == 0
+}
+
+// matchGroup matches str against the extended pattern op(alts) followed by the
+// pattern rest
+func (m matcher) matchGroup(op rune, alts [][]rune, rest, str []rune) bool {
+	matchAny := func(str []rune) bool {
+		for _, a := range alts {
+			if m.matchExt(a, str) {
+				return true
+			}
+		}
+		return false
+	}
+	switch op {
+	case '?', '@':
+		if op == '?' && m.matchExt(rest, str) {
+			return true
+		}
+		for i := 0; i <= len(str); i++ {
+			if matchAny(str[:i]) && m.matchExt(rest, str[i:]) {
+				return true
+			}
+		}
+	case '*':
+		if m.matchExt(rest, str) {
+			return true
+		}
+		for i := 1; i <= len(str); i++ {
+			if matchAny(str[:i]) && m.matchGroup(op, alts, rest, str[i:]) {
+				return true
+			}
+		}
+	case '+':
+		for i := 0; i <= len(str); i++ {
+			if matchAny(str[:i]) && m.matchGroup('*', alts, rest, str[i:]) {
+				return true
+			}
+		}
+	case '!':
+		for i := 0; i <= len(str); i++ {
+			if !matchAny(str[:i]) && m.matchExt(rest, str[i:]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// extglobEnd gives the width of the extended pattern at the start of pat or 0
+// if pat does not start with an extended pattern
+func extglobEnd(pat []rune) int {
+	if len(pat) < 2 || pat[1] != '(' || !strings.ContainsRune("?*+@!", pat[0]) {
+		return 0
+	}
+	var depth int
+	for i := 1; i < len(pat); i++ {
+		switch pat[i] {
+		case '\\':
+			i++
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return 0
+}
+
+func hasExtglob(pat []rune) bool {
+	for i := 0; i < len(pat); i++ {
+		if pat[i] == '\\' {
+			i++
+			continue
+		}
+		if extglobEnd(pat[i:]) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// splitAlternatives splits the list of an extended pattern on the '|' that are
+// not nested in another extended pattern
+func splitAlternatives(pat []rune) [][]rune {
+	var (
+		list  [][]rune
+		depth int
+		prev  int
+	)
+	for i := 0; i < len(pat); i++ {
+		switch pat[i] {
+		case '\\':
+			i++
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case '|':
+			if depth == 0 {
+				list = append(list, pat[prev:i])
+				prev = i + 1
+			}
+		}
+	}
+	return append(list, pat[prev:])
+}
+
+var charClasses = map[string]func(rune) bool{
+	"alnum": func(r rune) bool {
+		return unicode.IsLetter(r) || unicode.IsDigit(r)
+	},
+	"alpha": unicode.IsLetter,
+	"blank": func(r rune) bool {
+		return r == ' ' || r == '\t'
+	},
+	"cntrl": unicode.IsControl,
+	"digit": func(r rune) bool {
+		return r >= '0' && r <= '9'
+	},
+	"graph": func(r rune) bool {
+		return unicode.IsGraphic(r) && !unicode.IsSpace(r)
+	},
+	"lower": unicode.IsLower,
+	"print": unicode.IsPrint,
+	"punct": unicode.IsPunct,
+	"space": unicode.IsSpace,
+	"upper": unicode.IsUpper,
+	"word": func(r rune) bool {
+		return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+	},
+	"xdigit": func(r rune) bool {
+		return strings.ContainsRune("0123456789abcdefABCDEF", r)
+	},
+}
+
+// matchClass matches char against the bracket expression at the start of pat. It
+// returns whether char is matched, the width of the expression and whether the
+// expression is well formed.
+func (m matcher) matchClass(pat []rune, char rune) (bool, int, bool) {
+	var (
+		i      = 1
+		negate bool
+		found  bool
+		chars  = []rune{char}
+	)
+	if m.fold {
+		chars = append(chars, unicode.ToLower(char), unicode.ToUpper(char))
+	}
+	if i < len(pat) && (pat[i] == '!' || pat[i] == '^') {
+		negate = true
+		i++
+	}
+	for first := true; i < len(pat); first = false {
+		if pat[i] == ']' && !first {
+			return found != negate, i + 1, true
+		}
+		if pat[i] == '[' && i+1 < len(pat) && pat[i+1] == ':' {
+			if n, ok := matchCharClass(pat[i:], chars); n > 0 {
+				found = found || ok
+				i += n
+				continue
+			}
+		}
+		lo := pat[i]
+		if lo == '\\' && i+1 < len(pat) {
+			i++
+			lo = pat[i]
+		}
+		i++
+		hi := lo
+		if i+1 < len(pat) && pat[i] == '-' && pat[i+1] != ']' {
+			hi = pat[i+1]
+			if hi == '\\' && i+2 < len(pat) {
+				i++
+				hi = pat[i+1]
+			}
+			i += 2
+		}
+		for _, c := range chars {
+			if lo <= c && c <= hi {
+				found = true
+			}
+		}
+	}
+	return false, 0, false
+}
+
+// matchCharClass matches chars against the character class ([:name:]) at the
+// start of pat. It returns the width of the class, 0 if pat does not start with
+// a known class, and whether one of chars belongs to the class
+func matchCharClass(pat []rune, chars []rune) (int, bool) {
+	var end int
+	for i := 2; i+1 < len(pat); i++ {
+		if pat[i] == ':' && pat[i+1] == ']' {
+			end = i
+			break
+		}
+	}
+	if end == 0 {
+		return 0, false
+	}
+	is, ok := charClasses[string(pat[2:end])]
+	if !ok {
+		return 0, false
+	}
+	for _, c := range chars {
+		if is(c) {
+			return end + 2, true
+		}
+	}
+	return end + 2, false
+}
+
+// glob gives the list of files of fsys matching pattern, sorted. Hidden files
+// are only matched when the pattern of their name starts explicitly with a dot
+// unless the dotglob option is set.
+func glob(fsys FileSystem, pattern string, opts GlobOptions) []string {
+	var (
+		dirOnly = strings.HasSuffix(pattern, "/")
+		parts   = strings.Split(strings.TrimRight(pattern, "/"), "/")
+		list    = []string{""}
+		m       = matcher{
+			extglob: opts.Extglob,
+			fold:    opts.Nocaseglob,
+		}
+	)
+	if parts[0] == "" {
+		list, parts = []string{"/"}, parts[1:]
+	}
+	for i, p := range parts {
+		if p == "" {
+			continue
+		}
+		var (
+			next []string
+			last = i == len(parts)-1 && !dirOnly
+		)
+		for _, dir := range list {
+			if p == "**" && opts.Globstar {
+				next = append(next, globStar(fsys, dir, last, opts.Dotglob)...)
+				continue
+			}
+			next = append(next, globDir(fsys, dir, p, last, m, opts.Dotglob)...)
+		}
+		if list = next; len(list) == 0 {
+			break
+		}
+	}
+	if dirOnly {
+		var dirs []string
+		for _, file := range list {
+			if i, err := fsys.Stat(file); err == nil && i.IsDir() && file != "" {
+				dirs = append(dirs, strings.TrimSuffix(file, "/")+"/")
+			}
+		}
+		list = dirs
+	}
+	sort.Strings(list)
+	return list
+}
+
+// globStar gives all the files and directories below dir. The directory itself
+// is included with a trailing slash when ** is the last element of the pattern.
+// Only the directories are kept otherwise since they are used to match the
+// rest of the pattern
+func globStar(fsys FileSystem, dir string, last, dotglob bool) []string {
+	var list []string
+	switch {
+	case !last:
+		list = append(list, dir)
+	case dir != "" && dir != "/":
+		list = append(list, strings.TrimSuffix(dir, "/")+"/")
+	}
+	walkDir(fsys, dir, dotglob, func(file string, isDir bool) {
+		if last || isDir {
+			list = append(list, file)
+		}
+	})
+	return list
+}
+
+// walkDir calls fn for each file below dir. Symbolic links to directories are
+// not followed
+func walkDir(fsys FileSystem, dir string, dotglob bool, fn func(string, bool)) {
+	base := dir
+	if base == "" {
+		base = "."
+	}
+	es, err := fsys.ReadDir(base)
+	if err != nil {
+		return
+	}
+	for _, e := range es {
+		name := e.Name()
+		if strings.HasPrefix(name, ".") && !dotglob {
+			continue
+		}
+		file := filepath.Join(dir, name)
+		fn(file, e.IsDir())
+		if e.IsDir() {
+			walkDir(fsys, file, dotglob, fn)
+		}
+	}
+}
+
+func globDir(fsys FileSystem, dir, pattern string, last bool, m matcher, dotglob bool) []string {
+	if !isGlob(pattern, m.extglob) {
+		file := filepath.Join(dir, unescapePattern(pattern))
+		if _, err := fsys.Lstat(file); err != nil {
+			return nil
+		}
+		return []string{file}
+	}
+	base := dir
+	if base == "" {
+		base = "."
+	}
+	es, err := fsys.ReadDir(base)
+	if err != nil {
+		return nil
+	}
+	var list []string
+	for _, e := range es {
+		name := e.Name()
+		if strings.HasPrefix(name, ".") && !strings.HasPrefix(pattern, ".") && !dotglob {
+			continue
+		}
+		if !last && !e.IsDir() {
+			if i, err := fsys.Stat(filepath.Join(base, name)); err != nil || !i.IsDir() {
+				continue
+			}
+		}
+		if m.Match(pattern, name) {
+			list = append(list, filepath.Join(dir, name))
+		}
+	}
+	return list
+}
+
+func unescapePattern(str string) string {
+	if !strings.Contains(str, "\\") {
+		return str
+	}
+	var (
+		buf strings.Builder
+		esc bool
+	)
+	for _, r := range str {
+		if r == '\\' && !esc {
+			esc = true
+			continue
+		}
+		esc = false
+		buf.WriteRune(r)
+	}
+	return buf.String()
+}
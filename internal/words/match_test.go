@@ -0,0 +1,138 @@
+package words_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/midbel/tish"
+	"github.com/midbel/tish/internal/words"
+)
+
+func TestMatch(t *testing.T) {
+	data := []struct {
+		Pattern string
+		Input   string
+		Want    bool
+	}{
+		{Pattern: "", Input: "", Want: true},
+		{Pattern: "*", Input: "", Want: true},
+		{Pattern: "*", Input: "foobar", Want: true},
+		{Pattern: "foo*", Input: "foobar", Want: true},
+		{Pattern: "*bar", Input: "foobar", Want: true},
+		{Pattern: "*o*a*", Input: "foobar", Want: true},
+		{Pattern: "*.go", Input: "foobar", Want: false},
+		{Pattern: "f?o", Input: "foo", Want: true},
+		{Pattern: "f?o", Input: "fo", Want: false},
+		{Pattern: "[a-f]oo", Input: "foo", Want: true},
+		{Pattern: "[!a-f]oo", Input: "foo", Want: false},
+		{Pattern: "[^a-f]oo", Input: "zoo", Want: true},
+		{Pattern: "[]]", Input: "]", Want: true},
+		{Pattern: "[ab", Input: "[ab", Want: true},
+		{Pattern: `\*`, Input: "*", Want: true},
+		{Pattern: `\*`, Input: "a", Want: false},
+		{Pattern: `foo\`, Input: `foo\`, Want: true},
+		{Pattern: "*/*", Input: "usr/bin", Want: true},
+		{Pattern: "[[:alpha:]][[:digit:]]", Input: "a1", Want: true},
+		{Pattern: "[[:upper:]]*", Input: "foo", Want: false},
+		{Pattern: "[![:space:]_]", Input: "x", Want: true},
+		{Pattern: "[![:space:]_]", Input: "_", Want: false},
+		{Pattern: "@(foo|bar).go", Input: "bar.go", Want: true},
+		{Pattern: "@(foo|bar).go", Input: "foobar.go", Want: false},
+		{Pattern: "?(x)foo", Input: "foo", Want: true},
+		{Pattern: "?(x)foo", Input: "xxfoo", Want: false},
+		{Pattern: "*(ab)c", Input: "ababc", Want: true},
+		{Pattern: "+(ab)c", Input: "c", Want: false},
+		{Pattern: "+([0-9]).txt", Input: "123.txt", Want: true},
+		{Pattern: "!(*.go)", Input: "main.go", Want: false},
+		{Pattern: "!(*.go)", Input: "main.c", Want: true},
+		{Pattern: "*.@(c|+(h))", Input: "main.hh", Want: true},
+		{Pattern: `\@(a)`, Input: "@(a)", Want: true},
+	}
+	for _, d := range data {
+		got := words.Match(d.Pattern, d.Input)
+		if got != d.Want {
+			t.Errorf("%s (%s): result mismatched! want %t, got %t", d.Pattern, d.Input, d.Want, got)
+		}
+	}
+}
+
+type globEnv struct {
+	tish.Environment
+	cwd  string
+	opts words.GlobOptions
+}
+
+func (e globEnv) Cwd() string {
+	return e.cwd
+}
+
+func (e globEnv) FileSystem() words.FileSystem {
+	return words.OSFileSystem()
+}
+
+func (e globEnv) GlobOptions() words.GlobOptions {
+	return e.opts
+}
+
+func TestGlob(t *testing.T) {
+	dir := t.TempDir()
+	for _, f := range []string{"a/x.go", "a/b/y.go", "z.go", "README", ".h/w.go", ".dot"} {
+		file := filepath.Join(dir, f)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data := []struct {
+		Pattern string
+		Options words.GlobOptions
+		Want    string
+	}{
+		{Pattern: "*", Want: "README a z.go"},
+		{Pattern: "**", Want: "README a z.go"},
+		{Pattern: "**", Options: words.GlobOptions{Globstar: true}, Want: "README a a/b a/b/y.go a/x.go z.go"},
+		{Pattern: "**/*.go", Options: words.GlobOptions{Globstar: true}, Want: "a/b/y.go a/x.go z.go"},
+		{Pattern: "a/**", Options: words.GlobOptions{Globstar: true}, Want: "a/ a/b a/b/y.go a/x.go"},
+		{Pattern: "**/", Options: words.GlobOptions{Globstar: true}, Want: "a/ a/b/"},
+		{Pattern: "*/", Want: "a/"},
+		{Pattern: "*", Options: words.GlobOptions{Dotglob: true}, Want: ".dot .h README a z.go"},
+		{Pattern: ".*", Want: ".dot .h"},
+		{Pattern: "read*", Want: "read*"},
+		{Pattern: "read*", Options: words.GlobOptions{Nocaseglob: true}, Want: "README"},
+		{Pattern: "*.xyz", Options: words.GlobOptions{Nullglob: true}, Want: ""},
+		{Pattern: "@(z|q).go", Want: "@(z|q).go"},
+		{Pattern: "@(z|q).go", Options: words.GlobOptions{Extglob: true}, Want: "z.go"},
+		{Pattern: "!(z.go)", Options: words.GlobOptions{Extglob: true}, Want: "README a"},
+		{Pattern: "[[:upper:]]*", Want: "README"},
+		{Pattern: "*/*.go", Want: "a/x.go"},
+	}
+	for _, d := range data {
+		env := globEnv{
+			Environment: tish.EmptyEnv(),
+			cwd:         dir,
+			opts:        d.Options,
+		}
+		got, err := words.CreateWord(d.Pattern, false).Expand(env, true)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", d.Pattern, err)
+			continue
+		}
+		if str := strings.Join(got, " "); str != d.Want {
+			t.Errorf("%s: files mismatched! want %q, got %q", d.Pattern, d.Want, str)
+		}
+	}
+	env := globEnv{
+		Environment: tish.EmptyEnv(),
+		cwd:         dir,
+		opts:        words.GlobOptions{Failglob: true},
+	}
+	_, err := words.CreateWord("*.xyz", false).Expand(env, true)
+	if !errors.Is(err, words.ErrNoMatch) {
+		t.Errorf("*.xyz: expected ErrNoMatch, got %v", err)
+	}
+}
@@ -0,0 +1,112 @@
+package words
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// expandPrompt decodes the backslash escapes of a prompt string (eg: \u, \h,
+// \w). Unknown escapes are kept as is
+func expandPrompt(env Environment, str string) string {
+	if !strings.Contains(str, "\\") {
+		return str
+	}
+	var (
+		buf strings.Builder
+		rs  = []rune(str)
+		now = time.Now()
+	)
+	for i := 0; i < len(rs); i++ {
+		if rs[i] != '\\' || i+1 >= len(rs) {
+			buf.WriteRune(rs[i])
+			continue
+		}
+		i++
+		switch r := rs[i]; r {
+		case 'a':
+			buf.WriteByte('\a')
+		case 'e':
+			buf.WriteByte(0x1b)
+		case 'n':
+			buf.WriteByte('\n')
+		case 'r':
+			buf.WriteByte('\r')
+		case '\\':
+			buf.WriteByte('\\')
+		case '[', ']':
+		case 'u':
+			buf.WriteString(promptUser(env))
+		case 'h', 'H':
+			host, _ := os.Hostname()
+			if x := strings.IndexByte(host, '.'); r == 'h' && x > 0 {
+				host = host[:x]
+			}
+			buf.WriteString(host)
+		case 'w', 'W':
+			dir := promptDir(env)
+			if home := resolveFirst(env, "HOME"); home != "" && home != "/" {
+				if dir == home {
+					dir = "~"
+				} else if r == 'w' && strings.HasPrefix(dir, home+"/") {
+					dir = "~" + dir[len(home):]
+				}
+			}
+			if r == 'W' && dir != "~" && dir != "/" {
+				dir = filepath.Base(dir)
+			}
+			buf.WriteString(dir)
+		case 's':
+			buf.WriteString(filepath.Base(resolveFirst(env, "SHELL")))
+		case '$':
+			if os.Geteuid() == 0 {
+				buf.WriteByte('#')
+			} else {
+				buf.WriteByte('$')
+			}
+		case 'd':
+			buf.WriteString(now.Format("Mon Jan 02"))
+		case 't':
+			buf.WriteString(now.Format("15:04:05"))
+		case 'T':
+			buf.WriteString(now.Format("03:04:05"))
+		case '@':
+			buf.WriteString(now.Format("03:04 PM"))
+		case 'A':
+			buf.WriteString(now.Format("15:04"))
+		default:
+			if r < '0' || r > '7' {
+				buf.WriteByte('\\')
+				buf.WriteRune(r)
+				break
+			}
+			n, w := parseDigits(rs[i:], 8, 3)
+			buf.WriteByte(byte(n))
+			i += w - 1
+		}
+	}
+	return buf.String()
+}
+
+func promptUser(env Environment) string {
+	if name := resolveFirst(env, "USER"); name != "" {
+		return name
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return ""
+}
+
+func promptDir(env Environment) string {
+	if e, ok := env.(FileEnv); ok && e.Cwd() != "" {
+		return e.Cwd()
+	}
+	if dir := resolveFirst(env, "PWD"); dir != "" {
+		return dir
+	}
+	dir, _ := os.Getwd()
+	return dir
+}
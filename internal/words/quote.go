@@ -0,0 +1,191 @@
+package words
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Quote returns str quoted like QuoteValue does when it can not be given back
+// to the shell unchanged
+func Quote(str string) string {
+	if str != "" && strings.IndexFunc(str, isUnsafe) < 0 {
+		return str
+	}
+	return QuoteValue(str)
+}
+
+// QuoteValue returns str always quoted like the @Q operator does: between single
+// quotes or with $'...' when str contains control characters
+func QuoteValue(str string) string {
+	if strings.IndexFunc(str, unicode.IsControl) < 0 {
+		return "'" + strings.ReplaceAll(str, "'", `'\''`) + "'"
+	}
+	var buf strings.Builder
+	buf.WriteString("$'")
+	for _, r := range str {
+		switch r {
+		case '\'', '\\':
+			buf.WriteByte('\\')
+			buf.WriteRune(r)
+		case '\a':
+			buf.WriteString(`\a`)
+		case '\b':
+			buf.WriteString(`\b`)
+		case 0x1b:
+			buf.WriteString(`\E`)
+		case '\f':
+			buf.WriteString(`\f`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		case '\v':
+			buf.WriteString(`\v`)
+		default:
+			if unicode.IsControl(r) && r < 0x80 {
+				fmt.Fprintf(&buf, "\\%03o", r)
+				break
+			}
+			buf.WriteRune(r)
+		}
+	}
+	buf.WriteByte('\'')
+	return buf.String()
+}
+
+func isUnsafe(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z':
+	case r >= 'A' && r <= 'Z':
+	case r >= '0' && r <= '9':
+	case r == '_' || r == '-' || r == '.' || r == '/' || r == '+' || r == '@' || r == '%':
+	default:
+		return true
+	}
+	return false
+}
+
+// Unescape decodes the backslash escapes of str recognized by echo -e. It also
+// reports whether str contains the \c escape: the rest of str is discarded and
+// no further output should be produced
+func Unescape(str string) (string, bool) {
+	return unescape(str, false)
+}
+
+// UnescapeQuote decodes the backslash escapes of str quoted with $'...'
+func UnescapeQuote(str string) string {
+	str, _ = unescape(str, true)
+	return str
+}
+
+// unescape decodes the backslash escapes of str. The escapes of the $'...'
+// quoting (ansi) differ from the ones of echo: octal values do not need a leading
+// zero, quotes can be escaped and \cx gives a control character
+func unescape(str string, ansi bool) (string, bool) {
+	if !strings.Contains(str, "\\") {
+		return str, false
+	}
+	var (
+		buf strings.Builder
+		rs  = []rune(str)
+	)
+	for i := 0; i < len(rs); i++ {
+		if rs[i] != '\\' || i+1 >= len(rs) {
+			buf.WriteRune(rs[i])
+			continue
+		}
+		i++
+		switch r := rs[i]; r {
+		case 'a':
+			buf.WriteByte('\a')
+		case 'b':
+			buf.WriteByte('\b')
+		case 'e', 'E':
+			buf.WriteByte(0x1b)
+		case 'f':
+			buf.WriteByte('\f')
+		case 'n':
+			buf.WriteByte('\n')
+		case 'r':
+			buf.WriteByte('\r')
+		case 't':
+			buf.WriteByte('\t')
+		case 'v':
+			buf.WriteByte('\v')
+		case '\\':
+			buf.WriteByte('\\')
+		case 'x', 'u', 'U':
+			size := 2
+			if r == 'u' {
+				size = 4
+			} else if r == 'U' {
+				size = 8
+			}
+			n, w := parseDigits(rs[i+1:], 16, size)
+			if w == 0 {
+				buf.WriteByte('\\')
+				buf.WriteRune(r)
+				break
+			}
+			if r == 'x' {
+				buf.WriteByte(byte(n))
+			} else {
+				buf.WriteRune(rune(n))
+			}
+			i += w
+		case 'c':
+			if !ansi {
+				return buf.String(), true
+			}
+			if i+1 < len(rs) {
+				i++
+				buf.WriteByte(byte(unicode.ToUpper(rs[i])) & 0x1f)
+				break
+			}
+			buf.WriteString("\\c")
+		case '\'', '"', '?':
+			if !ansi {
+				buf.WriteByte('\\')
+			}
+			buf.WriteRune(r)
+		default:
+			if r < '0' || r > '7' {
+				buf.WriteByte('\\')
+				buf.WriteRune(r)
+				break
+			}
+			if !ansi && r == '0' {
+				n, w := parseDigits(rs[i+1:], 8, 3)
+				buf.WriteByte(byte(n))
+				i += w
+				break
+			}
+			if !ansi {
+				buf.WriteByte('\\')
+				buf.WriteRune(r)
+				break
+			}
+			n, w := parseDigits(rs[i:], 8, 3)
+			buf.WriteByte(byte(n))
+			i += w - 1
+		}
+	}
+	return buf.String(), false
+}
+
+// parseDigits parses at most size digits in the given base at the start of rs.
+// It returns the value of the digits and their number
+func parseDigits(rs []rune, base, size int) (int, int) {
+	var n, i int
+	for ; i < len(rs) && i < size; i++ {
+		d := strings.IndexRune("0123456789abcdef", unicode.ToLower(rs[i]))
+		if d < 0 || d >= base {
+			break
+		}
+		n = n*base + d
+	}
+	return n, i
+}
@@ -0,0 +1,87 @@
+package words_test
+
+import (
+	"testing"
+
+	"github.com/midbel/tish/internal/words"
+)
+
+func TestUnescape(t *testing.T) {
+	data := []struct {
+		Input string
+		Want  string
+		Stop  bool
+	}{
+		{Input: `foobar`, Want: "foobar"},
+		{Input: `a\tb\nc`, Want: "a\tb\nc"},
+		{Input: `\x41\x4a\xzz`, Want: "AJ\\xzz"},
+		{Input: `é\U0001F600`, Want: "é😀"},
+		{Input: `\e[0m`, Want: "\x1b[0m"},
+		{Input: `\0101\101`, Want: "A\\101"},
+		{Input: `\'\"\q\\`, Want: `\'\"\q\`},
+		{Input: `foo\cbar`, Want: "foo", Stop: true},
+	}
+	for _, d := range data {
+		got, stop := words.Unescape(d.Input)
+		if got != d.Want || stop != d.Stop {
+			t.Errorf("%s: result mismatched! want %q (%t), got %q (%t)", d.Input, d.Want, d.Stop, got, stop)
+		}
+	}
+}
+
+func TestUnescapeQuote(t *testing.T) {
+	data := []struct {
+		Input string
+		Want  string
+	}{
+		{Input: `a\tb`, Want: "a\tb"},
+		{Input: `\101\0101\7`, Want: "A\x08" + "1\a"},
+		{Input: `\'\"\?`, Want: `'"?`},
+		{Input: `\cA\c[`, Want: "\x01\x1b"},
+		{Input: `\q`, Want: `\q`},
+	}
+	for _, d := range data {
+		got := words.UnescapeQuote(d.Input)
+		if got != d.Want {
+			t.Errorf("%s: result mismatched! want %q, got %q", d.Input, d.Want, got)
+		}
+	}
+}
+
+func TestQuoteValue(t *testing.T) {
+	data := []struct {
+		Input string
+		Want  string
+	}{
+		{Input: ``, Want: `''`},
+		{Input: `foobar`, Want: `'foobar'`},
+		{Input: `it's`, Want: `'it'\''s'`},
+		{Input: "a\tb'c", Want: `$'a\tb\'c'`},
+		{Input: "\x1b[0m\x01", Want: `$'\E[0m\001'`},
+	}
+	for _, d := range data {
+		got := words.QuoteValue(d.Input)
+		if got != d.Want {
+			t.Errorf("%q: result mismatched! want %s, got %s", d.Input, d.Want, got)
+		}
+	}
+}
+
+func TestQuote(t *testing.T) {
+	data := []struct {
+		Input string
+		Want  string
+	}{
+		{Input: ``, Want: `''`},
+		{Input: `foobar`, Want: `foobar`},
+		{Input: `foo bar`, Want: `'foo bar'`},
+		{Input: `it's`, Want: `'it'\''s'`},
+		{Input: "a\tb", Want: `$'a\tb'`},
+	}
+	for _, d := range data {
+		got := words.Quote(d.Input)
+		if got != d.Want {
+			t.Errorf("%q: result mismatched! want %s, got %s", d.Input, d.Want, got)
+		}
+	}
+}
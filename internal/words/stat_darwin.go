@@ -0,0 +1,17 @@
+package words
+
+import (
+	"io/fs"
+	"syscall"
+	"time"
+)
+
+const ioctlGetTermios = syscall.TIOCGETA
+
+func accessTime(fi fs.FileInfo) (time.Time, bool) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(st.Atimespec.Sec), int64(st.Atimespec.Nsec)), true
+}
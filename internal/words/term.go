@@ -0,0 +1,15 @@
+//go:build linux || darwin
+
+package words
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// isTerminal reports whether fd is open and refers to a terminal
+func isTerminal(fd int) bool {
+	var st syscall.Termios
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), ioctlGetTermios, uintptr(unsafe.Pointer(&st)))
+	return errno == 0
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin
+
+package words
+
+// isTerminal can not check the file descriptors on the other systems: they are
+// never reported as terminals
+func isTerminal(fd int) bool {
+	return false
+}
@@ -4,7 +4,9 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"regexp"
 	"strconv"
+	"strings"
 
 	"github.com/midbel/tish/internal/token"
 )
@@ -13,6 +15,15 @@ import (
 // tested criteria are not met
 var ErrTest = errors.New("test")
 
+const varRematch = "BASH_REMATCH"
+
+// modes of access(2)
+const (
+	accessExec  = 0x1
+	accessWrite = 0x2
+	accessRead  = 0x4
+)
+
 type Tester interface {
 	Expander
 	Test(Environment) (bool, error)
@@ -51,17 +62,41 @@ func (t UnaryTest) Test(env Environment) (bool, error) {
 	case token.FileSize:
 		return t.fileSize(env)
 	case token.FileRead:
-		return t.fileReadable(env)
+		return t.fileAccess(env, accessRead)
 	case token.FileWrite:
-		return t.fileWritable(env)
+		return t.fileAccess(env, accessWrite)
+	case token.FileExec:
+		return t.fileAccess(env, accessExec)
 	case token.FileRegular:
 		return t.fileRegular(env)
 	case token.FileLink:
 		return t.fileLink(env)
-	case token.FileExec:
-		return t.fileExec(env)
 	case token.FileDir:
 		return t.fileDirectory(env)
+	case token.FileBlock:
+		return t.fileMode(env, os.ModeDevice|os.ModeCharDevice, os.ModeDevice)
+	case token.FileChar:
+		return t.fileMode(env, os.ModeDevice|os.ModeCharDevice, os.ModeDevice|os.ModeCharDevice)
+	case token.FilePipe:
+		return t.fileMode(env, os.ModeNamedPipe, os.ModeNamedPipe)
+	case token.FileSocket:
+		return t.fileMode(env, os.ModeSocket, os.ModeSocket)
+	case token.FileSetuid:
+		return t.fileMode(env, os.ModeSetuid, os.ModeSetuid)
+	case token.FileSetgid:
+		return t.fileMode(env, os.ModeSetgid, os.ModeSetgid)
+	case token.FileSticky:
+		return t.fileMode(env, os.ModeSticky, os.ModeSticky)
+	case token.FileOwner:
+		return t.fileOwner(env)
+	case token.FileGroup:
+		return t.fileGroup(env)
+	case token.FileModified:
+		return t.fileModified(env)
+	case token.FileTerm:
+		return t.fileTerminal(env)
+	case token.VarSet:
+		return t.varSet(env)
 	case token.StrNotEmpty:
 		str, err := expandSingle(t.Right, env)
 		if err != nil {
@@ -85,28 +120,17 @@ func (t UnaryTest) fileExists(env Environment) (bool, error) {
 	})
 }
 
-func (t UnaryTest) fileReadable(env Environment) (bool, error) {
-	return statFileWith(t.Right, env, func(fi os.FileInfo) bool {
-		var (
-			perm  = fi.Mode().Perm()
-			owner = perm&0600 != 0
-			group = perm&0060 != 0
-			other = perm&0006 != 0
-		)
-		return owner || group || other
-	})
-}
-
-func (t UnaryTest) fileWritable(env Environment) (bool, error) {
-	return statFileWith(t.Right, env, func(fi os.FileInfo) bool {
-		var (
-			perm  = fi.Mode().Perm()
-			owner = perm&0400 != 0
-			group = perm&0040 != 0
-			other = perm&0004 != 0
-		)
-		return owner || group || other
-	})
+// fileAccess checks the permission of the file for the effective user and
+// groups of the shell
+func (t UnaryTest) fileAccess(env Environment, mode uint32) (bool, error) {
+	file, err := expandSingle(t.Right, env)
+	if err != nil {
+		return false, err
+	}
+	if file == "" {
+		return false, nil
+	}
+	return WorkingFS(env).Access(file, mode) == nil, nil
 }
 
 func (t UnaryTest) fileSize(env Environment) (bool, error) {
@@ -128,23 +152,65 @@ func (t UnaryTest) fileDirectory(env Environment) (bool, error) {
 }
 
 func (t UnaryTest) fileLink(env Environment) (bool, error) {
+	file, err := expandSingle(t.Right, env)
+	if err != nil {
+		return false, err
+	}
+	fi, err := WorkingFS(env).Lstat(file)
+	if err != nil {
+		return false, nil
+	}
+	return fi.Mode()&os.ModeSymlink == os.ModeSymlink, nil
+}
+
+func (t UnaryTest) fileMode(env Environment, mask, want os.FileMode) (bool, error) {
 	return statFileWith(t.Right, env, func(fi os.FileInfo) bool {
-		return fi.Mode()&os.ModeSymlink == os.ModeSymlink
+		return fi.Mode()&mask == want
 	})
 }
 
-func (t UnaryTest) fileExec(env Environment) (bool, error) {
+func (t UnaryTest) fileOwner(env Environment) (bool, error) {
 	return statFileWith(t.Right, env, func(fi os.FileInfo) bool {
-		var (
-			perm  = fi.Mode().Perm()
-			owner = perm&0100 != 0
-			group = perm&0010 != 0
-			other = perm&0001 != 0
-		)
-		return owner || group || other
+		uid, _, ok := fileOwner(fi)
+		return ok && uid == os.Geteuid()
 	})
 }
 
+func (t UnaryTest) fileGroup(env Environment) (bool, error) {
+	return statFileWith(t.Right, env, func(fi os.FileInfo) bool {
+		_, gid, ok := fileOwner(fi)
+		return ok && gid == os.Getegid()
+	})
+}
+
+func (t UnaryTest) fileModified(env Environment) (bool, error) {
+	return statFileWith(t.Right, env, func(fi os.FileInfo) bool {
+		atime, ok := accessTime(fi)
+		return ok && fi.ModTime().After(atime)
+	})
+}
+
+func (t UnaryTest) fileTerminal(env Environment) (bool, error) {
+	str, err := expandSingle(t.Right, env)
+	if err != nil {
+		return false, err
+	}
+	fd, err := strconv.Atoi(str)
+	if err != nil || fd < 0 {
+		return false, nil
+	}
+	return isTerminal(fd), nil
+}
+
+func (t UnaryTest) varSet(env Environment) (bool, error) {
+	ident, err := expandSingle(t.Right, env)
+	if err != nil {
+		return false, err
+	}
+	_, err = env.Resolve(ident)
+	return err == nil, nil
+}
+
 type BinaryTest struct {
 	Op    rune
 	Left  Expander
@@ -178,13 +244,36 @@ func (t BinaryTest) Test(env Environment) (bool, error) {
 		}
 		return testExpander(t.Right, env)
 	case token.Eq:
-		return t.compare(env, func(left, right string) bool {
+		return t.match(env)
+	case token.Ne:
+		ok, err := t.match(env)
+		return !ok, err
+	case token.ReMatch:
+		return t.matchRegexp(env)
+	case token.NumEq:
+		return t.compareNumbers(env, func(left, right float64) bool {
 			return left == right
 		})
-	case token.Ne:
-		return t.compare(env, func(left, right string) bool {
+	case token.NumNe:
+		return t.compareNumbers(env, func(left, right float64) bool {
 			return left != right
 		})
+	case token.NumLt:
+		return t.compareNumbers(env, func(left, right float64) bool {
+			return left < right
+		})
+	case token.NumLe:
+		return t.compareNumbers(env, func(left, right float64) bool {
+			return left <= right
+		})
+	case token.NumGt:
+		return t.compareNumbers(env, func(left, right float64) bool {
+			return left > right
+		})
+	case token.NumGe:
+		return t.compareNumbers(env, func(left, right float64) bool {
+			return left >= right
+		})
 	case token.Lt:
 		return t.compare(env, func(left, right string) bool {
 			return left < right
@@ -224,67 +313,169 @@ func (t BinaryTest) compare(env Environment, cmp func(left, right string) bool)
 	return cmp(left, right), nil
 }
 
-func (t BinaryTest) olderThan(env Environment) (bool, error) {
-	left, err := statFile(t.Left, env)
+// match compares the left operand to the pattern given by the right operand
+func (t BinaryTest) match(env Environment) (bool, error) {
+	left, err := expandSingle(t.Left, env)
 	if err != nil {
 		return false, err
 	}
-	right, err := statFile(t.Right, env)
+	pattern, err := expandPattern(env, t.Right)
 	if err != nil {
 		return false, err
 	}
-	return left.ModTime().Before(right.ModTime()), nil
+	return Match(pattern, left), nil
 }
 
-func (t BinaryTest) newerThan(env Environment) (bool, error) {
-	left, err := statFile(t.Left, env)
+// matchRegexp matches the left operand against the regular expression given by
+// the right operand and stores the matched substrings in BASH_REMATCH
+func (t BinaryTest) matchRegexp(env Environment) (bool, error) {
+	left, err := expandSingle(t.Left, env)
 	if err != nil {
 		return false, err
 	}
-	right, err := statFile(t.Right, env)
+	list := []Expander{t.Right}
+	if m, ok := t.Right.(ExpandMulti); ok {
+		list = m.List
+	}
+	var buf strings.Builder
+	for _, e := range list {
+		str, err := e.Expand(env, false)
+		if err != nil {
+			return false, err
+		}
+		for i := range str {
+			if e.IsQuoted() {
+				str[i] = regexp.QuoteMeta(str[i])
+			}
+			buf.WriteString(str[i])
+		}
+	}
+	re, err := regexp.Compile(buf.String())
+	if err != nil {
+		return false, err
+	}
+	matches := re.FindStringSubmatch(left)
+	if matches == nil {
+		matches = []string{}
+	}
+	if err := env.Define(varRematch, matches); err != nil {
+		return false, err
+	}
+	return len(matches) > 0, nil
+}
+
+func (t BinaryTest) compareNumbers(env Environment, cmp func(left, right float64) bool) (bool, error) {
+	left, err := evalOperand(t.Left, env)
+	if err != nil {
+		return false, err
+	}
+	right, err := evalOperand(t.Right, env)
 	if err != nil {
 		return false, err
 	}
-	return left.ModTime().After(right.ModTime()), nil
+	return cmp(left, right), nil
 }
 
-func (t BinaryTest) sameFile(env Environment) (bool, error) {
-	left, err := statFile(t.Left, env)
+// olderThan reports whether the left file is older than the right file or if
+// the right file exists and the left file does not
+func (t BinaryTest) olderThan(env Environment) (bool, error) {
+	left, right, err := t.statFiles(env)
 	if err != nil {
 		return false, err
 	}
-	right, err := statFile(t.Right, env)
+	switch {
+	case left == nil:
+		return right != nil, nil
+	case right == nil:
+		return false, nil
+	default:
+		return left.ModTime().Before(right.ModTime()), nil
+	}
+}
+
+// newerThan reports whether the left file is newer than the right file or if
+// the left file exists and the right file does not
+func (t BinaryTest) newerThan(env Environment) (bool, error) {
+	left, right, err := t.statFiles(env)
 	if err != nil {
 		return false, err
 	}
+	switch {
+	case left == nil:
+		return false, nil
+	case right == nil:
+		return true, nil
+	default:
+		return left.ModTime().After(right.ModTime()), nil
+	}
+}
+
+func (t BinaryTest) sameFile(env Environment) (bool, error) {
+	left, right, err := t.statFiles(env)
+	if err != nil || left == nil || right == nil {
+		return false, err
+	}
 	return os.SameFile(left, right), nil
 }
 
+// statFiles gives the info of the files given by both operands. The info of a
+// file that does not exist is nil.
+func (t BinaryTest) statFiles(env Environment) (os.FileInfo, os.FileInfo, error) {
+	left, err := statFile(t.Left, env)
+	if err != nil {
+		return nil, nil, err
+	}
+	right, err := statFile(t.Right, env)
+	return left, right, err
+}
+
+// statFileWith calls stat with the info of the file given by ex. The test
+// fails without error if the file can not be found
 func statFileWith(ex Expander, env Environment, stat func(os.FileInfo) bool) (bool, error) {
 	fi, err := statFile(ex, env)
-	if err != nil {
+	if err != nil || fi == nil {
 		return false, err
 	}
 	return stat(fi), nil
 }
 
+// statFile gives the info of the file given by ex or nil if the file can not be
+// found
 func statFile(ex Expander, env Environment) (os.FileInfo, error) {
 	str, err := expandSingle(ex, env)
 	if err != nil {
 		return nil, err
 	}
-	return os.Stat(str)
+	fi, err := WorkingFS(env).Stat(str)
+	if err != nil {
+		return nil, nil
+	}
+	return fi, nil
+}
+
+// evalOperand gives the value of the arithmetic expression given by ex
+func evalOperand(ex Expander, env Environment) (float64, error) {
+	str, err := expandSingle(ex, env)
+	if err != nil {
+		return 0, err
+	}
+	if str = strings.TrimSpace(str); str == "" {
+		return 0, nil
+	}
+	if e, ok := env.(MathEnv); ok {
+		return e.Evaluate(str)
+	}
+	return strconv.ParseFloat(str, 64)
 }
 
+// expandSingle expands ex to a single word: words are never split nor globbed in
+// tests
 func expandSingle(ex Expander, env Environment) (string, error) {
-	str, err := ex.Expand(env, true)
+	str, err := ex.Expand(env, false)
 	if err != nil {
 		return "", err
 	}
-	if len(str) != 1 {
-		return "", fmt.Errorf("%w: expected only 1 value to be expanded (got %dd)", ErrExpansion, len(str))
-	}
-	return str[0], nil
+	return strings.Join(str, " "), nil
 }
 
 func testExpander(ex Expander, env Environment) (bool, error) {
@@ -1,6 +1,9 @@
 package words_test
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/midbel/tish"
@@ -16,17 +19,17 @@ func TestTester(t *testing.T) {
 	}{
 		{
 			Name:  "not empty",
-			Input: `[[ -z str ]]`,
+			Input: `[[ -n str ]]`,
 			Want:  true,
 		},
 		{
 			Name:  "empty(1)",
-			Input: `[[ -n str ]]`,
+			Input: `[[ -z str ]]`,
 			Want:  false,
 		},
 		{
 			Name:  "empty(2)",
-			Input: `[[ -n "" ]]`,
+			Input: `[[ -z "" ]]`,
 			Want:  true,
 		},
 		{
@@ -64,9 +67,49 @@ func TestTester(t *testing.T) {
 			Input: `[[ ! -s tests_test.go ]]`,
 			Want:  false,
 		},
+		{
+			Name:  "file-missing",
+			Input: `[[ -e missing.go || -r missing.go || -L missing.go ]]`,
+			Want:  false,
+		},
+		{
+			Name:  "file-link",
+			Input: `[[ -L tests_test.go || -h tests_test.go ]]`,
+			Want:  false,
+		},
+		{
+			Name:  "file-special",
+			Input: `[[ -p tests_test.go || -S tests_test.go || -b tests_test.go || -c tests_test.go ]]`,
+			Want:  false,
+		},
+		{
+			Name:  "file-char",
+			Input: `[[ -c /dev/null && ! -t 99 ]]`,
+			Want:  true,
+		},
+		{
+			Name:  "file-owner",
+			Input: `[[ -O tests_test.go && -G tests_test.go && ! -u tests_test.go && ! -g tests_test.go && ! -k tests_test.go ]]`,
+			Want:  true,
+		},
+		{
+			Name:  "var-set",
+			Input: `[[ -v foo && ! -v foobar ]]`,
+			Want:  true,
+		},
+		{
+			Name:  "newer-missing",
+			Input: `[[ tests_test.go -nt missing.go && missing.go -ot tests_test.go ]]`,
+			Want:  true,
+		},
+		{
+			Name:  "same-file-missing",
+			Input: `[[ missing.go -ef missing.go ]]`,
+			Want:  false,
+		},
 		{
 			Name:  "same-file",
-			Input: `[[ tests_test.go -eq tests_test.go ]]`,
+			Input: `[[ tests_test.go -ef tests_test.go ]]`,
 			Want:  true,
 		},
 		{
@@ -79,6 +122,36 @@ func TestTester(t *testing.T) {
 			Input: `[[ $foo != $bar && $bar != $foo ]]`,
 			Want:  true,
 		},
+		{
+			Name:  "cmp-glob",
+			Input: `[[ $foo == f* && $bar != "b*" ]]`,
+			Want:  true,
+		},
+		{
+			Name:  "num-cmp",
+			Input: `[[ 10 -gt 9 && 10 -ge 10 && 9 -lt 10 && 9 -le 9 && 1 -ne 2 ]]`,
+			Want:  true,
+		},
+		{
+			Name:  "num-arithmetic",
+			Input: `[[ num*2 -eq 20 ]]`,
+			Want:  true,
+		},
+		{
+			Name:  "regex",
+			Input: `[[ $foo =~ ^f(o+)$ ]]`,
+			Want:  true,
+		},
+		{
+			Name:  "regex(quoted)",
+			Input: `[[ $foo =~ "f.o" ]]`,
+			Want:  false,
+		},
+		{
+			Name:  "group",
+			Input: `[[ ! ( $foo == bar || $bar == foo ) && $foo == foo ]]`,
+			Want:  true,
+		},
 		{
 			Name:  "test",
 			Input: `[[ $foo ]]`,
@@ -90,9 +163,13 @@ func TestTester(t *testing.T) {
 			Want:  false,
 		},
 	}
+	if err := os.MkdirAll("testdata", 0755); err != nil {
+		t.Fatalf("fail to create testdata directory: %s", err)
+	}
 	env := tish.EmptyEnv()
 	env.Define("foo", []string{"foo"})
 	env.Define("bar", []string{"bar"})
+	env.Define("num", []string{"10"})
 	for _, d := range data {
 		t.Run(d.Name, func(t *testing.T) {
 			ex, err := parser.Parse(d.Input)
@@ -113,3 +190,70 @@ func TestTester(t *testing.T) {
 		})
 	}
 }
+
+func TestTesterAccess(t *testing.T) {
+	var (
+		dir  = t.TempDir()
+		root = os.Geteuid() == 0
+	)
+	data := []struct {
+		Perm os.FileMode
+		Test string
+		Want bool
+	}{
+		{Perm: 0700, Test: "-r", Want: true},
+		{Perm: 0300, Test: "-r", Want: root},
+		{Perm: 0300, Test: "-w", Want: true},
+		{Perm: 0500, Test: "-w", Want: root},
+		{Perm: 0100, Test: "-x", Want: true},
+		{Perm: 0600, Test: "-x", Want: false},
+		{Perm: 0077, Test: "-r", Want: root},
+		{Perm: 0001, Test: "-x", Want: root},
+		{Perm: 0001, Test: "-w", Want: root},
+	}
+	env := tish.EmptyEnv()
+	for i, d := range data {
+		file := filepath.Join(dir, fmt.Sprintf("file%d", i))
+		if err := os.WriteFile(file, nil, d.Perm); err != nil {
+			t.Fatalf("fail to create file: %s", err)
+		}
+		if err := os.Chmod(file, d.Perm); err != nil {
+			t.Fatalf("fail to change mode of file: %s", err)
+		}
+		ex, err := parser.Parse(fmt.Sprintf("[[ %s %s ]]", d.Test, file))
+		if err != nil {
+			t.Fatalf("%d: fail to parse: %s", i, err)
+		}
+		got, err := ex.(words.Tester).Test(env)
+		if err != nil {
+			t.Fatalf("%d: unexpected error: %s", i, err)
+		}
+		if got != d.Want {
+			t.Errorf("%s %s: results mismatched! want %t, got %t", d.Test, d.Perm, d.Want, got)
+		}
+	}
+}
+
+func TestTesterRematch(t *testing.T) {
+	ex, err := parser.Parse(`[[ 2022-10-18 =~ ^([0-9]+)-([0-9]+) ]]`)
+	if err != nil {
+		t.Fatalf("fail to parse: %s", err)
+	}
+	env := tish.EmptyEnv()
+	if ok, err := ex.(words.Tester).Test(env); !ok || err != nil {
+		t.Fatalf("regex should match (%v)", err)
+	}
+	got, err := env.Resolve("BASH_REMATCH")
+	if err != nil {
+		t.Fatalf("BASH_REMATCH not defined: %s", err)
+	}
+	want := []string{"2022-10", "2022", "10"}
+	if len(got) != len(want) {
+		t.Fatalf("length mismatched! want %d, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if want[i] != got[i] {
+			t.Errorf("strings mismatched! want %s, got %s", want[i], got[i])
+		}
+	}
+}
@@ -57,6 +57,24 @@ func WithEcho() ShellOption {
 	}
 }
 
+// WithCleanEnv prevents the shell from importing the environment of the process
+func WithCleanEnv() ShellOption {
+	return func(s *Shell) error {
+		s.clean = true
+		return nil
+	}
+}
+
+// WithExec sets the function used by exec to replace the process of the shell
+// with a command, such as syscall.Exec. Without it, exec stops the shell with an
+// ExecError
+func WithExec(fn ExecFunc) ShellOption {
+	return func(s *Shell) error {
+		s.exec = fn
+		return nil
+	}
+}
+
 func WithVar(ident string, values ...string) ShellOption {
 	return func(s *Shell) error {
 		if s.locals == nil {
@@ -66,9 +84,22 @@ func WithVar(ident string, values ...string) ShellOption {
 	}
 }
 
+// WithFileSystem sets the filesystem used to resolve the files of the shell. It
+// should be given before WithCwd in order to validate its directory
+func WithFileSystem(fsys FileSystem) ShellOption {
+	return func(s *Shell) error {
+		s.fs = fsys
+		return nil
+	}
+}
+
 func WithCwd(dir string) ShellOption {
 	return func(s *Shell) error {
-		return s.Chdir(dir)
+		dir, err := s.resolveDir(dir, false)
+		if err != nil {
+			return err
+		}
+		return s.Stack.Chdir(dir)
 	}
 }
 
@@ -7,15 +7,18 @@ import (
 	"io"
 	"math/rand"
 	"os"
+	"os/exec"
 	"os/user"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
 
 	"github.com/midbel/rw"
 	"github.com/midbel/shlex"
 	"github.com/midbel/tish/internal/parser"
-	"github.com/midbel/tish/internal/stdio"
 	"github.com/midbel/tish/internal/token"
 	"github.com/midbel/tish/internal/words"
 	"golang.org/x/sync/errgroup"
@@ -27,9 +30,37 @@ var (
 	ErrExit     = errors.New("exit")
 	ErrReadOnly = errors.New("read only")
 	ErrEmpty    = errors.New("empty command")
+	ErrReturn   = errors.New("return")
+	ErrExec     = errors.New("exec")
+
+	ErrUndefined = words.ErrUndefined
 )
 
-type ExitCode int8
+// ExecFunc replaces the process of the shell with the command file. The standard
+// streams given to the command should become the ones of the new process
+type ExecFunc func(file string, args, env []string, stdin io.Reader, stdout, stderr io.Writer) error
+
+// ExecError is returned by the shell when exec is asked to replace the shell
+// with a command while no function to do it has been given with WithExec
+type ExecError struct {
+	Path   string
+	Dir    string
+	Args   []string
+	Env    []string
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+func (e ExecError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrExec, e.Path)
+}
+
+func (e ExecError) Unwrap() error {
+	return ErrExec
+}
+
+type ExitCode int
 
 const (
 	Success ExitCode = iota
@@ -49,22 +80,33 @@ func (e ExitCode) Error() string {
 }
 
 type Shell struct {
-	locals   Environment
-	alias    map[string][]string
-	commands map[string]Command
-	find     CommandFinder
-	depth    int
-	echo     bool
+	locals    Environment
+	alias     map[string][]string
+	functions map[string]words.Executer
+	commands  map[string]Command
+	find      CommandFinder
+	depth     int
+	// number of functions being executed
+	calls   int
+	echo    bool
+	clean   bool
+	nounset bool
+	glob    words.GlobOptions
+	exec    ExecFunc
 
 	env map[string]string
 
 	Stack
+	old  string
+	fs   FileSystem
 	now  time.Time
 	rand *rand.Rand
 
 	stdin  io.Reader
 	stdout io.Writer
 	stderr io.Writer
+	// files bound to the standard streams by exec
+	files []File
 
 	context struct {
 		// PID of last executed command
@@ -75,6 +117,15 @@ type Shell struct {
 		name string
 		// arguments of last executed command
 		args []string
+		// exit codes of each command of the last executed pipeline
+		pipe []int
+	}
+
+	// state of getopts between two calls: index of the argument being parsed
+	// (OPTIND) and position in this argument of the next option to parse
+	getopts struct {
+		index int
+		pos   int
 	}
 
 	builtins map[string]Builtin
@@ -82,12 +133,14 @@ type Shell struct {
 
 func New(options ...ShellOption) (*Shell, error) {
 	sh := Shell{
-		now:      time.Now(),
-		Stack:    DirectoryStack(),
-		alias:    make(map[string][]string),
-		commands: make(map[string]Command),
-		env:      make(map[string]string),
-		builtins: builtins,
+		now:       time.Now(),
+		Stack:     DirectoryStack(),
+		fs:        OSFileSystem(),
+		alias:     make(map[string][]string),
+		functions: make(map[string]words.Executer),
+		commands:  make(map[string]Command),
+		env:       make(map[string]string),
+		builtins:  builtins,
 	}
 	sh.rand = rand.New(rand.NewSource(sh.now.Unix()))
 	cwd, _ := os.Getwd()
@@ -97,6 +150,9 @@ func New(options ...ShellOption) (*Shell, error) {
 			return nil, err
 		}
 	}
+	if !sh.clean {
+		sh.importEnv(os.Environ())
+	}
 	if sh.stdin == nil {
 		sh.stdin = rw.Empty()
 	}
@@ -148,6 +204,81 @@ func (s *Shell) Find(ctx context.Context, name string) (Command, error) {
 	return nil, fmt.Errorf("%s: command not found", name)
 }
 
+// option reports whether the option name of set is enabled
+func (s *Shell) option(name string) (bool, error) {
+	switch name {
+	case "nounset":
+		return s.nounset, nil
+	case "xtrace":
+		return s.echo, nil
+	default:
+		return false, fmt.Errorf("%s: invalid option name", name)
+	}
+}
+
+// setOption enables or disables the option name of set
+func (s *Shell) setOption(name string, on bool) error {
+	switch name {
+	case "nounset":
+		s.nounset = on
+	case "xtrace":
+		s.echo = on
+	default:
+		return fmt.Errorf("%s: invalid option name", name)
+	}
+	return nil
+}
+
+// implements words.StrictEnv
+func (s *Shell) Nounset() bool {
+	return s.nounset
+}
+
+// shoptNames are the names of the options of shopt
+var shoptNames = []string{"dotglob", "extglob", "failglob", "globstar", "nocaseglob", "nullglob"}
+
+// shopt reports whether the option name of shopt is enabled
+func (s *Shell) shopt(name string) (bool, error) {
+	opt, err := s.shoptOption(name)
+	if err != nil {
+		return false, err
+	}
+	return *opt, nil
+}
+
+// setShopt enables or disables the option name of shopt
+func (s *Shell) setShopt(name string, on bool) error {
+	opt, err := s.shoptOption(name)
+	if err == nil {
+		*opt = on
+	}
+	return err
+}
+
+func (s *Shell) shoptOption(name string) (*bool, error) {
+	switch name {
+	case "dotglob":
+		return &s.glob.Dotglob, nil
+	case "extglob":
+		return &s.glob.Extglob, nil
+	case "failglob":
+		return &s.glob.Failglob, nil
+	case "globstar":
+		return &s.glob.Globstar, nil
+	case "nocaseglob":
+		return &s.glob.Nocaseglob, nil
+	case "nullglob":
+		return &s.glob.Nullglob, nil
+	default:
+		return nil, fmt.Errorf("%s: invalid shell option name", name)
+	}
+}
+
+// implements words.GlobEnv
+func (s *Shell) GlobOptions() words.GlobOptions {
+	return s.glob
+}
+
 func (s *Shell) SetEcho(echo bool) {
 	s.echo = echo
 }
@@ -157,6 +288,20 @@ func (s *Shell) Export(ident, value string) {
 	s.env[ident] = value
 }
 
+// importEnv adds the variables of list given as name=value to the environment
+// of the shell. Variables already exported keep their value
+func (s *Shell) importEnv(list []string) {
+	for _, str := range list {
+		ident, value, ok := strings.Cut(str, "=")
+		if !ok || ident == "" {
+			continue
+		}
+		if _, ok := s.env[ident]; !ok {
+			s.env[ident] = value
+		}
+	}
+}
+
 // Unexport removes an environment variable from the shell
 func (s *Shell) Unexport(ident string) {
 	delete(s.env, ident)
@@ -180,6 +325,8 @@ func (s *Shell) Unalias(ident string) {
 func (s *Shell) Subshell() (*Shell, error) {
 	options := []ShellOption{
 		WithEnv(s),
+		WithCleanEnv(),
+		WithFileSystem(s.fs),
 		WithCwd(s.Cwd()),
 		WithStdout(s.stdout),
 		WithStderr(s.stderr),
@@ -193,9 +340,33 @@ func (s *Shell) Subshell() (*Shell, error) {
 		return nil, err
 	}
 	sub.depth = s.depth + 1
+	sub.calls = s.calls
+	sub.nounset = s.nounset
+	sub.glob = s.glob
+	sub.importEnv(s.environ())
+	sub.old = s.old
+	sub.context.name = s.context.name
+	sub.context.code = s.context.code
+	sub.context.args = append(sub.context.args, s.context.args...)
 	for n, str := range s.alias {
 		sub.alias[n] = str
 	}
+	for n, fn := range s.functions {
+		sub.functions[n] = fn
+	}
+	return sub, nil
+}
+
+// environShell gives a subshell whose only variables are the environment
+// variables of list given as name=value
+func (s *Shell) environShell(list []string) (*Shell, error) {
+	sub, err := s.Subshell()
+	if err != nil {
+		return nil, err
+	}
+	sub.locals = EmptyEnv()
+	sub.env = make(map[string]string)
+	sub.importEnv(list)
 	return sub, nil
 }
 
@@ -205,15 +376,18 @@ func (s *Shell) SetEnv(env Environment) {
 
 // implements Environment.Resolve
 func (s *Shell) Resolve(ident string) ([]string, error) {
+	if str := s.resolveSpecials(ident); len(str) > 0 {
+		return str, nil
+	}
 	str, err := s.locals.Resolve(ident)
-	if err == nil && len(str) > 0 {
+	if err == nil {
 		return str, nil
 	}
 	if v, ok := s.env[ident]; ok {
 		return []string{v}, nil
 	}
-	if str = s.resolveSpecials(ident); len(str) > 0 {
-		return str, nil
+	if !errors.Is(err, ErrUndefined) {
+		err = fmt.Errorf("%s: %w", ident, ErrUndefined)
 	}
 	return nil, err
 }
@@ -226,6 +400,16 @@ func (s *Shell) Define(ident string, values []string) error {
 	return s.locals.Define(ident, values)
 }
 
+// implements words.MathEnv
+func (s *Shell) Evaluate(str string) (float64, error) {
+	return evaluate(s, str)
+}
+
+// implements words.FileEnv
+func (s *Shell) FileSystem() FileSystem {
+	return s.fs
+}
+
 // implements Environment.Delete
 func (s *Shell) Delete(ident string) error {
 	if _, ok := specials[ident]; ok {
@@ -234,6 +418,109 @@ func (s *Shell) Delete(ident string) error {
 	return s.locals.Delete(ident)
 }
 
+// implements Environment.SetAttributes
+func (s *Shell) SetAttributes(ident string, attr Attribute) error {
+	if _, ok := specials[ident]; ok {
+		return ErrReadOnly
+	}
+	return s.locals.SetAttributes(ident, attr)
+}
+
+// implements words.RefEnv
+func (s *Shell) Reference(ident string) (string, bool) {
+	return reference(s, ident)
+}
+
+// implements words.AttrEnv
+func (s *Shell) Flags(ident string) (string, error) {
+	attr, err := s.Attributes(ident)
+	if err != nil {
+		return "", err
+	}
+	return formatFlags(attr), nil
+}
+
+// implements Environment.Attributes
+func (s *Shell) Attributes(ident string) (Attribute, error) {
+	if _, ok := specials[ident]; ok {
+		return AttrReadOnly, nil
+	}
+	return s.locals.Attributes(ident)
+}
+
+// implements Environment.Names
+func (s *Shell) Names() []string {
+	var (
+		list []string
+		seen = make(map[string]struct{})
+	)
+	add := func(n string) {
+		if _, ok := seen[n]; ok {
+			return
+		}
+		seen[n] = struct{}{}
+		if _, ok := s.lookup(n); ok {
+			list = append(list, n)
+		}
+	}
+	for _, n := range s.locals.Names() {
+		add(n)
+	}
+	for n := range s.env {
+		add(n)
+	}
+	// the special parameters ($?, $#, $@,...) are not names of variables
+	for n := range specials {
+		if unicode.IsLetter(rune(n[0])) {
+			add(n)
+		}
+	}
+	sort.Strings(list)
+	return list
+}
+
+// lookup gives the variable ident as Resolve finds it: the special variables
+// first, then the variables of the shell and finally its environment
+func (s *Shell) lookup(ident string) (variable, bool) {
+	if str := s.resolveSpecials(ident); len(str) > 0 {
+		return variable{values: str, attrs: AttrReadOnly}, true
+	}
+	str, exported := s.env[ident]
+	if f, ok := s.locals.(variableFinder); ok {
+		if v, ok := f.lookup(ident); ok {
+			if exported {
+				v.attrs |= AttrExport
+			}
+			return v, ok
+		}
+	} else if vs, err := s.locals.Resolve(ident); err == nil {
+		attr, _ := s.locals.Attributes(ident)
+		if exported {
+			attr |= AttrExport
+		}
+		return variable{values: vs, attrs: attr}, true
+	}
+	if exported {
+		return variable{values: []string{str}, attrs: AttrExport}, true
+	}
+	return variable{}, false
+}
+
+// listed gives the variable ident as declare and readonly list it without
+// arguments. The special variables set by the shell (PID, RANDOM,...) are not
+// listed since they can not be declared back. Those imported from the
+// environment (PWD, HOME,...) are listed as exported with their current value
+func (s *Shell) listed(ident string) (variable, bool) {
+	v, ok := s.lookup(ident)
+	if _, special := specials[ident]; special && ok && v.attrs == AttrReadOnly {
+		if _, ok := s.env[ident]; !ok {
+			return variable{}, false
+		}
+		v.attrs = AttrExport
+	}
+	return v, ok
+}
+
 func (s *Shell) Expand(str string, args []string) ([]string, error) {
 	env := getEnvShell(s)
 	return parser.Expand(str, args, env)
@@ -274,6 +561,19 @@ func (s *Shell) Run(ctx context.Context, r io.Reader, cmd string, args []string)
 			return err
 		}
 		ret = s.execute(ctx, ex)
+		if errors.Is(ret, ErrExec) {
+			return ret
+		}
+		if errors.Is(ret, words.ErrNoMatch) || errors.Is(ret, words.ErrExpansion) {
+			fmt.Fprintln(s.stderr, ret)
+			s.context.code = 1
+			continue
+		}
+		if errors.Is(ret, words.ErrUnset) {
+			fmt.Fprintln(s.stderr, ret)
+			s.context.code = 1
+			return nil
+		}
 	}
 }
 
@@ -285,7 +585,7 @@ func (s *Shell) execute(ctx context.Context, ex words.Executer) error {
 	var err error
 	switch ex := ex.(type) {
 	case words.ExecSimple:
-		err = s.executeSingle(ctx, ex.Expander, ex.Redirect)
+		err = s.executeSingle(ctx, ex)
 	case words.ExecList:
 		for i := range ex {
 			if err = s.execute(ctx, ex[i]); err != nil {
@@ -294,6 +594,11 @@ func (s *Shell) execute(ctx context.Context, ex words.Executer) error {
 		}
 	case words.ExecSubshell:
 		return s.executeSubshell(ctx, ex)
+	case words.ExecGroup:
+		err = s.executeGroup(ctx, ex)
+	case words.ExecFunction:
+		s.functions[ex.Ident] = ex.Body
+		s.context.code = 0
 	case words.ExecAssign:
 		err = s.executeAssign(ex)
 	case words.ExecAnd:
@@ -302,14 +607,24 @@ func (s *Shell) execute(ctx context.Context, ex words.Executer) error {
 		}
 		err = s.execute(ctx, ex.Right)
 	case words.ExecOr:
-		if err = s.execute(ctx, ex.Left); err == nil || s.context.code == 0 {
+		if err = s.execute(ctx, ex.Left); err == nil && s.context.code == 0 {
 			break
 		}
 		err = s.execute(ctx, ex.Right)
 	case words.ExecPipe:
 		err = s.executePipe(ctx, ex)
+	case words.ExecNot:
+		err = s.executeNot(ctx, ex)
+	case words.ExecTime:
+		err = s.executeTime(ctx, ex)
 	case words.ExecFor:
 		err = s.executeFor(ctx, ex)
+	case words.ExecForMath:
+		err = s.executeForMath(ctx, ex)
+	case words.ExecSelect:
+		err = s.executeSelect(ctx, ex)
+	case words.ExecMath:
+		err = s.executeMath(ctx, ex)
 	case words.ExecWhile:
 		err = s.executeWhile(ctx, ex)
 	case words.ExecUntil:
@@ -343,6 +658,26 @@ func (s *Shell) executeSubshell(ctx context.Context, ex words.ExecSubshell) erro
 	return nil
 }
 
+func (s *Shell) executeGroup(ctx context.Context, ex words.ExecGroup) error {
+	rd, err := s.setupRedirect(ex.Redirect, s.stdin, s.stdout, s.stderr)
+	if err != nil {
+		return err
+	}
+	defer rd.Close()
+
+	stdin, stdout, stderr := s.stdin, s.stdout, s.stderr
+	defer func() {
+		s.stdin, s.stdout, s.stderr = stdin, stdout, stderr
+	}()
+	s.stdin, s.stdout, s.stderr = rd.in, rd.out, rd.err
+	for i := range ex.List {
+		if err := s.execute(ctx, ex.List[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (s *Shell) executeCase(ctx context.Context, ex words.ExecCase) error {
 	var (
 		env       = getEnvShell(s)
@@ -413,6 +748,143 @@ func (s *Shell) executeFor(ctx context.Context, ex words.ExecFor) error {
 	return nil
 }
 
+func (s *Shell) executeForMath(ctx context.Context, ex words.ExecForMath) error {
+	var (
+		env = getEnvShell(s)
+		it  int
+	)
+	eval := func(e words.Expr) (float64, error) {
+		if e == nil {
+			return 1, nil
+		}
+		return e.Eval(env)
+	}
+	if _, err := eval(ex.Init); err != nil {
+		return err
+	}
+	for {
+		ok, err := eval(ex.Cond)
+		if err != nil {
+			return err
+		}
+		if ok == 0 {
+			break
+		}
+		it++
+		err = s.execute(ctx, ex.Body)
+		if err != nil && !errors.Is(err, words.ErrContinue) {
+			if errors.Is(err, words.ErrBreak) {
+				break
+			}
+			return err
+		}
+		if _, err := eval(ex.Step); err != nil {
+			return err
+		}
+	}
+	if it == 0 {
+		return s.execute(ctx, ex.Alt)
+	}
+	return nil
+}
+
+const (
+	varPs3     = "PS3"
+	varReply   = "REPLY"
+	defaultPs3 = "#? "
+)
+
+func (s *Shell) executeSelect(ctx context.Context, ex words.ExecSelect) error {
+	var (
+		env       = getEnvShell(s)
+		list, err = ex.Expand(env, false)
+	)
+	if err != nil {
+		return err
+	}
+	if len(list) == 0 {
+		return s.execute(ctx, ex.Alt)
+	}
+	menu := true
+	for {
+		if menu {
+			width := len(strconv.Itoa(len(list)))
+			for i := range list {
+				fmt.Fprintf(s.stderr, "%*d) %s", width, i+1, list[i])
+				fmt.Fprintln(s.stderr)
+			}
+		}
+		prompt := defaultPs3
+		if vs, err := s.Resolve(varPs3); err == nil {
+			prompt = strings.Join(vs, " ")
+		}
+		fmt.Fprint(s.stderr, prompt)
+
+		line, err := readLine(s.stdin)
+		if err != nil {
+			fmt.Fprintln(s.stderr)
+			break
+		}
+		if err := s.Define(varReply, []string{line}); err != nil {
+			return err
+		}
+		if menu = line == ""; menu {
+			continue
+		}
+		var item string
+		if n, err := strconv.Atoi(strings.TrimSpace(line)); err == nil && n >= 1 && n <= len(list) {
+			item = list[n-1]
+		}
+		if err := s.Define(ex.Ident, []string{item}); err != nil {
+			return err
+		}
+		if err := s.execute(ctx, ex.Body); err != nil {
+			if errors.Is(err, words.ErrBreak) {
+				break
+			}
+			if errors.Is(err, words.ErrContinue) {
+				continue
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// readLine reads r one byte at a time until the end of the line so that nothing
+// following the line is consumed
+func readLine(r io.Reader) (string, error) {
+	var (
+		buf []byte
+		one = make([]byte, 1)
+	)
+	for {
+		n, err := r.Read(one)
+		if n > 0 {
+			if one[0] == '\n' {
+				return string(buf), nil
+			}
+			buf = append(buf, one[0])
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) && len(buf) > 0 {
+				return string(buf), nil
+			}
+			return "", err
+		}
+	}
+}
+
+func (s *Shell) executeMath(_ context.Context, ex words.ExecMath) error {
+	ret, err := ex.Eval(getEnvShell(s))
+	if err != nil || ret == 0 {
+		s.context.code = 1
+	} else {
+		s.context.code = 0
+	}
+	return err
+}
+
 func (s *Shell) executeWhile(ctx context.Context, ex words.ExecWhile) error {
 	var it int
 	for {
@@ -480,99 +952,270 @@ func (s *Shell) executeIf(ctx context.Context, ex words.ExecIf) error {
 	return s.execute(ctx, ex.Alt)
 }
 
-func (s *Shell) executeSingle(ctx context.Context, ex words.Expander, redirect []words.ExpandRedirect) error {
-	str, err := s.expand(ex)
+func (s *Shell) executeSingle(ctx context.Context, ex words.ExecSimple) error {
+	str, err := s.expand(ex.Expander)
 	if err != nil {
 		return err
 	}
 	s.trace(str)
-	cmd := s.resolveCommand(ctx, str)
-	cmd.SetOut(s.stdout)
-	cmd.SetErr(s.stderr)
-	cmd.SetIn(s.stdin)
-
-	// rd, err := s.setupRedirect(redirect, false)
-	// if err != nil {
-	// 	return err
-	// }
-	// defer rd.Close()
-	//
-	// cmd.SetOut(rd.out)
-	// cmd.SetErr(rd.err)
-	// cmd.SetIn(rd.in)
-
-	// err = cmd.Run()
-	cmd.Run()
-	s.updateContext(cmd)
-	return nil
-}
+	return s.withAssign(ex.Assign, func() error {
+		cmd := s.resolveCommand(ctx, str)
 
-func (s *Shell) executePipe(ctx context.Context, ex words.ExecPipe) error {
-	get := func(ex words.Executer) (Command, error) {
-		sex, ok := ex.(words.ExecSimple)
-		if !ok {
-			return nil, fmt.Errorf("single command expected")
-		}
-		str, err := s.expand(sex.Expander)
+		rd, err := s.setupRedirect(ex.Redirect, s.stdin, s.stdout, s.stderr)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		cmd := s.resolveCommand(ctx, str)
-		cmd.SetErr(s.stderr)
-		cmd.SetIn(s.stdin)
-		return cmd, nil
-	}
-	run := func(cmd Command, update bool) func() error {
-		return func() error {
-			err := cmd.Run()
-			if update {
-				s.updateContext(cmd)
-			}
+		defer s.closeRedirect(rd)
+
+		cmd.SetOut(rd.out)
+		cmd.SetErr(rd.err)
+		cmd.SetIn(rd.in)
+
+		if err = cmd.Start(); err != nil {
+			s.context.pid, s.context.code = 0, failStart(rd.err, cmd, err)
+			s.context.pipe = append(s.context.pipe[:0], s.context.code)
+			return nil
+		}
+		if err = cmd.Wait(); errors.Is(err, ErrReturn) || errors.Is(err, ErrExec) || isExpansionError(err) {
 			return err
 		}
+		s.updateContext(cmd)
+		return nil
+	})
+}
+
+// failStart reports to w that cmd could not be started and gives its exit code:
+// 127 when the command is not found, 126 otherwise
+func failStart(w io.Writer, cmd Command, err error) int {
+	code := 126
+	if errors.Is(err, exec.ErrNotFound) {
+		err = fmt.Errorf("%s: command not found", cmd.Command())
+		code = 127
+	}
+	fmt.Fprintln(w, err)
+	return code
+}
+
+// isExpansionError reports whether err is an error of the expansions that has
+// to stop the execution of the current function or script
+func isExpansionError(err error) bool {
+	return errors.Is(err, words.ErrUnset) || errors.Is(err, words.ErrNoMatch) || errors.Is(err, words.ErrExpansion)
+}
+
+// withAssign calls fn with the variables of list defined and exported in a
+// temporary scope that is discarded once fn returns
+func (s *Shell) withAssign(list []words.ExecAssign, fn func() error) error {
+	if len(list) == 0 {
+		return fn()
 	}
 	var (
-		last = len(ex.List) - 1
-		in   io.ReadCloser
-		grp  errgroup.Group
+		locals = s.locals
+		tmp    = temporaryEnv(locals)
 	)
-	cmd, err := get(ex.List[last].Executer)
-	if err != nil {
+	s.locals = tmp
+	defer func() {
+		s.locals = locals
+	}()
+	if err := s.defineAssign(tmp.Env, list); err != nil {
+		if errors.Is(err, ErrReadOnly) {
+			fmt.Fprintln(s.stderr, err)
+			s.context.code = 1
+			s.context.pipe = append(s.context.pipe[:0], s.context.code)
+			return nil
+		}
 		return err
 	}
-	cmd.SetOut(s.stdout)
-	cmd.SetErr(s.stderr)
-	for i := last - 1; i >= 0; i-- {
-		curr, err := get(ex.List[i].Executer)
+	return fn()
+}
+
+// defineAssign defines and exports in scope the variables given before the name
+// of a command
+func (s *Shell) defineAssign(scope Environment, list []words.ExecAssign) error {
+	env := getEnvShell(s)
+	for _, a := range list {
+		str, err := a.Expand(env, false)
 		if err != nil {
 			return err
 		}
-		if in, err = curr.StdoutPipe(); err != nil {
+		if err := scope.Define(a.Ident, str); err != nil {
+			return err
+		}
+		attr, _ := scope.Attributes(a.Ident)
+		if err := scope.SetAttributes(a.Ident, attr|AttrExport); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Shell) executePipe(ctx context.Context, ex words.ExecPipe) error {
+	var (
+		list    = make([]Command, len(ex.List))
+		dirs    = make([][]words.ExpandRedirect, len(ex.List))
+		closes  = make([][]io.Closer, len(ex.List))
+		stderrs = make([]io.Writer, len(ex.List))
+		last    = len(ex.List) - 1
+	)
+	defer func() {
+		for i := range closes {
+			closeAll(closes[i])
+		}
+	}()
+	for i := range ex.List {
+		cmd, rs, err := s.resolvePipe(ctx, ex.List[i].Executer)
+		if err != nil {
 			return err
 		}
-		defer in.Close()
+		list[i], dirs[i] = cmd, rs
+	}
+	var (
+		stdin  = s.stdin
+		stdout io.Writer
+		stderr io.Writer
+	)
+	for i := range list {
+		var next io.Reader
+		if i < last {
+			pr, pw, err := os.Pipe()
+			if err != nil {
+				return err
+			}
+			closes[i] = append(closes[i], pw)
+			closes[i+1] = append(closes[i+1], pr)
+			stdout, stderr, next = pw, s.stderr, pr
+			if ex.List[i].Both {
+				stderr = pw
+			}
+		} else {
+			stdout, stderr = s.stdout, s.stderr
+		}
+		rd, err := s.setupRedirect(dirs[i], stdin, stdout, stderr)
+		if err != nil {
+			return err
+		}
+		closes[i] = append(closes[i], rd)
+
+		list[i].SetIn(rd.in)
+		list[i].SetOut(rd.out)
+		list[i].SetErr(rd.err)
+		stderrs[i] = rd.err
+		stdin = next
+	}
+	var (
+		grp    errgroup.Group
+		failed = make([]int, len(list))
+	)
+	for i := range list {
+		if err := list[i].Start(); err != nil {
+			failed[i] = failStart(stderrs[i], list[i], err)
+			closeAll(closes[i])
+			continue
+		}
+		cmd, fds := list[i], closes[i]
+		grp.Go(func() error {
+			defer closeAll(fds)
+			cmd.Wait()
+			return nil
+		})
+	}
+	grp.Wait()
+
+	codes := make([]int, len(list))
+	for i := range list {
+		if failed[i] != 0 {
+			codes[i] = failed[i]
+			continue
+		}
+		_, codes[i] = list[i].Exit()
+	}
+	s.updateContext(list[last])
+	s.context.code = codes[last]
+	s.context.pipe = codes
+	return nil
+}
+
+func (s *Shell) resolvePipe(ctx context.Context, ex words.Executer) (Command, []words.ExpandRedirect, error) {
+	sub, err := s.Subshell()
+	if err != nil {
+		return nil, nil, err
+	}
+	sex, ok := ex.(words.ExecSimple)
+	if !ok {
+		return createScript(ctx, sub, ex), nil, nil
+	}
+	str, err := s.expand(sex.Expander)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.trace(str)
+	if err := sub.defineAssign(sub.locals, sex.Assign); err != nil {
+		return nil, nil, err
+	}
+	cmd := s.resolveCommand(ctx, str)
+	if len(sex.Assign) > 0 {
+		cmd = sub.resolveCommand(ctx, str)
+	}
+	if c, ok := cmd.(*shellCommand); ok {
+		c.shell = sub
+	}
+	return cmd, sex.Redirect, nil
+}
 
-		cmd.SetIn(in)
-		grp.Go(run(cmd, i == last-1))
-		cmd = curr
+func (s *Shell) executeNot(ctx context.Context, ex words.ExecNot) error {
+	if err := s.execute(ctx, ex.Executer); err != nil {
+		return err
 	}
-	cmd.SetIn(s.stdin)
-	err = cmd.Run()
-	if err1 := grp.Wait(); err1 != nil && err == nil {
-		err = err1
+	if s.context.code == 0 {
+		s.context.code = 1
+	} else {
+		s.context.code = 0
+	}
+	return nil
+}
+
+func (s *Shell) executeTime(ctx context.Context, ex words.ExecTime) error {
+	var (
+		before = getUsage()
+		now    = time.Now()
+		err    = s.execute(ctx, ex.Executer)
+		real   = time.Since(now)
+		after  = getUsage()
+	)
+	user, sys := after.user-before.user, after.sys-before.sys
+	if ex.Posix {
+		fmt.Fprintf(s.stderr, "real %.2f", real.Seconds())
+		fmt.Fprintln(s.stderr)
+		fmt.Fprintf(s.stderr, "user %.2f", user.Seconds())
+		fmt.Fprintln(s.stderr)
+		fmt.Fprintf(s.stderr, "sys %.2f", sys.Seconds())
+		fmt.Fprintln(s.stderr)
+		return err
 	}
+	fmt.Fprintln(s.stderr)
+	fmt.Fprintf(s.stderr, "real\t%s", formatDuration(real))
+	fmt.Fprintln(s.stderr)
+	fmt.Fprintf(s.stderr, "user\t%s", formatDuration(user))
+	fmt.Fprintln(s.stderr)
+	fmt.Fprintf(s.stderr, "sys\t%s", formatDuration(sys))
+	fmt.Fprintln(s.stderr)
 	return err
 }
 
 func (s *Shell) executeAssign(ex words.ExecAssign) error {
 	var (
 		env      = getEnvShell(s)
-		str, err = ex.Expand(env, true)
+		str, err = ex.Expand(env, false)
 	)
 	if err != nil {
 		return err
 	}
-	return s.Define(ex.Ident, str)
+	if err = s.Define(ex.Ident, str); errors.Is(err, ErrReadOnly) {
+		fmt.Fprintln(s.stderr, err)
+		s.context.code = 1
+		s.context.pipe = append(s.context.pipe[:0], s.context.code)
+		return nil
+	}
+	return err
 }
 
 func (s *Shell) expand(ex words.Expander) ([]string, error) {
@@ -609,6 +1252,7 @@ func (s *Shell) updateContext(cmd Command) {
 	}
 	s.context.pid = pid
 	s.context.code = code
+	s.context.pipe = append(s.context.pipe[:0], code)
 }
 
 func (s *Shell) clearContext() {
@@ -617,8 +1261,18 @@ func (s *Shell) clearContext() {
 }
 
 func (s *Shell) resolveCommand(ctx context.Context, str []string) Command {
+	if fn, ok := s.functions[str[0]]; ok {
+		return createFunction(ctx, s, str[0], str[1:], fn)
+	}
+	return s.findCommand(ctx, str)
+}
+
+// findCommand resolves the builtin or the external command of str without
+// looking at the functions of the shell
+func (s *Shell) findCommand(ctx context.Context, str []string) Command {
 	if b, ok := s.builtins[str[0]]; ok && b.IsEnabled() {
 		b.shell = s
+		b.ctx = ctx
 		b.Args = str[1:]
 		return &b
 	}
@@ -638,6 +1292,85 @@ func (s *Shell) resolveCommand(ctx context.Context, str []string) Command {
 	return cmd
 }
 
+const (
+	varPath = "PATH"
+	// directories searched by command -p
+	defaultPath = "/usr/bin:/bin"
+)
+
+// lookPath searches the executable file name in the directories of path or,
+// when path is empty, in the directories of the PATH variable of the shell
+func (s *Shell) lookPath(name, path string) (string, error) {
+	var (
+		fsys = words.WorkingFS(s)
+		dirs []string
+	)
+	if strings.Contains(name, "/") {
+		dirs = append(dirs, "")
+	} else if path != "" {
+		dirs = filepath.SplitList(path)
+	} else if list, err := s.Resolve(varPath); err == nil {
+		dirs = filepath.SplitList(strings.Join(list, ":"))
+	}
+	for _, d := range dirs {
+		file := name
+		if d != "" {
+			file = filepath.Join(d, name)
+		}
+		i, err := fsys.Stat(file)
+		if err == nil && i.Mode().IsRegular() && i.Mode().Perm()&0111 != 0 {
+			return file, nil
+		}
+	}
+	return "", fmt.Errorf("%s: not found", name)
+}
+
+// rebind makes the standard streams given to exec the standard streams of the
+// shell. Files previously bound by exec and no longer used are closed
+func (s *Shell) rebind(stdin io.Reader, stdout, stderr io.Writer) {
+	var (
+		files []File
+		curr  = []interface{}{s.stdin, s.stdout, s.stderr}
+	)
+	for i, c := range []interface{}{stdin, stdout, stderr} {
+		f, ok := c.(File)
+		if !ok || (c == curr[i] && !s.isBound(f)) || hasFile(files, f) {
+			continue
+		}
+		files = append(files, f)
+	}
+	for _, f := range s.files {
+		if !hasFile(files, f) {
+			f.Close()
+		}
+	}
+	s.stdin, s.stdout, s.stderr = stdin, stdout, stderr
+	s.files = files
+}
+
+// closeRedirect closes the files opened for the redirections of rd except the
+// files bound to the standard streams of the shell by exec
+func (s *Shell) closeRedirect(rd redirect) {
+	for _, f := range rd.files {
+		if !s.isBound(f) {
+			f.Close()
+		}
+	}
+}
+
+func (s *Shell) isBound(f File) bool {
+	return hasFile(s.files, f)
+}
+
+func hasFile(files []File, f File) bool {
+	for i := range files {
+		if files[i] == f {
+			return true
+		}
+	}
+	return false
+}
+
 func (s *Shell) resolveSpecials(ident string) []string {
 	var ret []string
 	switch ident {
@@ -656,7 +1389,9 @@ func (s *Shell) resolveSpecials(ident string) []string {
 	case varPwd:
 		ret = append(ret, s.Cwd())
 	case varOld:
-		// ret = append(ret, s.old)
+		if s.old != "" {
+			ret = append(ret, s.old)
+		}
 	case varPid, varShellPid:
 		str := strconv.Itoa(os.Getpid())
 		ret = append(ret, str)
@@ -678,6 +1413,10 @@ func (s *Shell) resolveSpecials(ident string) []string {
 		ret = append(ret, strings.Join(s.context.args, " "))
 	case varArgsArr:
 		ret = append(ret, s.context.args...)
+	case varPipe:
+		for _, c := range s.context.pipe {
+			ret = append(ret, strconv.Itoa(c))
+		}
 	default:
 		n, err := strconv.Atoi(ident)
 		if err != nil {
@@ -701,128 +1440,103 @@ func (s *Shell) trace(str []string) {
 
 func (s *Shell) environ() []string {
 	var str []string
-	for n, v := range s.env {
-		str = append(str, fmt.Sprintf("%s=%s", n, v))
+	for _, n := range s.Names() {
+		v, ok := s.listed(n)
+		if !ok || v.values == nil || v.attrs&AttrExport == 0 {
+			continue
+		}
+		str = append(str, fmt.Sprintf("%s=%s", n, strings.Join(v.values, " ")))
 	}
 	return str
 }
 
-func (s *Shell) setupRedirect(rs []words.ExpandRedirect, pipe bool) (redirect, error) {
+func (s *Shell) setupRedirect(rs []words.ExpandRedirect, stdin io.Reader, stdout, stderr io.Writer) (redirect, error) {
+	rd := redirect{
+		in:  stdin,
+		out: stdout,
+		err: stderr,
+	}
 	var (
-		stdin  *os.File
-		stdout *os.File
-		stderr *os.File
-		rd     redirect
-		env    = getEnvShell(s)
+		env  = getEnvShell(s)
+		fsys = words.WorkingFS(s)
 	)
 	for _, r := range rs {
 		str, err := r.Expand(env, true)
 		if err != nil {
+			rd.Close()
 			return rd, err
 		}
-		switch file := str[0]; r.Type {
+		var (
+			file = str[0]
+			fd   File
+		)
+		switch r.Type {
 		case token.RedirectIn:
-			stdin, err = replaceFile(file, flagRead, stdin)
+			if fd, err = openFile(fsys, file, flagRead); err == nil {
+				rd.in = fd
+			}
 		case token.RedirectOut:
-			if stdout == stderr {
-				stdout = nil
+			if fd, err = openFile(fsys, file, flagWrite); err == nil {
+				rd.out = fd
 			}
-			stdout, err = replaceFile(file, flagWrite, stdout)
 		case token.RedirectErr:
-			if stderr == stdout {
-				stderr = nil
+			if fd, err = openFile(fsys, file, flagWrite); err == nil {
+				rd.err = fd
 			}
-			stderr, err = replaceFile(file, flagWrite, stderr)
 		case token.RedirectBoth:
-			var fd *os.File
-			if fd, err = replaceFile(file, flagWrite, stdout, stderr); err == nil {
-				stdout, stderr = fd, fd
+			if fd, err = openFile(fsys, file, flagWrite); err == nil {
+				rd.out, rd.err = fd, fd
 			}
 		case token.AppendOut:
-			if stdout.Fd() == stderr.Fd() {
-				stdout = nil
+			if fd, err = openFile(fsys, file, flagAppend); err == nil {
+				rd.out = fd
 			}
-			stdout, err = replaceFile(file, flagAppend, stdout)
 		case token.AppendErr:
-			if stderr == stdout {
-				stderr = nil
+			if fd, err = openFile(fsys, file, flagAppend); err == nil {
+				rd.err = fd
 			}
-			stderr, err = replaceFile(file, flagAppend, stderr)
 		case token.AppendBoth:
-			var fd *os.File
-			if fd, err = replaceFile(file, flagAppend, stdout, stderr); err == nil {
-				stdout, stderr = fd, fd
+			if fd, err = openFile(fsys, file, flagAppend); err == nil {
+				rd.out, rd.err = fd, fd
 			}
 		default:
 			err = fmt.Errorf("unknown/unsupported redirection")
 		}
 		if err != nil {
+			rd.Close()
 			return rd, err
 		}
+		rd.files = append(rd.files, fd)
 	}
-	rd.in = fileOrReader(stdin, s.stdin, pipe)
-	rd.out = fileOrWriter(stdout, s.stdout, pipe)
-	rd.err = fileOrWriter(stderr, s.stderr, pipe)
 	return rd, nil
 }
 
 const (
-	flagRead   = os.O_CREATE | os.O_RDONLY
-	flagWrite  = os.O_CREATE | os.O_WRONLY
+	flagRead   = os.O_RDONLY
+	flagWrite  = os.O_CREATE | os.O_WRONLY | os.O_TRUNC
 	flagAppend = os.O_CREATE | os.O_WRONLY | os.O_APPEND
 )
 
-func replaceFile(file string, flag int, list ...*os.File) (*os.File, error) {
-	fd, err := os.OpenFile(file, flag, 0644)
-	if err != nil {
-		return nil, err
-	}
-	for i := range list {
-		if list[i] == nil {
-			continue
-		}
-		list[i].Close()
-	}
-	return fd, nil
-}
-
-func fileOrWriter(f *os.File, w io.Writer, pipe bool) io.Writer {
-	if f == nil {
-		if pipe {
-			return nil // w
-		}
-		return stdio.Writer(w)
-	}
-	return stdio.Writer(w)
+func openFile(fsys FileSystem, file string, flag int) (File, error) {
+	return fsys.OpenFile(file, flag, 0644)
 }
 
-func fileOrReader(f *os.File, r io.Reader, pipe bool) io.ReadCloser {
-	if f == nil {
-		if pipe {
-			return nil // stdio.Reader(r)
-		}
-		if r == nil {
-			r = rw.Empty()
-		}
-		return stdio.Reader(r)
+func closeAll(list []io.Closer) {
+	for i := range list {
+		list[i].Close()
 	}
-	return stdio.Reader(f)
 }
 
 type redirect struct {
-	in  io.Reader
-	out io.Writer
-	err io.Writer
+	in    io.Reader
+	out   io.Writer
+	err   io.Writer
+	files []File
 }
 
 func (r redirect) Close() error {
-	for _, c := range []interface{}{r.in, r.out, r.err} {
-		if c == nil {
-			continue
-		}
-		if c, ok := c.(io.Closer); ok {
-			c.Close()
-		}
+	for i := range r.files {
+		r.files[i].Close()
 	}
 	return nil
 }
@@ -3,10 +3,14 @@ package tish_test
 import (
 	"bytes"
 	"context"
-	"fmt"
+	"errors"
 	"io"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/midbel/tish"
 )
@@ -40,6 +44,324 @@ func TestShellBis(t *testing.T) {
 			Script: `FOO=foobar; echo ${FOO} | cut -f 1 -d 'b'`,
 			Out:    []string{"foo"},
 		},
+		{
+			Script: `greet() { echo "hello $1"; }; greet world`,
+			Out:    []string{"hello world"},
+		},
+		{
+			Script: `{ echo "foobar"; } | cut -f 1 -d 'b'`,
+			Out:    []string{"foo"},
+		},
+		{
+			Script: `declare -i x=1+2; echo $x`,
+			Out:    []string{"3"},
+		},
+		{
+			Script: `declare -u x=foobar; echo $x`,
+			Out:    []string{"FOOBAR"},
+		},
+		{
+			Script: `foo=foobar; declare -n ref=foo; echo $ref`,
+			Out:    []string{"foobar"},
+		},
+		{
+			Script: `readonly foo=foobar; foo=bar; echo $foo`,
+			Out:    []string{"foobar"},
+			Err:    []string{"foo: read only"},
+		},
+		{
+			Script: `x=1; x=2 echo $x; echo $x`,
+			Out:    []string{"1", "1"},
+		},
+		{
+			Script: `FOO=bar sh -c 'echo $FOO'; FOO=foo BAR=$FOO sh -c 'echo $BAR' | cat`,
+			Out:    []string{"bar", "foo"},
+		},
+		{
+			Script: `show() { echo $FOO; }; FOO=bar show`,
+			Out:    []string{"bar"},
+		},
+		{
+			Script: `env -i foo=1 bar=2; env -i -0 foo=1`,
+			Out:    []string{"bar=2", "foo=1", "foo=1\x00"},
+		},
+		{
+			Script: `export foo=1 bar=2; env -u foo env | grep -c foo=; env -i foo=3 env`,
+			Out:    []string{"0", "foo=3"},
+		},
+		{
+			Script: `export e=1; e=5; sh -c 'echo $e'; echo ${e@A}; export -d e; sh -c 'echo "[$e]"'; export u; env | grep -c ^u=; declare -p u`,
+			Out:    []string{"5", "declare -x e='5'", "[]", "0", "declare -x u"},
+		},
+		{
+			Script: `set -- a b; x=$@; readonly x; declare -p x`,
+			Out:    []string{"declare -r x='a b'"},
+		},
+		{
+			Script: `f() { echo "$foo-$bar"; }; bar=2; env -i foo=1 f; env false; echo $?`,
+			Out:    []string{"1-", "1"},
+		},
+		{
+			Script: `env sh -c 'exit 200'; echo $?; env sh -c 'kill -TERM $$'; echo $?`,
+			Out:    []string{"200", "143"},
+		},
+		{
+			Script: `eval 'foo=bar; echo $foo'; echo $foo; eval false; echo $?`,
+			Out:    []string{"bar", "bar", "1"},
+		},
+		{
+			Script: `command sh -c 'exit 200'; echo $?; eval 'sh -c "exit 201"'; echo $?`,
+			Out:    []string{"200", "201"},
+		},
+		{
+			Script: `echo() { printf "func\n"; }; echo foo; command echo bar; command -v echo cd`,
+			Out:    []string{"func", "bar", "echo", "cd"},
+		},
+		{
+			Script: `f() { :; }; alias ll='ls -l'; command -V f cd ll; command -v foobar; echo $?`,
+			Out:    []string{"f is a function", "cd is a shell builtin", "ll is aliased to `ls -l'", "1"},
+		},
+		{
+			Script: `set -- a b c d; shift; echo $1 $#; shift 2; echo $@; shift 5; echo $?`,
+			Out:    []string{"b 3", "d", "1"},
+			Err:    []string{"shift: 5: shift count out of range"},
+		},
+		{
+			Script: `set -- a b c d e f g h i j k; echo ${10} ${11} $10`,
+			Out:    []string{"j k a0"},
+		},
+		{
+			Script: `set -- -ac -bfoo -b bar -x -- x y; while getopts :ab:c opt; do echo "$opt $OPTARG $OPTIND"; done; shift $((OPTIND-1)); echo $@`,
+			Out:    []string{"a  1", "c  2", "b foo 3", "b bar 5", "? x 6", "x y"},
+		},
+		{
+			Script: `getopts b: opt -b; echo $? $opt; OPTIND=1; getopts :b: opt -b; echo $? $opt $OPTARG`,
+			Out:    []string{"0 ?", "0 : b"},
+			Err:    []string{"getopts: option requires an argument -- b"},
+		},
+		{
+			Script: `OPTERR=0; set -- -x -b; while getopts ab: opt; do echo "$opt ${OPTARG-unset}"; done`,
+			Out:    []string{"? unset", "? unset"},
+		},
+		{
+			Script: `echo $'a\tb\x41\u00e9\'' '\t'; echo -n foo; echo -e 'bar\n\0101\c' baz; echo -E 'x\ty'`,
+			Out:    []string{"a\tbA\u00e9' \\t", "foobar", "Ax\\ty"},
+		},
+		{
+			Script: "echo foo\\\nbar a#b # comment \\\necho \"c\\\nd\" 'e\\\nf'",
+			Out:    []string{"foobar a#b", "cd e\\", "f"},
+		},
+		{
+			Script: `x=1; echo "\$x" "a\"b" "c\d" $x# "#"`,
+			Out:    []string{`$x a"b c\d 1# #`},
+		},
+		{
+			Script: `echo -3 -nx - -en; echo -- -n`,
+			Out:    []string{"-3 -nx - -en", "-- -n"},
+		},
+		{
+			Script: `shopt -s extglob nullglob; shopt extglob; shopt -p nullglob dotglob; echo $?; shopt -u nullglob; shopt -q nullglob; echo $?`,
+			Out:    []string{"extglob        \ton", "shopt -s nullglob", "shopt -u dotglob", "1", "1"},
+		},
+		{
+			Script: `shopt -s foo; echo $?; shopt -su extglob; echo $?`,
+			Out:    []string{"1", "1"},
+			Err:    []string{"shopt: foo: invalid shell option name", "shopt: cannot set and unset shell options simultaneously"},
+		},
+		{
+			Script: `shopt -s nullglob; echo x testdata/*.xyz y; shopt -s failglob; echo testdata/*.xyz; echo $?`,
+			Out:    []string{"x y", "1"},
+			Err:    []string{"no match: testdata/*.xyz"},
+		},
+		{
+			Script: `nosuchcmd | cat; echo $PIPESTATUS $?; echo x | nosuchcmd; echo $PIPESTATUS $?`,
+			Out:    []string{"127 0 0", "0 127 127"},
+			Err:    []string{"nosuchcmd: command not found", "nosuchcmd: command not found"},
+		},
+		{
+			Script: `x="a b"; select f in ${!x}; do echo $f; else echo alt; done; echo $?; select f in; do echo $f; else echo alt; done`,
+			Out:    []string{"1", "alt"},
+			Err:    []string{"a b: invalid variable name"},
+		},
+		{
+			Script: `f=/a/b.tar.gz; echo "${f%.*}" "${f##*/}" "${f%%.*}"; echo "${f%".gz"}" "${f%"*"}"; p="*.gz"; echo "${f%$p}" "${f%"$p"}"`,
+			Out:    []string{"/a/b.tar b.tar.gz /a/b", "/a/b.tar /a/b.tar.gz", "/a/b.tar /a/b.tar.gz"},
+		},
+		{
+			Script: `s="a*b"; echo "${s/[*]/-}" "${s/"*"/+}" "${s//?/x}"`,
+			Out:    []string{"a-b a+b xxx"},
+		},
+		{
+			Script: `env -i /usr/bin/env; env -i sh -c 'env | grep -v ^PWD= | wc -l'`,
+			Out:    []string{"0"},
+		},
+		{
+			Script: "f() { echo ${X:?need X}; echo inside; }\nf\necho \"after $?\"",
+			Err:    []string{"X: need X"},
+		},
+		{
+			Script: "f() { x='a b'; echo ${!x}; echo inside; }\nf\necho \"after $?\"",
+			Out:    []string{"after 1"},
+			Err:    []string{"a b: invalid variable name"},
+		},
+		{
+			Script: `echo = x; [ a = a ] && echo yes; [ = ] && echo one; x=1 y= sh -c 'echo "$x[$y]"'`,
+			Out:    []string{"= x", "yes", "one", "1[]"},
+		},
+		{
+			Script: `nosuchcmd; echo $?; nosuchcmd 2>/dev/null; echo $?`,
+			Out:    []string{"127", "127"},
+			Err:    []string{"nosuchcmd: command not found"},
+		},
+		{
+			Script: `! false; echo $?; ! true; echo $?; ! echo foo | grep -q bar; echo $?; ! nosuchcmd 2>/dev/null && echo negated`,
+			Out:    []string{"0", "1", "0", "negated"},
+		},
+		{
+			Script: `echo ! time; for w in time !; do echo $w; done`,
+			Out:    []string{"! time", "time", "!"},
+		},
+		{
+			Script: `return 3; echo "after $?"; f() { return 4; echo no; }; f; echo $?; echo function; function h { echo h; }; h`,
+			Out:    []string{"after 1", "4", "function", "h"},
+			Err:    []string{"return: can only return from a function"},
+		},
+		{
+			Script: `echo select function; for w in select; do echo $w; done; f() { select x in a; do break; done; }; echo ok`,
+			Out:    []string{"select function", "select", "ok"},
+		},
+		{
+			Script: `[[ foo.go == @(foo|bar).go ]] && echo ok; [[ x1 == [[:alpha:]][[:digit:]] ]] && echo ok`,
+			Out:    []string{"ok", "ok"},
+		},
+		{
+			Script: `for ((i=0; i<3; i++)); do echo $i; done; for ((;i<3;)); do echo never; else echo zero; done`,
+			Out:    []string{"0", "1", "2", "zero"},
+		},
+		{
+			Script: `for ((i=0; ; i++)); do (( i % 2 )) || continue; (( i > 4 )) && break; echo $i; done`,
+			Out:    []string{"1", "3"},
+		},
+		{
+			Script: `x=1; (( x > 0 )) && echo ok; (( x - 1 )); echo $?; (( y = x + 1 )); echo $y`,
+			Out:    []string{"ok", "1", "2"},
+		},
+		{
+			Script: `let x=2 y=x*3; echo $? $x $y; let y=0; echo $?`,
+			Out:    []string{"0 2 6", "1"},
+		},
+		{
+			Script: `x=1; ((x+=2)); echo $x; (( a=1, b=2 )); echo $a $b; echo $((x*=2)) $((x<<=1)) $((x-=2)) $x; let "y=1, y|=4"; echo $y $((1, 2))`,
+			Out:    []string{"3", "1 2", "6 12 10 10", "5 2"},
+		},
+		{
+			Script: `for ((i=0, j=5; i<2; i++, j--)); do echo $i $j; done`,
+			Out:    []string{"0 5", "1 4"},
+		},
+		{
+			Script: `echo foo $undefined bar`,
+			Out:    []string{"foo bar"},
+		},
+		{
+			Script: `x=1; unset x; echo "[${x-unset}]"; y=; echo "[${y-unset}]"`,
+			Out:    []string{"[unset]", "[]"},
+		},
+		{
+			Script: `x=; [[ -v x ]] && echo set; unset -v x; [[ -v x ]] || echo unset`,
+			Out:    []string{"set", "unset"},
+		},
+		{
+			Script: `f() { echo foo; }; f=bar; unset f; f; unset -f f; f`,
+			Out:    []string{"foo"},
+			Err:    []string{"f: command not found"},
+		},
+		{
+			Script: `x=1; ( unset x; echo "[$x]"; x=3; echo $x ); echo $x`,
+			Out:    []string{"[]", "3", "1"},
+		},
+		{
+			Script: `x=1; f() { unset x; echo "[${x-unset}]"; }; x=2 f; echo $x`,
+			Out:    []string{"[unset]", "1"},
+		},
+		{
+			Script: `readonly x=1; unset x; echo $?`,
+			Out:    []string{"1"},
+			Err:    []string{"unset: x: read only"},
+		},
+		{
+			Script: `set -u; echo ${x-foo}; echo $x; echo bar`,
+			Out:    []string{"foo"},
+			Err:    []string{"x: unbound variable"},
+		},
+		{
+			Script: `[ -n foobar ] && echo ok`,
+			Out:    []string{"ok"},
+		},
+		{
+			Script: `test foo = bar || echo ko; test foo != bar && echo ok`,
+			Out:    []string{"ko", "ok"},
+		},
+		{
+			Script: `test 1 -lt 2 -a ! -d testdata/nofile && echo ok`,
+			Out:    []string{"ok"},
+		},
+		{
+			Script: `[ \( foo -o "" \) -a ! "" ] && echo ok`,
+			Out:    []string{"ok"},
+		},
+		{
+			Script: `[ foo = bar; echo $?`,
+			Out:    []string{"2"},
+			Err:    []string{"[: missing ']'"},
+		},
+		{
+			Script: `test 1 -lt; echo $?`,
+			Out:    []string{"2"},
+			Err:    []string{"test: 1: unary operator expected"},
+		},
+		{
+			Script: "s=\"it's\"; echo ${s@Q}; eval \"t=${s@Q}\"; echo $t; echo a'b  c'd",
+			Out:    []string{`'it'\''s'`, "it's", "ab  cd"},
+		},
+		{
+			Script: `s='a\tb'; echo "${s@E}" "${s@U}" ${s@u}; s=FOO; echo ${s@L}`,
+			Out:    []string{"a\tb A\\TB A\\tb", "foo"},
+		},
+		{
+			Script: `e=; echo ${e@Q} ${e@A}; declare -r e; echo ${e@A}; declare -p e`,
+			Out:    []string{"'' e=''", "declare -r e=''", "declare -r e=''"},
+		},
+		{
+			Script: `declare -rx x=1; y=foo; echo ${x@A}; echo ${y@A}; echo "${z@A}" end`,
+			Out:    []string{"declare -rx x='1'", "y='foo'", "end"},
+		},
+		{
+			Script: `TARGET_linux=l; TARGET_darwin=d; os=linux; t=TARGET_$os; echo ${!t}; echo ${!TARGET_*}`,
+			Out:    []string{"l", "TARGET_darwin TARGET_linux"},
+		},
+		{
+			Script: `a='bad name'; echo ${!a}; echo ${!unknown}; echo $?`,
+			Out:    []string{"1"},
+			Err:    []string{"bad name: invalid variable name", "unknown: invalid indirect expansion"},
+		},
+		{
+			Script: `a=b; b=hello; echo ${!a#?} ${!a@Q} ${!a^^} ${!a:1:2} ${!a/l/L}; unset b; echo ${!a:-def} ${!a-undef}; echo ${!a:=new} $b`,
+			Out:    []string{"ello 'hello' HELLO el heLlo", "def undef", "new new"},
+		},
+		{
+			Script: `n=1; echo ${!#} ${!n}`,
+			Out:    []string{"baz foo bar"},
+			Args:   []string{"foo bar", "baz"},
+		},
+		{
+			Script: `os=linux; declare -n ref=os; ref=darwin; echo $os ${!ref}`,
+			Out:    []string{"darwin os"},
+		},
+		{
+			Script: `echo "${@@Q}"; echo "${@@A}"`,
+			Out:    []string{"'foo bar' 'baz'", "set -- 'foo bar' 'baz'"},
+			Args:   []string{"foo bar", "baz"},
+		},
 	}
 	for _, d := range data {
 		t.Run(d.Script, func(t *testing.T) {
@@ -51,11 +373,15 @@ func TestShellBis(t *testing.T) {
 			if err := sh.Execute(context.TODO(), d.Script, "test", d.Args); err != nil {
 				t.Fatalf("error while executing script: %s", err)
 			}
+			pair.Check(t)
 		})
 	}
 }
 
 func TestShell(t *testing.T) {
+	if err := os.MkdirAll("testdata", 0755); err != nil {
+		t.Fatalf("fail to create testdata directory: %s", err)
+	}
 	var (
 		sio     stdio
 		sh, err = createShell(&sio.Out, &sio.Err)
@@ -83,7 +409,7 @@ func TestShell(t *testing.T) {
 	t.Run("assign", func(t *testing.T) {
 		defer sio.Reset()
 
-		executeScript(t, sh, "foobar = foobar; echo ${foobar} | cut -f 1 -d 'b'", &sio)
+		executeScript(t, sh, "foobar=foobar; echo ${foobar} | cut -f 1 -d 'b'", &sio)
 	})
 	t.Run("conditional", func(t *testing.T) {
 		defer sio.Reset()
@@ -127,6 +453,348 @@ func executeScript(t *testing.T, sh *tish.Shell, script string, sio *stdio) {
 	}
 }
 
+func TestShellCwd(t *testing.T) {
+	dir := t.TempDir()
+	for _, f := range []string{"foo.txt", "bar.txt", "sub/baz.txt"} {
+		f = filepath.Join(dir, f)
+		if err := os.MkdirAll(filepath.Dir(f), 0755); err != nil {
+			t.Fatalf("fail to create directory: %s", err)
+		}
+		if err := os.WriteFile(f, nil, 0644); err != nil {
+			t.Fatalf("fail to create file: %s", err)
+		}
+	}
+	var sio stdio
+	sh, err := tish.New(tish.WithStdout(&sio.Out), tish.WithStderr(&sio.Err), tish.WithCwd(dir))
+	if err != nil {
+		t.Fatalf("fail to create shell: %s", err)
+	}
+	script := `echo *.txt; [ -f foo.txt ] && echo ok; echo foobar > out.txt; cd sub; echo *; cd ..; echo */*.txt`
+	if err := sh.Execute(context.TODO(), script, "test", nil); err != nil {
+		t.Fatalf("error while executing script: %s", err)
+	}
+	want := "bar.txt foo.txt\nok\nbaz.txt\nsub/baz.txt\n"
+	if got := sio.Out.String(); got != want {
+		t.Errorf("output mismatched! want %q, got %q", want, got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "out.txt")); err != nil {
+		t.Errorf("redirection not relative to shell directory: %s", err)
+	}
+}
+
+func TestShellChdir(t *testing.T) {
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatalf("fail to resolve temp directory: %s", err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "a", "b"), 0755); err != nil {
+		t.Fatalf("fail to create directory: %s", err)
+	}
+	if err := os.Symlink(filepath.Join(dir, "a", "b"), filepath.Join(dir, "l")); err != nil {
+		t.Fatalf("fail to create symlink: %s", err)
+	}
+	data := []struct {
+		Script string
+		Out    string
+	}{
+		{
+			Script: `cd a; pwd; cd b; echo $PWD`,
+			Out:    "{dir}/a\n{dir}/a/b\n",
+		},
+		{
+			Script: `cd a/b; cd -; echo $OLDPWD`,
+			Out:    "{dir}\n{dir}/a/b\n",
+		},
+		{
+			Script: `cd l; pwd; cd ..; pwd`,
+			Out:    "{dir}/l\n{dir}\n",
+		},
+		{
+			Script: `cd l; cd -P ..; pwd; cd -P ../l; pwd`,
+			Out:    "{dir}/a\n{dir}/a/b\n",
+		},
+		{
+			Script: `CDPATH=:{dir}/a; cd b; cd {dir}; cd a; pwd`,
+			Out:    "{dir}/a/b\n{dir}/a\n",
+		},
+		{
+			Script: `pushd a; pushd b; pushd; pushd +2; popd; dirs -v`,
+			Out:    "{dir}/a {dir}\n{dir}/a/b {dir}/a {dir}\n{dir}/a {dir}/a/b {dir}\n{dir} {dir}/a {dir}/a/b\n{dir}/a {dir}/a/b\n 0  {dir}/a\n 1  {dir}/a/b\n",
+		},
+		{
+			Script: `pushd -n a; pushd a/b; popd -1; echo ~0 ~1 ~-0; popd -n; dirs -c; dirs`,
+			Out:    "{dir} {dir}/a\n{dir}/a/b {dir} {dir}/a\n{dir}/a/b {dir}/a\n{dir}/a/b {dir}/a {dir}/a\n{dir}/a/b\n{dir}/a/b\n",
+		},
+	}
+	for _, d := range data {
+		script := strings.ReplaceAll(d.Script, "{dir}", dir)
+		t.Run(script, func(t *testing.T) {
+			var sio stdio
+			sh, err := tish.New(tish.WithStdout(&sio.Out), tish.WithStderr(&sio.Err), tish.WithCwd(dir))
+			if err != nil {
+				t.Fatalf("fail to create shell: %s", err)
+			}
+			if err := sh.Execute(context.TODO(), script, "test", nil); err != nil {
+				t.Fatalf("error while executing script: %s", err)
+			}
+			want := strings.ReplaceAll(d.Out, "{dir}", dir)
+			if got := sio.Out.String(); got != want {
+				t.Errorf("output mismatched! want %q, got %q (%s)", want, got, sio.Err.String())
+			}
+		})
+	}
+}
+
+func TestShellUnsetIndex(t *testing.T) {
+	var sio stdio
+	sh, err := tish.New(tish.WithStdout(&sio.Out), tish.WithStderr(&sio.Err), tish.WithVar("arr", "foo", "bar", "baz", "qux"))
+	if err != nil {
+		t.Fatalf("fail to create shell: %s", err)
+	}
+	if err := sh.Execute(context.TODO(), `unset arr[1]; echo $arr; unset arr[-1]; echo $arr`, "test", nil); err != nil {
+		t.Fatalf("error while executing script: %s", err)
+	}
+	want := "foo baz qux\nfoo baz\n"
+	if got := sio.Out.String(); got != want {
+		t.Errorf("output mismatched! want %q, got %q", want, got)
+	}
+}
+
+func TestShellExec(t *testing.T) {
+	dir := t.TempDir()
+	var sio stdio
+	sh, err := tish.New(tish.WithStdout(&sio.Out), tish.WithStderr(&sio.Err), tish.WithCwd(dir))
+	if err != nil {
+		t.Fatalf("fail to create shell: %s", err)
+	}
+	script := `echo foo; exec >out.txt; echo bar; exec 2>err.txt; cd nowhere; exec >>out.txt; echo baz`
+	if err := sh.Execute(context.TODO(), script, "test", nil); err != nil {
+		t.Fatalf("error while executing script: %s", err)
+	}
+	if got := sio.Out.String(); got != "foo\n" {
+		t.Errorf("output not redirected! got %q", got)
+	}
+	for f, want := range map[string]string{"out.txt": "bar\nbaz\n", "err.txt": "cd: nowhere: no such file or directory\n"} {
+		got, err := os.ReadFile(filepath.Join(dir, f))
+		if err != nil {
+			t.Fatalf("fail to read %s: %s", f, err)
+		}
+		if string(got) != want {
+			t.Errorf("%s: content mismatched! want %q, got %q", f, want, got)
+		}
+	}
+
+	sh, err = tish.New(tish.WithStdout(&sio.Out), tish.WithStderr(&sio.Err))
+	if err != nil {
+		t.Fatalf("fail to create shell: %s", err)
+	}
+	err = sh.Execute(context.TODO(), `exec true foo; echo bar`, "test", nil)
+	var ex tish.ExecError
+	if !errors.As(err, &ex) {
+		t.Fatalf("exec error expected! got %v", err)
+	}
+	if filepath.Base(ex.Path) != "true" || len(ex.Args) != 2 || ex.Args[1] != "foo" {
+		t.Errorf("unexpected command to exec: %s %s", ex.Path, ex.Args)
+	}
+
+	var (
+		args    []string
+		streams []interface{}
+	)
+	exec := func(_ string, argv, _ []string, stdin io.Reader, stdout, stderr io.Writer) error {
+		args = argv
+		streams = []interface{}{stdin, stdout, stderr}
+		return nil
+	}
+	sh, err = tish.New(tish.WithExec(exec), tish.WithCwd(dir))
+	if err != nil {
+		t.Fatalf("fail to create shell: %s", err)
+	}
+	if err := sh.Execute(context.TODO(), `exec true foo >cmd.txt`, "test", nil); err != nil {
+		t.Fatalf("error while executing script: %s", err)
+	}
+	if len(args) != 2 || args[0] != "true" {
+		t.Errorf("exec function not called with command! got %s", args)
+	}
+	checkStreams(t, streams, "", "cmd.txt", "")
+
+	sh, err = tish.New(tish.WithExec(exec), tish.WithCwd(dir))
+	if err != nil {
+		t.Fatalf("fail to create shell: %s", err)
+	}
+	if err := sh.Execute(context.TODO(), `exec <out.txt 2>err.txt; exec true`, "test", nil); err != nil {
+		t.Fatalf("error while executing script: %s", err)
+	}
+	checkStreams(t, streams, "out.txt", "", "err.txt")
+}
+
+// checkStreams checks the names of the files given as standard streams to exec.
+// An empty name means that the stream should not be a file
+func checkStreams(t *testing.T, streams []interface{}, names ...string) {
+	t.Helper()
+	if len(streams) != len(names) {
+		t.Fatalf("exec function not called with streams! got %v", streams)
+	}
+	for i := range names {
+		f, ok := streams[i].(interface{ Name() string })
+		if names[i] == "" {
+			if ok {
+				t.Errorf("stream %d: unexpected file %s", i, f.Name())
+			}
+			continue
+		}
+		if !ok || filepath.Base(f.Name()) != names[i] {
+			t.Errorf("stream %d: expected file %s! got %v", i, names[i], streams[i])
+		}
+	}
+}
+
+func TestShellSelect(t *testing.T) {
+	var (
+		sio stdio
+		in  = strings.NewReader("2\n\n9\n1\nfoo\n")
+	)
+	sh, err := tish.New(tish.WithStdout(&sio.Out), tish.WithStderr(&sio.Err), tish.WithStdin(in))
+	if err != nil {
+		t.Fatalf("fail to create shell: %s", err)
+	}
+	script := `PS3="> "; select f in foo bar; do echo "$REPLY $f"; [[ $f == foo ]] && break; done`
+	if err := sh.Execute(context.TODO(), script, "test", nil); err != nil {
+		t.Fatalf("error while executing script: %s", err)
+	}
+	var (
+		wantOut = "2 bar\n9 \n1 foo\n"
+		wantErr = "1) foo\n2) bar\n> > 1) foo\n2) bar\n> > "
+	)
+	if got := sio.Out.String(); got != wantOut {
+		t.Errorf("output mismatched! want %q, got %q", wantOut, got)
+	}
+	if got := sio.Err.String(); got != wantErr {
+		t.Errorf("menu mismatched! want %q, got %q", wantErr, got)
+	}
+	if rest, _ := io.ReadAll(in); string(rest) != "foo\n" {
+		t.Errorf("select reads beyond its line! remaining %q", rest)
+	}
+}
+
+func TestShellEnviron(t *testing.T) {
+	t.Setenv("TISH_TEST_VAR", "foobar")
+
+	sh, err := tish.New()
+	if err != nil {
+		t.Fatalf("fail to create shell: %s", err)
+	}
+	if str, err := sh.Resolve("TISH_TEST_VAR"); err != nil || len(str) != 1 || str[0] != "foobar" {
+		t.Errorf("process environment not imported! got %s (%v)", str, err)
+	}
+
+	var sio stdio
+	sh, err = tish.New(tish.WithStdout(&sio.Out), tish.WithStderr(&sio.Err))
+	if err != nil {
+		t.Fatalf("fail to create shell: %s", err)
+	}
+	script := `TISH_TEST_X=1; echo ${!TISH_TEST_*}; declare -p TISH_TEST_VAR; echo ${!PIPE*}`
+	if err := sh.Execute(context.TODO(), script, "test", nil); err != nil {
+		t.Fatalf("error while executing script: %s", err)
+	}
+	want := "TISH_TEST_VAR TISH_TEST_X\ndeclare -x TISH_TEST_VAR='foobar'\nPIPESTATUS\n"
+	if got := sio.Out.String(); got != want {
+		t.Errorf("output mismatched! want %q, got %q", want, got)
+	}
+	if str := sio.Err.String(); str != "" {
+		t.Errorf("unexpected error output: %q", str)
+	}
+
+	t.Setenv("OLDPWD", "/tmp")
+	t.Setenv("PWD", "/tmp")
+	sio.Out.Reset()
+	sh, err = tish.New(tish.WithStdout(&sio.Out), tish.WithStderr(&sio.Err))
+	if err != nil {
+		t.Fatalf("fail to create shell: %s", err)
+	}
+	script = `declare -p | grep -E '^declare -[a-z]+ (OLDPWD|PID|RANDOM|SECONDS)='; readonly; echo $?; cd /usr; env | grep -E '^(OLD)?PWD=' | sort`
+	if err := sh.Execute(context.TODO(), script, "test", nil); err != nil {
+		t.Fatalf("error while executing script: %s", err)
+	}
+	wd, _ := os.Getwd()
+	want = "declare -x OLDPWD='/tmp'\n0\nOLDPWD=" + wd + "\nPWD=/usr\n"
+	if got := sio.Out.String(); got != want {
+		t.Errorf("output mismatched! want %q, got %q", want, got)
+	}
+	if str := sio.Err.String(); str != "" {
+		t.Errorf("unexpected error output: %q", str)
+	}
+
+	sio.Out.Reset()
+	sh, err = tish.New(tish.WithCleanEnv(), tish.WithStdout(&sio.Out), tish.WithStderr(&sio.Err))
+	if err != nil {
+		t.Fatalf("fail to create shell: %s", err)
+	}
+	if str, err := sh.Resolve("TISH_TEST_VAR"); err == nil {
+		t.Errorf("process environment imported! got %s", str)
+	}
+	if err := sh.Execute(context.TODO(), `sh -c 'env | grep -v ^PWD= | wc -l'`, "test", nil); err != nil {
+		t.Fatalf("error while executing script: %s", err)
+	}
+	if got := strings.TrimSpace(sio.Out.String()); got != "0" {
+		t.Errorf("process environment given to command! got %s variables", got)
+	}
+}
+
+func TestShellTime(t *testing.T) {
+	data := []struct {
+		Script string
+		Want   *regexp.Regexp
+	}{
+		{
+			Script: `time -p echo foo`,
+			Want:   regexp.MustCompile(`^real \d+\.\d{2}\nuser \d+\.\d{2}\nsys \d+\.\d{2}\n$`),
+		},
+		{
+			Script: `time echo foo | cat`,
+			Want:   regexp.MustCompile(`^\nreal\t\d+m\d+\.\d{3}s\nuser\t\d+m\d+\.\d{3}s\nsys\t\d+m\d+\.\d{3}s\n$`),
+		},
+	}
+	for _, d := range data {
+		var sio stdio
+		sh, err := createShell(&sio.Out, &sio.Err)
+		if err != nil {
+			t.Fatalf("fail to create shell: %s", err)
+		}
+		if err := sh.Execute(context.TODO(), d.Script, "test", nil); err != nil {
+			t.Fatalf("%s: error while executing script: %s", d.Script, err)
+		}
+		if got := sio.Out.String(); got != "foo\n" {
+			t.Errorf("%s: output mismatched! want %q, got %q", d.Script, "foo\n", got)
+		}
+		if got := sio.Err.String(); !d.Want.MatchString(got) {
+			t.Errorf("%s: timing report mismatched! got %q", d.Script, got)
+		}
+	}
+}
+
+func TestShellContext(t *testing.T) {
+	data := []string{
+		"command sleep 5",
+		"eval sleep 5",
+		"env sleep 5",
+	}
+	for _, d := range data {
+		var sio stdio
+		sh, err := createShell(&sio.Out, &sio.Err)
+		if err != nil {
+			t.Fatalf("fail to create shell: %s", err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		now := time.Now()
+		sh.Execute(ctx, d, "test", nil)
+		cancel()
+		if elapsed := time.Since(now); elapsed > 2*time.Second {
+			t.Errorf("%s: command not stopped with the context of the shell (%s)", d, elapsed)
+		}
+	}
+}
+
 func createShell(out, err io.Writer) (*tish.Shell, error) {
 	options := []tish.ShellOption{
 		tish.WithStdout(out),
@@ -136,8 +804,8 @@ func createShell(out, err io.Writer) (*tish.Shell, error) {
 }
 
 type stdpair struct {
-	Out io.Writer
-	Err io.Writer
+	Out *writer
+	Err *writer
 }
 
 func Pair(out, err []string) stdpair {
@@ -147,25 +815,41 @@ func Pair(out, err []string) stdpair {
 	}
 }
 
+// Check reports the lines written that mismatched the lines expected
+func (p stdpair) Check(t *testing.T) {
+	t.Helper()
+	p.Out.check(t, "stdout")
+	p.Err.check(t, "stderr")
+}
+
 type writer struct {
-	curr int
+	buf  bytes.Buffer
 	want []string
 }
 
-func empty(values []string) io.Writer {
+func empty(values []string) *writer {
 	return &writer{
 		want: values,
 	}
 }
 
 func (w *writer) Write(b []byte) (int, error) {
-	if w.curr >= len(w.want) {
-		return 0, fmt.Errorf("too many values written")
+	return w.buf.Write(b)
+}
+
+func (w *writer) check(t *testing.T, name string) {
+	t.Helper()
+	var got []string
+	if str := strings.TrimRight(w.buf.String(), "\n"); str != "" {
+		got = strings.Split(str, "\n")
+	}
+	if len(got) != len(w.want) {
+		t.Errorf("%s: number of lines mismatched! want %q, got %q", name, w.want, got)
+		return
 	}
-	got := bytes.TrimSpace(b)
-	if w.want[w.curr] != string(got) {
-		return 0, fmt.Errorf("strings mismatched! want %s, got %s", w.want[w.curr], got)
+	for i := range got {
+		if str := strings.TrimSpace(got[i]); str != w.want[i] {
+			t.Errorf("%s: strings mismatched! want %q, got %q", name, w.want[i], str)
+		}
 	}
-	w.curr++
-	return len(b), nil
 }
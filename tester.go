@@ -0,0 +1,225 @@
+package tish
+
+import (
+	"fmt"
+
+	"github.com/midbel/tish/internal/token"
+	"github.com/midbel/tish/internal/words"
+)
+
+// parseTest builds the tester of the expression given as arguments to the
+// test and [ builtins following the rules of POSIX for 0 to 4 arguments and
+// using the precedence of !, -a and -o for longer expressions.
+func parseTest(args []string) (words.Tester, error) {
+	switch len(args) {
+	case 0:
+		return createSingleTest(""), nil
+	case 1:
+		return createSingleTest(args[0]), nil
+	case 2:
+		if args[0] == "!" {
+			return createNotTest(createSingleTest(args[1])), nil
+		}
+		if op, ok := unaryTestOp(args[0]); ok {
+			return createUnaryTest(op, args[1]), nil
+		}
+		return nil, fmt.Errorf("%s: unary operator expected", args[0])
+	case 3:
+		if op, ok := binaryTestOp(args[1]); ok {
+			return createBinaryTest(op, args[0], args[2]), nil
+		}
+		if args[1] == "-a" || args[1] == "-o" {
+			break
+		}
+		if args[0] == "!" {
+			t, err := parseTest(args[1:])
+			if err != nil {
+				return nil, err
+			}
+			return createNotTest(t), nil
+		}
+		if args[0] == "(" && args[2] == ")" {
+			return createSingleTest(args[1]), nil
+		}
+	case 4:
+		if args[0] == "!" {
+			t, err := parseTest(args[1:])
+			if err != nil {
+				return nil, err
+			}
+			return createNotTest(t), nil
+		}
+		if args[0] == "(" && args[3] == ")" {
+			return parseTest(args[1:3])
+		}
+	default:
+	}
+	p := testParser{
+		args: args,
+	}
+	t, err := p.parseOr()
+	if err == nil && !p.done() {
+		err = fmt.Errorf("%s: unexpected argument", p.curr())
+	}
+	return t, err
+}
+
+type testParser struct {
+	args []string
+	pos  int
+}
+
+func (p *testParser) parseOr() (words.Tester, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.curr() == "-o" {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = createConnective(token.Or, left, right)
+	}
+	return left, nil
+}
+
+func (p *testParser) parseAnd() (words.Tester, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.curr() == "-a" {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = createConnective(token.And, left, right)
+	}
+	return left, nil
+}
+
+func (p *testParser) parseNot() (words.Tester, error) {
+	if p.curr() != "!" || p.remain() == 1 {
+		return p.parsePrimary()
+	}
+	p.next()
+	t, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	return createNotTest(t), nil
+}
+
+func (p *testParser) parsePrimary() (words.Tester, error) {
+	if p.done() {
+		return nil, fmt.Errorf("argument expected")
+	}
+	if p.remain() >= 3 {
+		if op, ok := binaryTestOp(p.peek(1)); ok {
+			t := createBinaryTest(op, p.curr(), p.peek(2))
+			p.pos += 3
+			return t, nil
+		}
+	}
+	if p.curr() == "(" && p.remain() > 1 {
+		p.next()
+		t, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.curr() != ")" {
+			return nil, fmt.Errorf("')' expected")
+		}
+		p.next()
+		return t, nil
+	}
+	if op, ok := unaryTestOp(p.curr()); ok && p.remain() >= 2 {
+		t := createUnaryTest(op, p.peek(1))
+		p.pos += 2
+		return t, nil
+	}
+	t := createSingleTest(p.curr())
+	p.next()
+	return t, nil
+}
+
+func (p *testParser) curr() string {
+	return p.peek(0)
+}
+
+func (p *testParser) peek(n int) string {
+	if p.pos+n >= len(p.args) {
+		return ""
+	}
+	return p.args[p.pos+n]
+}
+
+func (p *testParser) next() {
+	p.pos++
+}
+
+func (p *testParser) remain() int {
+	return len(p.args) - p.pos
+}
+
+func (p *testParser) done() bool {
+	return p.pos >= len(p.args)
+}
+
+func unaryTestOp(str string) (rune, bool) {
+	op, ok := token.LookupTest(str)
+	return op, ok && token.IsUnaryTest(op)
+}
+
+func binaryTestOp(str string) (rune, bool) {
+	switch str {
+	case "=", "==":
+		return token.Eq, true
+	case "!=":
+		return token.Ne, true
+	case "<":
+		return token.Lt, true
+	case ">":
+		return token.Gt, true
+	default:
+	}
+	op, ok := token.LookupTest(str)
+	return op, ok && token.IsBinaryTest(op)
+}
+
+func createSingleTest(str string) words.Tester {
+	return createUnaryTest(token.StrNotEmpty, str)
+}
+
+func createUnaryTest(op rune, str string) words.Tester {
+	return words.UnaryTest{
+		Op:    op,
+		Right: words.CreateWord(str, true),
+	}
+}
+
+func createBinaryTest(op rune, left, right string) words.Tester {
+	return words.BinaryTest{
+		Op:    op,
+		Left:  words.CreateWord(left, true),
+		Right: words.CreateWord(right, true),
+	}
+}
+
+func createConnective(op rune, left, right words.Tester) words.Tester {
+	return words.BinaryTest{
+		Op:    op,
+		Left:  left,
+		Right: right,
+	}
+}
+
+func createNotTest(t words.Tester) words.Tester {
+	return words.UnaryTest{
+		Op:    token.Not,
+		Right: t,
+	}
+}
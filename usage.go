@@ -0,0 +1,19 @@
+package tish
+
+import (
+	"fmt"
+	"time"
+)
+
+type usage struct {
+	user time.Duration
+	sys  time.Duration
+}
+
+func formatDuration(d time.Duration) string {
+	var (
+		min = int(d.Minutes())
+		sec = d.Seconds() - float64(min*60)
+	)
+	return fmt.Sprintf("%dm%.3fs", min, sec)
+}
@@ -0,0 +1,9 @@
+//go:build windows || plan9
+
+package tish
+
+// getUsage can not give the cpu times on the other systems: time only reports
+// the elapsed time
+func getUsage() usage {
+	return usage{}
+}
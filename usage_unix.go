@@ -0,0 +1,23 @@
+//go:build !windows && !plan9
+
+package tish
+
+import (
+	"syscall"
+	"time"
+)
+
+// getUsage returns the cpu times consumed by the shell and by all its
+// terminated children
+func getUsage() usage {
+	var u usage
+	for _, who := range []int{syscall.RUSAGE_SELF, syscall.RUSAGE_CHILDREN} {
+		var ru syscall.Rusage
+		if err := syscall.Getrusage(who, &ru); err != nil {
+			continue
+		}
+		u.user += time.Duration(ru.Utime.Nano())
+		u.sys += time.Duration(ru.Stime.Nano())
+	}
+	return u
+}